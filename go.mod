@@ -4,4 +4,9 @@ go 1.24.11
 
 require github.com/gorilla/mux v1.8.1
 
-require github.com/mattn/go-sqlite3 v1.14.33
+require (
+	github.com/mattn/go-sqlite3 v1.14.33
+	go.etcd.io/bbolt v1.4.3
+)
+
+require golang.org/x/sys v0.29.0 // indirect
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Represents the proof that a state root was published to an external ledger.
+type AnchorReceipt struct {
+	// Kind of ledger, such as "file"
+	Anchor      string    `json:"anchor"`
+	Root        string    `json:"root"`
+	Size        int       `json:"size"`
+	CommittedAt int64     `json:"committed_at"`
+	AnchoredAt  time.Time `json:"anchored_at"`
+	// Ledger specific position of the entry, such as a transaction ID or log index
+	Location string `json:"location"`
+}
+
+// Publishes signed state commitments to an external append-only ledger,
+// such as a blockchain or a transparency log.
+type Anchor interface {
+	// Publishes the commitment and returns a receipt for it.
+	Publish(commitment Commitment, signed string, now time.Time) (AnchorReceipt, error)
+	// Checks that the ledger holds the entry the receipt points to.
+	Verify(receipt AnchorReceipt) error
+}
+
+// Represents one line of a file anchor.
+type fileAnchorEntry struct {
+	Index int `json:"index"`
+	// Hex SHA-256 of the previous line, empty for the first entry
+	Previous    string    `json:"previous"`
+	Root        string    `json:"root"`
+	Size        int       `json:"size"`
+	CommittedAt int64     `json:"committed_at"`
+	Commitment  string    `json:"commitment"`
+	AnchoredAt  time.Time `json:"anchored_at"`
+}
+
+// Implements Anchor as a local append-only file with one JSON entry per
+// line. Each entry includes the hash of the previous line, so rewriting an
+// entry breaks the chain. Intended for tests and single-node deployments.
+type FileAnchor struct {
+	mu   sync.Mutex
+	path string
+}
+
+// Creates a file anchor writing to the given path. The file is created on
+// first publication.
+func NewFileAnchor(path string) *FileAnchor {
+	return &FileAnchor{path: path}
+}
+
+// Reads every line of the file and checks the hash chain.
+// Must be called with the mutex held.
+func (a *FileAnchor) entries() ([]fileAnchorEntry, string, error) {
+	file, err := os.Open(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	entries := []fileAnchorEntry{}
+	previous := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry fileAnchorEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, "", fmt.Errorf("anchor entry %d: %w", len(entries), err)
+		}
+		if entry.Index != len(entries) || entry.Previous != previous {
+			return nil, "", fmt.Errorf("anchor entry %d does not follow the previous entry", len(entries))
+		}
+		sum := sha256.Sum256(scanner.Bytes())
+		previous = hex.EncodeToString(sum[:])
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", err
+	}
+	return entries, previous, nil
+}
+
+// Appends the commitment to the file.
+func (a *FileAnchor) Publish(commitment Commitment, signed string, now time.Time) (AnchorReceipt, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries, previous, err := a.entries()
+	if err != nil {
+		return AnchorReceipt{}, err
+	}
+	entry := fileAnchorEntry{
+		Index:       len(entries),
+		Previous:    previous,
+		Root:        commitment.Root,
+		Size:        commitment.Size,
+		CommittedAt: commitment.IssuedAt,
+		Commitment:  signed,
+		AnchoredAt:  now,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return AnchorReceipt{}, err
+	}
+
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return AnchorReceipt{}, err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return AnchorReceipt{}, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return AnchorReceipt{}, err
+	}
+	if err := file.Close(); err != nil {
+		return AnchorReceipt{}, err
+	}
+	return AnchorReceipt{
+		Anchor:      "file",
+		Root:        entry.Root,
+		Size:        entry.Size,
+		CommittedAt: entry.CommittedAt,
+		AnchoredAt:  entry.AnchoredAt,
+		Location:    strconv.Itoa(entry.Index),
+	}, nil
+}
+
+// Checks that the entry at the receipt's index has the receipt's root and
+// that the chain up to the last entry is intact.
+func (a *FileAnchor) Verify(receipt AnchorReceipt) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries, _, err := a.entries()
+	if err != nil {
+		return err
+	}
+	index, err := strconv.Atoi(receipt.Location)
+	if err != nil || receipt.Anchor != "file" || index < 0 || index >= len(entries) {
+		return fmt.Errorf("no anchor entry at %q", receipt.Location)
+	}
+	entry := entries[index]
+	if entry.Root != receipt.Root || entry.Size != receipt.Size || entry.CommittedAt != receipt.CommittedAt {
+		return fmt.Errorf("anchor entry %d does not match the receipt", index)
+	}
+	return nil
+}
+
+// Publishes the commitment with the configured anchor and stores the receipt.
+func AnchorHandler(state *AppState, commitment Commitment, signed string) (AnchorReceipt, error) {
+	if state.anchor == nil {
+		return AnchorReceipt{}, fmt.Errorf("no anchor configured")
+	}
+	receipt, err := state.anchor.Publish(commitment, signed, state.currentTime())
+	if err != nil {
+		return AnchorReceipt{}, err
+	}
+	state.AddAnchorReceipt(receipt)
+	return receipt, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileAnchorPublishAndVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "anchor.log")
+	anchor := NewFileAnchor(path)
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	receipts := []AnchorReceipt{}
+	for i, root := range []string{"aa", "bb"} {
+		receipt, err := anchor.Publish(Commitment{Root: root, Size: i + 1, IssuedAt: now.Unix()}, "signed", now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if receipt.Anchor != "file" || receipt.Root != root || receipt.Location != []string{"0", "1"}[i] {
+			t.Fatalf("unexpected receipt: %#v", receipt)
+		}
+		receipts = append(receipts, receipt)
+	}
+	for _, receipt := range receipts {
+		if err := anchor.Verify(receipt); err != nil {
+			t.Fatalf("receipt did not verify: %v", err)
+		}
+	}
+
+	forged := receipts[1]
+	forged.Root = "cc"
+	if err := anchor.Verify(forged); err == nil {
+		t.Fatal("expected a receipt with another root to fail")
+	}
+	missing := receipts[1]
+	missing.Location = "2"
+	if err := anchor.Verify(missing); err == nil {
+		t.Fatal("expected a receipt past the end to fail")
+	}
+
+	// Rewriting the first entry breaks the hash chain
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(data), `"root":"aa"`, `"root":"cc"`, 1)), 0600)
+	if err := anchor.Verify(receipts[1]); err == nil {
+		t.Fatal("expected a rewritten file to fail")
+	}
+}
+
+func TestStorageAnchorReceipts(t *testing.T) {
+	testStorageImplementations(t, "AnchorReceipts", func(t *testing.T, storage Storage) {
+		receipts, err := storage.AnchorReceipts()
+		if err != nil || len(receipts) != 0 {
+			t.Fatalf("expected no receipts, got %v/%v", receipts, err)
+		}
+
+		anchoredAt := time.Date(2024, time.March, 1, 12, 0, 0, 5, time.UTC)
+		for i, root := range []string{"aa", "bb"} {
+			receipt := AnchorReceipt{Anchor: "file", Root: root, Size: i, CommittedAt: 100, AnchoredAt: anchoredAt, Location: root}
+			if err := storage.AddAnchorReceipt(receipt); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		receipts, err = storage.AnchorReceipts()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(receipts) != 2 || receipts[0].Root != "aa" || receipts[1].Root != "bb" || !receipts[1].AnchoredAt.Equal(anchoredAt) {
+			t.Fatalf("expected the receipts in order, got %#v", receipts)
+		}
+		latest, ok, err := storage.LatestAnchorReceipt()
+		if err != nil || !ok || latest != receipts[1] {
+			t.Fatalf("expected the latest receipt %#v, got %#v/%v/%v", receipts[1], latest, ok, err)
+		}
+	})
+}
+
+func TestStorageLatestAnchorReceiptWithoutReceipts(t *testing.T) {
+	testStorageImplementations(t, "LatestAnchorReceiptWithoutReceipts", func(t *testing.T, storage Storage) {
+		if _, ok, err := storage.LatestAnchorReceipt(); ok || err != nil {
+			t.Fatalf("expected no receipt, got %v/%v", ok, err)
+		}
+	})
+}
+
+func TestRunCommitmentsSkipsAnchoredRoot(t *testing.T) {
+	state := NewAppState()
+	state.anchor = NewFileAnchor(filepath.Join(t.TempDir(), "anchor.log"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A cancelled context stops after the first commitment
+	RunCommitments(ctx, state, time.Hour)
+	RunCommitments(ctx, state, time.Hour)
+	if receipts, _ := state.storage.AnchorReceipts(); len(receipts) != 1 {
+		t.Fatalf("expected the unchanged root to be anchored once, got %#v", receipts)
+	}
+
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: time.Now().UTC()})
+	RunCommitments(ctx, state, time.Hour)
+	if receipts, _ := state.storage.AnchorReceipts(); len(receipts) != 2 {
+		t.Fatalf("expected the new root to be anchored, got %#v", receipts)
+	}
+}
+
+func TestEventLogStorageAnchorReceiptsPersist(t *testing.T) {
+	dir := t.TempDir()
+	storage1, err := NewEventLogStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to create event log storage: %v", err)
+	}
+	receipt := AnchorReceipt{Anchor: "file", Root: "aa", Size: 1, AnchoredAt: time.Unix(100, 0).UTC(), Location: "0"}
+	if err := storage1.AddAnchorReceipt(receipt); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	storage1.Close()
+
+	storage2, err := NewEventLogStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen event log storage: %v", err)
+	}
+	defer storage2.Close()
+	receipts, err := storage2.AnchorReceipts()
+	if err != nil || len(receipts) != 1 || receipts[0] != receipt {
+		t.Fatalf("expected the receipt after reopening, got %#v/%v", receipts, err)
+	}
+}
+
+// Tests that attestations carry the receipt of the latest anchored root
+func TestAttestationIncludesAnchorReceipt(t *testing.T) {
+	state := NewAppState()
+	state.anchor = NewFileAnchor(filepath.Join(t.TempDir(), "anchor.log"))
+	commitment, signed, err := CommitHandler(state)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	receipt, err := AnchorHandler(state, commitment, signed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	router := SetupRouterWithState(state)
+	req := httptest.NewRequest("GET", "/idt/alice/attestation", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var resp AttestationResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.AnchorReceipt == nil || resp.AnchorReceipt.Root != commitment.Root || resp.AnchorReceipt.Location != receipt.Location {
+		t.Fatalf("expected the anchor receipt, got %#v", resp.AnchorReceipt)
+	}
+	if err := state.anchor.Verify(*resp.AnchorReceipt); err != nil {
+		t.Fatalf("receipt did not verify: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// JWS type of score attestations.
+const attestationType = "idt-attestation+jwt"
+
+// How long an attestation stays valid. Scores change over time, so verifiers
+// should not rely on old attestations.
+const attestationLifetime = 24 * time.Hour
+
+// Describes how the scores of an attestation were computed.
+type ScoringParameters struct {
+	Mode                  ScoringMode `json:"mode"`
+	TreeDepth             int         `json:"tree_depth"`
+	BalanceWeightPerLayer float64     `json:"balance_weight_per_layer"`
+	PenaltyWeightPerLayer float64     `json:"penalty_weight_per_layer"`
+	MaxBalanceVouchers    int         `json:"max_balance_vouchers"`
+	DecayPerDay           uint64      `json:"decay_per_day"`
+	// PageRank damping factor, only set for pagerank scoring.
+	Damping float64 `json:"damping,omitempty"`
+}
+
+// Represents the claims of a signed score attestation.
+type Attestation struct {
+	Issuer    string            `json:"iss"`
+	Subject   string            `json:"sub"`
+	IssuedAt  int64             `json:"iat"`
+	ExpiresAt int64             `json:"exp"`
+	Balance   int64             `json:"balance"`
+	Penalty   uint64            `json:"penalty"`
+	Tier      Tier              `json:"tier"`
+	Scoring   ScoringParameters `json:"scoring"`
+}
+
+// Returns the parameters used by the scoring mode.
+func scoringParameters(mode ScoringMode) ScoringParameters {
+	params := ScoringParameters{
+		Mode:                  mode,
+		TreeDepth:             DefaultTreeDepth,
+		BalanceWeightPerLayer: balanceWeightPerLayer,
+		PenaltyWeightPerLayer: penaltyWeightPerLayer,
+		MaxBalanceVouchers:    maxBalanceVouchers,
+		DecayPerDay:           idtDecayPerDay,
+	}
+	if mode == ScoringModePageRank {
+		params.Damping = pageRankDamping
+	}
+	return params
+}
+
+// Issues a signed attestation of the user's identity information.
+// Returns the attestation claims and their compact JWS.
+func AttestationHandler(state *AppState, user string, scoring ScoringMode) (Attestation, string, error) {
+	info, err := ScoredIdtHandler(state, user, scoring)
+	if err != nil {
+		return Attestation{}, "", err
+	}
+	now := state.currentTime()
+	attestation := Attestation{
+		Issuer:    state.issuerURL(),
+		Subject:   info.User,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(attestationLifetime).Unix(),
+		Balance:   info.Balance,
+		Penalty:   info.Penalty,
+		Tier:      info.Tier,
+		Scoring:   scoringParameters(info.Scoring),
+	}
+	token, err := state.signer().Sign(attestation, attestationType)
+	if err != nil {
+		return Attestation{}, "", err
+	}
+	return attestation, token, nil
+}
+
+// Verifies an attestation against the issuer's published keys and checks
+// that it has not expired at `now`.
+func VerifyAttestation(token string, keys JWKSet, now time.Time) (Attestation, error) {
+	var attestation Attestation
+	if err := VerifyJWS(token, keys, attestationType, &attestation); err != nil {
+		return Attestation{}, err
+	}
+	if now.Unix() >= attestation.ExpiresAt {
+		return Attestation{}, fmt.Errorf("attestation expired at %s", time.Unix(attestation.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	}
+	return attestation, nil
+}
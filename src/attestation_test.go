@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttestationVerifies(t *testing.T) {
+	state := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	state.issuer = "https://idt.example"
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: now})
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: now})
+
+	claims, token, err := AttestationHandler(state, "bob", ScoringModeTree)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Subject != "bob" || claims.Balance != 10 || claims.Issuer != "https://idt.example" || claims.Scoring.Mode != ScoringModeTree {
+		t.Fatalf("unexpected claims: %#v", claims)
+	}
+
+	verified, err := VerifyAttestation(token, state.publicKeys(), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if verified != claims {
+		t.Fatalf("expected verified claims %#v, got %#v", claims, verified)
+	}
+
+	if _, err := VerifyAttestation(token, state.publicKeys(), now.Add(attestationLifetime)); err == nil {
+		t.Fatal("expected error for expired attestation")
+	}
+	if _, err := VerifyAttestation(token, NewAppState().publicKeys(), now); err == nil {
+		t.Fatal("expected error for another issuer's keys")
+	}
+}
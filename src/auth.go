@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a login challenge can be answered.
+const challengeLifetime = 5 * time.Minute
+
+// How long an access token stays valid.
+const accessTokenLifetime = 15 * time.Minute
+
+// JWS type of access tokens.
+const accessTokenType = "JWT"
+
+// How long a session token stays valid.
+const sessionLifetime = 24 * time.Hour
+
+// JWS type of session tokens. It differs from access tokens so that tokens
+// handed to other services cannot be used to act on the user's behalf here.
+const sessionTokenType = "idt-session+jwt"
+
+// Represents the registration of a user's ed25519 public key.
+// Only one key is stored per user; newer keys replace older ones.
+type KeyEvent struct {
+	User      string    `json:"user"`
+	PublicKey []byte    `json:"public_key"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Represents the claims of an access token.
+type TokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Balance   int64  `json:"balance"`
+	Penalty   uint64 `json:"penalty"`
+	Tier      Tier   `json:"tier"`
+}
+
+// Represents the claims of a session token.
+type SessionClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Maximum number of outstanding challenges or authorization codes of one
+// user. Issuing another one drops the user's oldest.
+const maxPendingPerUser = 5
+
+// Maximum number of outstanding challenges or authorization codes overall.
+const maxPending = 100_000
+
+type pendingEntry[T any] struct {
+	user      string
+	value     T
+	expiresAt time.Time
+}
+
+// Keeps short-lived single-use entries, such as login challenges, until
+// they are consumed or expire. They are not persisted. Every entry of a
+// store lives equally long, so entries expire in the order they were issued
+// and are dropped from the front of the queue. Entries are capped per user
+// and overall, so unauthenticated requests cannot grow the store unbounded.
+type pendingStore[T any] struct {
+	mu      sync.Mutex
+	entries map[string]pendingEntry[T]
+	// keys in issue order, including keys of consumed entries
+	order []string
+	// keys of each user's entries in issue order
+	users map[string][]string
+}
+
+// Stores the value for the user until `expiresAt` and returns its key.
+// Fails with ErrTooManyPending if the store is full.
+func (p *pendingStore[T]) issue(user string, value T, expiresAt time.Time, now time.Time) (string, IdentityError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.entries == nil {
+		p.entries = make(map[string]pendingEntry[T])
+		p.users = make(map[string][]string)
+	}
+	for len(p.order) > 0 {
+		entry, ok := p.entries[p.order[0]]
+		if ok && now.Before(entry.expiresAt) {
+			break
+		}
+		if ok {
+			p.remove(p.order[0])
+		}
+		p.order = p.order[1:]
+	}
+	if keys := p.users[user]; len(keys) >= maxPendingPerUser {
+		p.remove(keys[0])
+	}
+	if len(p.entries) >= maxPending {
+		return "", ErrTooManyPending
+	}
+	// Keys of consumed entries are only dropped once they reach the front
+	if len(p.order) > 2*len(p.entries)+maxPendingPerUser {
+		p.order = slices.DeleteFunc(p.order, func(key string) bool {
+			_, ok := p.entries[key]
+			return !ok
+		})
+	}
+
+	key := rand.Text()
+	p.entries[key] = pendingEntry[T]{user: user, value: value, expiresAt: expiresAt}
+	p.order = append(p.order, key)
+	p.users[user] = append(p.users[user], key)
+	return key, nil
+}
+
+// Removes and returns the entry. Reports false if it is unknown or has
+// expired. Each entry can be consumed once.
+func (p *pendingStore[T]) consume(key string, now time.Time) (pendingEntry[T], bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.entries[key]
+	if !ok {
+		return pendingEntry[T]{}, false
+	}
+	p.remove(key)
+	return entry, now.Before(entry.expiresAt)
+}
+
+// Must be called with the mutex held.
+func (p *pendingStore[T]) remove(key string) {
+	entry := p.entries[key]
+	delete(p.entries, key)
+	keys := slices.DeleteFunc(p.users[entry.user], func(k string) bool { return k == key })
+	if len(keys) == 0 {
+		delete(p.users, entry.user)
+	} else {
+		p.users[entry.user] = keys
+	}
+}
+
+// Issues a login challenge for the user.
+// Returns the challenge to sign and its expiry. Remote users log in on
+// their home instance, so they get no challenge.
+func ChallengeHandler(state *AppState, user string) (string, time.Time, IdentityError) {
+	if !validUserID(user) {
+		return "", time.Time{}, ErrInvalidUserID
+	}
+	if IsRemoteUser(user) {
+		return "", time.Time{}, ErrRemoteLogin
+	}
+	now := state.currentTime()
+	expiresAt := now.Add(challengeLifetime)
+	nonce, err := state.challenges.issue(user, struct{}{}, expiresAt, now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return nonce, expiresAt, nil
+}
+
+// Checks that the signature over the challenge was made with the user's key.
+// A user without a registered key registers the given public key on first
+// login. Once registered, the key cannot be replaced by logging in.
+// Remote users cannot log in, since their key is registered on their home
+// instance.
+func verifyChallenge(state *AppState, user string, nonce string, signature []byte, publicKey []byte) IdentityError {
+	if !validUserID(user) {
+		return ErrInvalidUserID
+	}
+	if IsRemoteUser(user) {
+		return ErrRemoteLogin
+	}
+	now := state.currentTime()
+	// The challenge is used up even if it was issued to another user
+	challenge, ok := state.challenges.consume(nonce, now)
+	if !ok || challenge.user != user {
+		return ErrInvalidChallenge
+	}
+	key, err := state.KeyRecord(user)
+	if err != nil {
+		return err
+	}
+	registered := len(key.PublicKey) > 0
+	if !registered {
+		// Only new users may register a key on login. Anyone could otherwise
+		// claim an existing identity that has not logged in yet.
+		known, err := hasRecords(state, user)
+		if err != nil {
+			return err
+		}
+		if known {
+			return ErrKeyNotRegistered
+		}
+		key.PublicKey = publicKey
+	}
+	if len(key.PublicKey) != ed25519.PublicKeySize {
+		return ErrInvalidSignature
+	}
+	if registered && len(publicKey) > 0 && !bytes.Equal(publicKey, key.PublicKey) {
+		return ErrInvalidSignature
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key.PublicKey), []byte(nonce), signature) {
+		return ErrInvalidSignature
+	}
+	if !registered {
+		state.SetKey(KeyEvent{User: user, PublicKey: publicKey, Timestamp: now})
+	}
+	return nil
+}
+
+// Reads the admin token from a file, ignoring surrounding whitespace.
+func LoadAdminToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("admin token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// Reports whether the token is the configured admin token. No token is
+// accepted if none is configured.
+func checkAdminToken(state *AppState, token string) bool {
+	return state.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(state.adminToken)) == 1
+}
+
+// Reports whether the storage holds vouches, a proof or penalties of the user.
+func hasRecords(state *AppState, user string) (bool, error) {
+	from, err := state.storage.UserVouchesFrom(user)
+	if err != nil || len(from) > 0 {
+		return len(from) > 0, err
+	}
+	to, err := state.storage.UserVouchesTo(user)
+	if err != nil || len(to) > 0 {
+		return len(to) > 0, err
+	}
+	penalties, err := state.storage.Penalties(user)
+	if err != nil || len(penalties) > 0 {
+		return len(penalties) > 0, err
+	}
+	proof, err := state.storage.ProofRecord(user)
+	if err != nil {
+		return false, err
+	}
+	return !proof.Timestamp.IsZero(), nil
+}
+
+// Issues an access token after the user signs a challenge with their key.
+// The token carries the user's current scores as claims.
+func TokenHandler(state *AppState, user string, nonce string, signature []byte, publicKey []byte) (string, TokenClaims, IdentityError) {
+	if err := verifyChallenge(state, user, nonce, signature, publicKey); err != nil {
+		return "", TokenClaims{}, err
+	}
+	return issueAccessToken(state, user, "")
+}
+
+// Issues an access token with the user's current scores as claims.
+// The audience is optional.
+func issueAccessToken(state *AppState, user string, audience string) (string, TokenClaims, IdentityError) {
+	info, err := IdtHandler(state, user)
+	if err != nil {
+		return "", TokenClaims{}, err
+	}
+	now := state.currentTime()
+	claims := TokenClaims{
+		Issuer:    state.issuerURL(),
+		Subject:   user,
+		Audience:  audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(accessTokenLifetime).Unix(),
+		Balance:   info.Balance,
+		Penalty:   info.Penalty,
+		Tier:      info.Tier,
+	}
+	token, err := state.signer().Sign(claims, accessTokenType)
+	if err != nil {
+		return "", TokenClaims{}, err
+	}
+	return token, claims, nil
+}
+
+// Verifies an access token against the issuer's published keys and checks
+// that it has not expired at `now`.
+func VerifyToken(token string, keys JWKSet, now time.Time) (TokenClaims, error) {
+	var claims TokenClaims
+	if err := VerifyJWS(token, keys, accessTokenType, &claims); err != nil {
+		return TokenClaims{}, err
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return TokenClaims{}, fmt.Errorf("token expired at %s", time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	}
+	return claims, nil
+}
+
+// Issues a session token after the user signs a challenge with their
+// registered key. Unlike TokenHandler, it never registers a key.
+func VerifyHandler(state *AppState, user string, nonce string, signature []byte) (string, SessionClaims, IdentityError) {
+	if err := verifyChallenge(state, user, nonce, signature, nil); err != nil {
+		return "", SessionClaims{}, err
+	}
+	now := state.currentTime()
+	claims := SessionClaims{
+		Issuer:    state.issuerURL(),
+		Subject:   user,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(sessionLifetime).Unix(),
+	}
+	token, err := state.signer().Sign(claims, sessionTokenType)
+	if err != nil {
+		return "", SessionClaims{}, err
+	}
+	return token, claims, nil
+}
+
+// Returns the user a session token was issued to.
+func SessionUser(state *AppState, token string) (string, IdentityError) {
+	var claims SessionClaims
+	if err := VerifyJWS(token, state.publicKeys(), sessionTokenType, &claims); err != nil {
+		return "", ErrInvalidToken
+	}
+	if state.currentTime().Unix() >= claims.ExpiresAt {
+		return "", ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
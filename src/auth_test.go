@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Logs in as the user by signing a fresh challenge with the private key.
+func loginWithKey(t *testing.T, state *AppState, user string, private ed25519.PrivateKey, publicKey []byte) (string, IdentityError) {
+	t.Helper()
+	nonce, _, _ := ChallengeHandler(state, user)
+	token, _, err := TokenHandler(state, user, nonce, ed25519.Sign(private, []byte(nonce)), publicKey)
+	return token, err
+}
+
+func TestTokenRegistersKeyOnFirstLogin(t *testing.T) {
+	state := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	public, private, _ := ed25519.GenerateKey(nil)
+
+	if _, err := loginWithKey(t, state, "alice", private, public); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key, _ := state.KeyRecord("alice")
+	if !public.Equal(ed25519.PublicKey(key.PublicKey)) {
+		t.Fatalf("expected the key to be registered, got %#v", key)
+	}
+
+	// Later logins use the registered key without sending it again
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: now})
+	token, err := loginWithKey(t, state, "alice", private, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claims, verifyErr := VerifyToken(token, state.publicKeys(), now)
+	if verifyErr != nil {
+		t.Fatalf("token did not verify: %v", verifyErr)
+	}
+	if claims.Subject != "alice" || claims.Balance != 100 || claims.Tier != TierModeratorVerified {
+		t.Fatalf("unexpected claims: %#v", claims)
+	}
+	if _, verifyErr := VerifyToken(token, state.publicKeys(), now.Add(accessTokenLifetime)); verifyErr == nil {
+		t.Fatal("expected error for expired token")
+	}
+}
+
+func TestRemoteUsersCannotLogIn(t *testing.T) {
+	state := NewAppState()
+	if _, _, err := ChallengeHandler(state, "alice@peer"); err != ErrRemoteLogin {
+		t.Fatalf("expected %v for a challenge, got %v", ErrRemoteLogin, err)
+	}
+
+	// A challenge issued to the local user cannot be answered for the remote one
+	public, private, _ := ed25519.GenerateKey(nil)
+	nonce, _, _ := ChallengeHandler(state, "alice")
+	if _, _, err := TokenHandler(state, "alice@peer", nonce, ed25519.Sign(private, []byte(nonce)), public); err != ErrRemoteLogin {
+		t.Fatalf("expected %v for a token, got %v", ErrRemoteLogin, err)
+	}
+	if key, _ := state.KeyRecord("alice@peer"); len(key.PublicKey) > 0 {
+		t.Fatalf("expected no key to be registered, got %#v", key)
+	}
+}
+
+func TestPendingStoreCapsEntries(t *testing.T) {
+	var store pendingStore[struct{}]
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	expiresAt := now.Add(challengeLifetime)
+
+	// A user's oldest entry makes room for a new one
+	keys := []string{}
+	for range maxPendingPerUser + 1 {
+		key, err := store.issue("alice", struct{}{}, expiresAt, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	if _, ok := store.consume(keys[0], now); ok {
+		t.Fatal("expected the oldest entry to be dropped")
+	}
+	if entry, ok := store.consume(keys[1], now); !ok || entry.user != "alice" {
+		t.Fatalf("expected the second entry to be kept, got %#v", entry)
+	}
+
+	// The store refuses entries once full, until they expire
+	for i := len(store.entries); i < maxPending; i++ {
+		if _, err := store.issue(strconv.Itoa(i), struct{}{}, expiresAt, now); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := store.issue("bob", struct{}{}, expiresAt, now); err != ErrTooManyPending {
+		t.Fatalf("expected %v, got %v", ErrTooManyPending, err)
+	}
+	later := expiresAt.Add(time.Second)
+	if _, err := store.issue("bob", struct{}{}, later.Add(challengeLifetime), later); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(store.entries) != 1 || len(store.order) != 1 || len(store.users) != 1 {
+		t.Fatalf("expected expired entries to be dropped, got %d entries", len(store.entries))
+	}
+}
+
+func TestTokenDoesNotRegisterKeysOfExistingUsers(t *testing.T) {
+	state := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: now})
+	state.AddVouch(VouchEvent{From: "bob", To: "carol", Timestamp: now})
+	state.AddPenalty(PenaltyEvent{User: "dave", Amount: 10, Timestamp: now})
+	public, private, _ := ed25519.GenerateKey(nil)
+
+	for _, user := range []string{"alice", "bob", "carol", "dave"} {
+		if _, err := loginWithKey(t, state, user, private, public); err != ErrKeyNotRegistered {
+			t.Fatalf("expected %v for existing user %s, got %v", ErrKeyNotRegistered, user, err)
+		}
+		if key, _ := state.KeyRecord(user); len(key.PublicKey) > 0 {
+			t.Fatalf("expected no key for %s, got %#v", user, key)
+		}
+	}
+
+	// An operator registers the key, after which the user can log in
+	state.SetKey(KeyEvent{User: "alice", PublicKey: public, Timestamp: now})
+	if _, err := loginWithKey(t, state, "alice", private, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTokenRejectsOtherKeys(t *testing.T) {
+	state := NewAppState()
+	public, private, _ := ed25519.GenerateKey(nil)
+	otherPublic, otherPrivate, _ := ed25519.GenerateKey(nil)
+	if _, err := loginWithKey(t, state, "alice", private, public); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := loginWithKey(t, state, "alice", otherPrivate, otherPublic); err != ErrInvalidSignature {
+		t.Fatalf("expected %v for another key, got %v", ErrInvalidSignature, err)
+	}
+	if _, err := loginWithKey(t, state, "alice", otherPrivate, nil); err != ErrInvalidSignature {
+		t.Fatalf("expected %v for a signature by another key, got %v", ErrInvalidSignature, err)
+	}
+	if _, err := loginWithKey(t, state, "bob", private, nil); err != ErrInvalidSignature {
+		t.Fatalf("expected %v without a key, got %v", ErrInvalidSignature, err)
+	}
+}
+
+func TestChallengeIsSingleUseAndExpires(t *testing.T) {
+	state := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	public, private, _ := ed25519.GenerateKey(nil)
+
+	nonce, _, _ := ChallengeHandler(state, "alice")
+	signature := ed25519.Sign(private, []byte(nonce))
+	if _, _, err := TokenHandler(state, "bob", nonce, signature, public); err != ErrInvalidChallenge {
+		t.Fatalf("expected %v for another user's challenge, got %v", ErrInvalidChallenge, err)
+	}
+	if _, _, err := TokenHandler(state, "alice", nonce, signature, public); err != ErrInvalidChallenge {
+		t.Fatalf("expected %v for a used challenge, got %v", ErrInvalidChallenge, err)
+	}
+
+	nonce, _, _ = ChallengeHandler(state, "alice")
+	now = now.Add(challengeLifetime)
+	if _, _, err := TokenHandler(state, "alice", nonce, ed25519.Sign(private, []byte(nonce)), public); err != ErrInvalidChallenge {
+		t.Fatalf("expected %v for an expired challenge, got %v", ErrInvalidChallenge, err)
+	}
+}
+
+func TestVerifyIssuesSessionForRegisteredKey(t *testing.T) {
+	state := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	_, private, _ := ed25519.GenerateKey(nil)
+
+	// Without a registered key there is nothing to verify against
+	nonce, _, _ := ChallengeHandler(state, "alice")
+	if _, _, err := VerifyHandler(state, "alice", nonce, ed25519.Sign(private, []byte(nonce))); err != ErrInvalidSignature {
+		t.Fatalf("expected %v without a registered key, got %v", ErrInvalidSignature, err)
+	}
+	if key, _ := state.KeyRecord("alice"); len(key.PublicKey) != 0 {
+		t.Fatalf("expected no key to be registered, got %#v", key)
+	}
+
+	state.SetKey(KeyEvent{User: "alice", PublicKey: private.Public().(ed25519.PublicKey), Timestamp: now})
+	nonce, _, _ = ChallengeHandler(state, "alice")
+	session, claims, err := VerifyHandler(state, "alice", nonce, ed25519.Sign(private, []byte(nonce)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Subject != "alice" || claims.ExpiresAt != now.Add(sessionLifetime).Unix() {
+		t.Fatalf("unexpected claims: %#v", claims)
+	}
+	if user, err := SessionUser(state, session); err != nil || user != "alice" {
+		t.Fatalf("expected session of alice, got %q/%v", user, err)
+	}
+
+	// Access tokens are not sessions, and sessions expire
+	token, _ := loginWithKey(t, state, "alice", private, nil)
+	if _, err := SessionUser(state, token); err != ErrInvalidToken {
+		t.Fatalf("expected %v for an access token, got %v", ErrInvalidToken, err)
+	}
+	now = now.Add(sessionLifetime)
+	if _, err := SessionUser(state, session); err != ErrInvalidToken {
+		t.Fatalf("expected %v for an expired session, got %v", ErrInvalidToken, err)
+	}
+}
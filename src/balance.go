@@ -65,18 +65,18 @@ func Penalty(state *AppState, user string, tree *VouchTreeNode, now *time.Time)
 		log.Fatalf("Warning: provided tree root user %v does not match target user %v", tree.User, user)
 	}
 
+	if now == nil {
+		currentTime := state.currentTime()
+		now = &currentTime
+	}
+
 	if tree == nil {
 		// Builds a vouch graph and outgoing user's tree of default depth.
-		tree = OutgoingTree(state, user, DefaultTreeDepth)
+		tree = OutgoingTreeAt(state, user, DefaultTreeDepth, *now)
 	}
 
 	// NOTE: Do not check for nil state or tree, allow panic in that case.
 
-	if now == nil {
-		currentTime := state.currentTime()
-		now = &currentTime
-	}
-
 	penaltySums := make(map[string]uint64)
 	basePenalty := func(u string) uint64 {
 		if sum, ok := penaltySums[u]; ok {
@@ -97,7 +97,8 @@ func Penalty(state *AppState, user string, tree *VouchTreeNode, now *time.Time)
 			if edge.Peer == nil {
 				continue
 			}
-			total += uint64(penaltyWeightPerLayer * float64(results[edge.Peer]))
+			// Penalty propagates to the voucher in proportion to the vouch weight
+			total += uint64(penaltyWeightPerLayer * float64(scaleByWeight(results[edge.Peer], edge.Event)))
 		}
 		return total
 	})
@@ -105,6 +106,22 @@ func Penalty(state *AppState, user string, tree *VouchTreeNode, now *time.Time)
 	return results[tree]
 }
 
+// Computes the user's own balance before vouches: the decayed proof balance
+// less the user's aggregated penalty.
+func ownBalance(state *AppState, user string, now time.Time) int64 {
+	sum := int64(0)
+	proof, err := state.ProofRecord(user)
+	if err != nil {
+		log.Printf("Error getting proof record for user %s: %v", user, err)
+	} else {
+		sum = int64(DecayedAmount(proof.Balance, proof.Timestamp, now))
+	}
+
+	// TODO: rebuilds the outgoing tree for user each time; could be optimized by caching
+	outgoingTree := OutgoingTreeAt(state, user, DefaultTreeDepth, now)
+	return sum - int64(Penalty(state, user, outgoingTree, &now))
+}
+
 // Computes the aggregated balance for a user.
 // If incoming tree is not provided, it is built from the vouch graph.
 // Optional parameter `now` allows to compute the balance at a specific point
@@ -115,34 +132,24 @@ func Balance(state *AppState, user string, incomingTree *VouchTreeNode, now *tim
 		log.Fatalf("Warning: provided tree root user %v does not match target user %v", incomingTree.User, user)
 	}
 
+	if now == nil {
+		currentTime := state.currentTime()
+		now = &currentTime
+	}
+
 	if incomingTree == nil {
 		// Builds a vouch graph and incoming user's tree of default depth.
-		incomingTree = IncomingTree(state, user, DefaultTreeDepth)
+		incomingTree = IncomingTreeAt(state, user, DefaultTreeDepth, *now)
 	}
 
 	// NOTE: Do not check for nil state or tree, allow panic in that case.
 
-	if now == nil {
-		currentTime := state.currentTime()
-		now = &currentTime
-	}
-
 	balances := make(map[string]int64)
 	baseBalance := func(u string) int64 {
 		if sum, ok := balances[u]; ok {
 			return sum
 		}
-		sum := int64(0)
-		proof, err := state.ProofRecord(u)
-		if err != nil {
-			log.Printf("Error getting proof record for user %s: %v", u, err)
-		} else {
-			sum = int64(DecayedAmount(proof.Balance, proof.Timestamp, *now))
-		}
-
-		// TODO: rebuilds the outgoing tree for u each time; could be optimized by caching
-		outgoingTree := OutgoingTree(state, u, DefaultTreeDepth)
-		sum -= int64(Penalty(state, u, outgoingTree, now))
+		sum := ownBalance(state, u, *now)
 		balances[u] = sum
 		return sum
 	}
@@ -159,7 +166,9 @@ func Balance(state *AppState, user string, incomingTree *VouchTreeNode, now *tim
 			if results[edge.Peer] <= 0 {
 				continue
 			}
-			heap.Push(&peerBalances, results[edge.Peer])
+			// Peer's balance contributes in proportion to the vouch weight,
+			// less the trust discount for vouchers of other instances
+			heap.Push(&peerBalances, discountRemote(state, scaleByWeight(results[edge.Peer], edge.Event), edge.Event))
 		}
 		limit := min(peerBalances.Len(), maxBalanceVouchers)
 		for i := 0; i < limit; i++ {
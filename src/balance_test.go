@@ -338,3 +338,59 @@ func TestBalanceIgnoresNegativeVoucherBalance(t *testing.T) {
 		t.Fatalf("expected balance 0, got %d", got)
 	}
 }
+
+func TestBalanceScalesByVouchWeight(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return timestamp }
+
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp, Weight: 50})
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+
+	got := Balance(state, "bob", nil, nil)
+	// 10% of alice's 100 balance at 50% weight
+	if got != 5 {
+		t.Fatalf("expected balance 5, got %d", got)
+	}
+}
+
+func TestPenaltyScalesByVouchWeight(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return timestamp }
+
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp, Weight: 20})
+	state.AddVouch(VouchEvent{From: "alice", To: "carol", Timestamp: timestamp})
+	state.AddPenalty(PenaltyEvent{User: "bob", Amount: 100, Timestamp: timestamp})
+	state.AddPenalty(PenaltyEvent{User: "carol", Amount: 100, Timestamp: timestamp})
+
+	got := Penalty(state, "alice", nil, nil)
+	// 10% of bob's 100 penalty at 20% weight + 10% of carol's 100 penalty at full weight
+	if got != 12 {
+		t.Fatalf("expected penalty 12, got %d", got)
+	}
+}
+
+func TestBalanceIgnoresExpiredVouches(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	expiresAt := timestamp.Add(time.Hour)
+
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp, ExpiresAt: expiresAt})
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+	state.AddPenalty(PenaltyEvent{User: "bob", Amount: 100, Timestamp: timestamp})
+
+	before := timestamp.Add(time.Minute)
+	if got := Balance(state, "bob", nil, &before); got != -91 {
+		t.Fatalf("expected balance -91 before expiry, got %d", got)
+	}
+	if got := Penalty(state, "alice", nil, &before); got != 10 {
+		t.Fatalf("expected penalty 10 before expiry, got %d", got)
+	}
+	if got := Balance(state, "bob", nil, &expiresAt); got != -100 {
+		t.Fatalf("expected balance -100 after expiry, got %d", got)
+	}
+	if got := Penalty(state, "alice", nil, &expiresAt); got != 0 {
+		t.Fatalf("expected penalty 0 after expiry, got %d", got)
+	}
+}
@@ -0,0 +1,692 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Storage backend selected on the command line.
+type storageConfig struct {
+	kind string
+	path string
+}
+
+// Registers the storage selection flags.
+func (c *storageConfig) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.kind, "storage", "memory", "storage backend: memory, sqlite, bolt or eventlog")
+	flags.StringVar(&c.path, "db", "identity.db", "database file for sqlite and bolt, directory for eventlog")
+}
+
+// Opens the configured storage backend.
+func (c *storageConfig) open() (Storage, error) {
+	return OpenStorage(c.kind, c.path)
+}
+
+// Opens a storage backend by name.
+func OpenStorage(kind string, path string) (Storage, error) {
+	switch kind {
+	case "memory":
+		return NewMemoryStorage(), nil
+	case "sqlite":
+		return NewSQLiteStorage(path)
+	case "bolt":
+		return NewBoltStorage(path)
+	case "eventlog":
+		return NewEventLogStorage(path)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", kind)
+}
+
+// Represents a command line subcommand.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+func commands() []command {
+	return []command{
+		{name: "serve", usage: "serve [-port N] [-scoring tree|pagerank] [-vouch-ttl DURATION] [-max-vouches N] [-balance-per-vouch N] [-vouch-rate N -vouch-rate-window DURATION] [-tier-basic N] [-tier-trusted N] [-tier-max-penalty N] [-signing-key FILE] [-id-token-key FILE] [-issuer URL] [-oidc-client ID=REDIRECT_URI[,...]]... [-leader | -follow URL] [-instance NAME] [-peer NAME=URL#kid=KEY_ID]... [-peer-sync-interval DURATION] [-remote-discount PERCENT] [-commit-interval DURATION] [-anchor-file FILE] [-webhook EVENT[,...]=URL]... [-webhook-secret FILE] [-webhook-balance-threshold N]... [-admin-token FILE]", run: serveCommand},
+		{name: "user", usage: "user show <id> | user set-key <id> <public key>", run: userCommand},
+		{name: "tree", usage: "tree <id> [-direction in|out] [-depth N]", run: treeCommand},
+		{name: "graph", usage: "graph [-format dot|graphml|json] [-user id [-direction in|out] [-depth N]]", run: graphCommand},
+		{name: "recompute", usage: "recompute [-scoring tree|pagerank]", run: recomputeCommand},
+		{name: "export", usage: "export [-format jsonl|csv] [-o file]", run: exportCommand},
+		{name: "import", usage: "import [-format jsonl|csv] [file]", run: importCommand},
+		{name: "migrate", usage: "migrate", run: migrateCommand},
+	}
+}
+
+// Runs the subcommand named by the first argument.
+func RunCommand(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%s", commandsUsage())
+	}
+	for _, cmd := range commands() {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:], stdin, stdout)
+		}
+	}
+	return fmt.Errorf("unknown command %q\n%s", args[0], commandsUsage())
+}
+
+func commandsUsage() string {
+	usage := "Usage:"
+	for _, cmd := range commands() {
+		usage += "\n  " + cmd.usage
+	}
+	usage += "\nAll commands accept -storage and -db to select the storage backend."
+	return usage
+}
+
+// Parses flags that may appear before, between or after positional arguments
+// and returns the positional arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// Opens the configured storage wrapped in an application state.
+func openAppState(config storageConfig) (*AppState, error) {
+	storage, err := config.open()
+	if err != nil {
+		return nil, err
+	}
+	return NewAppStateWithStorage(storage), nil
+}
+
+// Options of the serve command.
+type serveConfig struct {
+	storage     storageConfig
+	port        int
+	scoringName string
+	// scoring mode parsed by validate
+	scoring  ScoringMode
+	vouchTTL time.Duration
+	limits   VouchLimits
+	tiers    TierThresholds
+	// file of the signing key, empty for an ephemeral key
+	signingKeyPath string
+	// file of the RSA key signing ID tokens, empty for an ephemeral key
+	idTokenKeyPath string
+	issuer         string
+	oidcClients    map[string]OIDCClient
+	leader         bool
+	// base URL of the leader of a follower
+	follow            string
+	federation        FederationConfig
+	commitInterval    time.Duration
+	anchorFile        string
+	webhooks          WebhookConfig
+	webhookSecretPath string
+	// file of the admin token, admin endpoints are disabled if empty
+	adminTokenPath string
+	adminToken     string
+}
+
+// Registers the serve flags.
+func (c *serveConfig) register(flags *flag.FlagSet) {
+	c.storage.register(flags)
+	flags.IntVar(&c.port, "port", PORT, "port to listen on")
+	flags.StringVar(&c.scoringName, "scoring", string(ScoringModeTree), "default scoring mode: tree or pagerank")
+	flags.DurationVar(&c.vouchTTL, "vouch-ttl", 0, "lifetime of new and renewed vouches, 0 for no expiry")
+	flags.IntVar(&c.limits.MaxActive, "max-vouches", 0, "maximum active outgoing vouches per user, 0 for unlimited")
+	flags.Uint64Var(&c.limits.BalancePerVouch, "balance-per-vouch", 0, "balance that allows one more active vouch above -max-vouches, 0 to disable")
+	flags.IntVar(&c.limits.MaxPerWindow, "vouch-rate", 0, "maximum vouches per user within -vouch-rate-window, 0 for unlimited")
+	flags.DurationVar(&c.limits.RateWindow, "vouch-rate-window", time.Hour, "window of the -vouch-rate limit")
+	c.tiers = defaultTierThresholds
+	flags.Int64Var(&c.tiers.Basic, "tier-basic", c.tiers.Basic, "minimum balance of the basic tier")
+	flags.Int64Var(&c.tiers.Trusted, "tier-trusted", c.tiers.Trusted, "minimum balance of the trusted tier")
+	flags.Uint64Var(&c.tiers.MaxPenalty, "tier-max-penalty", c.tiers.MaxPenalty, "maximum penalty of the trusted and moderator-verified tiers")
+	flags.StringVar(&c.signingKeyPath, "signing-key", "", "file with the key signing attestations and tokens, created if missing; ephemeral key if empty")
+	flags.StringVar(&c.idTokenKeyPath, "id-token-key", "", "file with the RSA key signing OpenID Connect ID tokens, created if missing; ephemeral key if empty")
+	flags.StringVar(&c.issuer, "issuer", "", "public URL of the service in signed documents (default http://localhost:PORT)")
+	c.oidcClients = make(map[string]OIDCClient)
+	flags.Func("oidc-client", "OpenID Connect client as id=redirect_uri[,redirect_uri...], may be repeated", func(value string) error {
+		client, err := ParseOIDCClient(value)
+		if err != nil {
+			return err
+		}
+		c.oidcClients[client.ID] = client
+		return nil
+	})
+	flags.BoolVar(&c.leader, "leader", false, "serve the event stream to followers at /replication/events")
+	flags.StringVar(&c.follow, "follow", "", "base URL of a leader to replicate from; the node is read-only")
+	c.federation = FederationConfig{RemoteDiscount: defaultRemoteDiscount}
+	flags.StringVar(&c.federation.Instance, "instance", "", "name of this instance for federation; serves signed events at /federation/events")
+	flags.Func("peer", "instance to import vouches and proofs from as name=url#kid=key_id, pinning the ID of the key it signs with, may be repeated", func(value string) error {
+		peer, err := ParseFederationPeer(value)
+		if err != nil {
+			return err
+		}
+		c.federation.Peers = append(c.federation.Peers, peer)
+		return nil
+	})
+	flags.DurationVar(&c.federation.SyncInterval, "peer-sync-interval", defaultPeerSyncInterval, "how often peers are pulled")
+	flags.Uint64Var(&c.federation.RemoteDiscount, "remote-discount", c.federation.RemoteDiscount, "percentage of a remote voucher's balance withheld, 0 to 100")
+	flags.DurationVar(&c.commitInterval, "commit-interval", defaultCommitInterval, "how often a signed Merkle root of the state is published")
+	flags.StringVar(&c.anchorFile, "anchor-file", "", "append-only file the state roots are anchored to; not anchored if empty")
+	flags.Func("webhook", "URL notified of vouch, proof, penalty, renew, slash, tier or balance events as event[,event...]=url, may be repeated", func(value string) error {
+		subscription, err := ParseWebhookSubscription(value)
+		if err != nil {
+			return err
+		}
+		c.webhooks.Subscriptions = append(c.webhooks.Subscriptions, subscription)
+		return nil
+	})
+	flags.StringVar(&c.webhookSecretPath, "webhook-secret", "", "file with the secret webhook deliveries are signed with, required with -webhook")
+	flags.Func("webhook-balance-threshold", "balance whose crossing triggers balance webhooks, may be repeated", func(value string) error {
+		threshold, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		c.webhooks.BalanceThresholds = append(c.webhooks.BalanceThresholds, threshold)
+		return nil
+	})
+	flags.StringVar(&c.adminTokenPath, "admin-token", "", "file with the bearer token of the /admin endpoints; they are disabled if empty")
+}
+
+// Rejects invalid or conflicting options and loads the webhook secret and
+// the admin token.
+func (c *serveConfig) validate() error {
+	scoring, idtErr := ParseScoringMode(c.scoringName, ScoringModeTree)
+	if idtErr != nil {
+		return idtErr
+	}
+	c.scoring = scoring
+	if c.leader && c.follow != "" {
+		return fmt.Errorf("-leader and -follow cannot be combined")
+	}
+	if c.follow != "" && len(c.federation.Peers) > 0 {
+		return fmt.Errorf("followers receive peer events from their leader, -peer cannot be combined with -follow")
+	}
+	if strings.Contains(c.federation.Instance, "@") {
+		return fmt.Errorf("invalid instance name %q", c.federation.Instance)
+	}
+	if c.federation.RemoteDiscount > 100 {
+		return fmt.Errorf("-remote-discount must be between 0 and 100")
+	}
+	if len(c.webhooks.Subscriptions) > 0 && c.follow != "" {
+		return fmt.Errorf("followers accept no writes, -webhook cannot be combined with -follow")
+	}
+	if len(c.webhooks.Subscriptions) > 0 {
+		if c.webhookSecretPath == "" {
+			return fmt.Errorf("-webhook requires -webhook-secret")
+		}
+		secret, err := LoadWebhookSecret(c.webhookSecretPath)
+		if err != nil {
+			return err
+		}
+		c.webhooks.Secret = secret
+	}
+	if c.adminTokenPath != "" {
+		token, err := LoadAdminToken(c.adminTokenPath)
+		if err != nil {
+			return err
+		}
+		c.adminToken = token
+	}
+	return nil
+}
+
+// Applies the scoring, vouching, federation and admin options to the state.
+func (c *serveConfig) configureState(state *AppState) {
+	state.scoring = c.scoring
+	state.vouchTTL = c.vouchTTL
+	state.vouchLimits = c.limits
+	state.tiers = &c.tiers
+	state.federation = &c.federation
+	state.adminToken = c.adminToken
+}
+
+// Sets up the issuer, the OpenID Connect clients and the key signing
+// attestations and tokens.
+func (c *serveConfig) setupOIDC(state *AppState) error {
+	state.issuer = c.issuer
+	state.oidcClients = c.oidcClients
+	if state.issuer == "" {
+		state.issuer = fmt.Sprintf("http://localhost:%d", c.port)
+	}
+	if c.signingKeyPath != "" {
+		key, err := LoadSigningKey(c.signingKeyPath)
+		if err != nil {
+			return err
+		}
+		state.signingKey = key
+	} else {
+		log.Printf("No -signing-key given, signed documents will not verify after a restart")
+	}
+	if c.idTokenKeyPath != "" {
+		key, err := LoadRSASigningKey(c.idTokenKeyPath)
+		if err != nil {
+			return err
+		}
+		state.idTokenKey = key
+	}
+	return nil
+}
+
+// Wraps the storage in a replication log on leaders and federated
+// instances, and starts replicating on followers.
+func (c *serveConfig) setupReplication(state *AppState) error {
+	// Peers read the instance's events from its replication log
+	if c.leader || (c.federation.Instance != "" && c.follow == "") {
+		replication, err := NewReplicationLog(state.storage)
+		if err != nil {
+			return err
+		}
+		state.storage = replication
+		state.replication = replication
+	}
+	if c.follow != "" {
+		state.readOnly = true
+		follower := NewFollower(strings.TrimSuffix(c.follow, "/"), state)
+		go follower.Run(context.Background())
+		log.Printf("Replicating from %s\n", c.follow)
+	}
+	return nil
+}
+
+// Starts pulling the configured peers.
+func (c *serveConfig) setupFederation(state *AppState) {
+	if c.federation.Instance != "" {
+		log.Printf("Federating as %s, peers pin key ID %s\n", c.federation.Instance, state.signer().ID)
+	}
+	go RunFederation(context.Background(), state)
+}
+
+// Starts publishing state commitments, anchored if an anchor file is set.
+func (c *serveConfig) setupCommitments(state *AppState) {
+	if c.anchorFile != "" {
+		state.anchor = NewFileAnchor(c.anchorFile)
+	}
+	go RunCommitments(context.Background(), state, c.commitInterval)
+}
+
+// Starts delivering webhooks if any are subscribed.
+func (c *serveConfig) setupWebhooks(state *AppState) {
+	if len(c.webhooks.Subscriptions) > 0 {
+		dispatcher := NewWebhookDispatcher(state, c.webhooks)
+		go dispatcher.Run(context.Background(), state)
+	}
+}
+
+// Starts the HTTP server on the configured storage.
+func serveCommand(args []string, _ io.Reader, _ io.Writer) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	var config serveConfig
+	config.register(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	state, err := openAppState(config.storage)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+	config.configureState(state)
+	if err := config.setupOIDC(state); err != nil {
+		return err
+	}
+	if err := config.setupReplication(state); err != nil {
+		return err
+	}
+	config.setupFederation(state)
+	config.setupCommitments(state)
+	config.setupWebhooks(state)
+
+	router := SetupRouterWithState(state)
+	log.Printf("Starting server on :%d\n", config.port)
+	return http.ListenAndServe(fmt.Sprintf(":%d", config.port), router)
+}
+
+// Prints the identity information, proof, penalties and vouches of a user.
+func userCommand(args []string, _ io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("user", flag.ContinueOnError)
+	var config storageConfig
+	config.register(flags)
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	usage := fmt.Errorf("usage: user show <id> | user set-key <id> <public key>")
+	if len(positional) < 2 {
+		return usage
+	}
+	switch {
+	case positional[0] == "show" && len(positional) == 2:
+	case positional[0] == "set-key" && len(positional) == 3:
+	default:
+		return usage
+	}
+	user := positional[1]
+
+	state, err := openAppState(config)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	if positional[0] == "set-key" {
+		return setUserKey(state, user, positional[2], stdout)
+	}
+
+	info, idtErr := IdtHandler(state, user)
+	if idtErr != nil {
+		return idtErr
+	}
+	proof, err := state.ProofRecord(user)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "User: %s\n", info.User)
+	fmt.Fprintf(stdout, "Balance: %d\n", info.Balance)
+	fmt.Fprintf(stdout, "Penalty: %d\n", info.Penalty)
+	fmt.Fprintf(stdout, "Tier: %s\n", info.Tier)
+	if proof.Timestamp.IsZero() {
+		fmt.Fprintf(stdout, "Proof: none\n")
+	} else {
+		fmt.Fprintf(stdout, "Proof: %d at %s\n", proof.Balance, proof.Timestamp.Format(time.RFC3339Nano))
+	}
+
+	penalties := state.Penalties(user)
+	fmt.Fprintf(stdout, "Penalties (%d):\n", len(penalties))
+	for _, p := range penalties {
+		if p.Origin != "" {
+			fmt.Fprintf(stdout, "  %d at %s slashed by penalty %s\n", p.Amount, p.Timestamp.Format(time.RFC3339Nano), p.Origin)
+			continue
+		}
+		fmt.Fprintf(stdout, "  %d at %s\n", p.Amount, p.Timestamp.Format(time.RFC3339Nano))
+	}
+
+	outgoing := state.UserVouchesFrom(user)
+	sort.Slice(outgoing, func(i, j int) bool { return outgoing[i].To < outgoing[j].To })
+	fmt.Fprintf(stdout, "Vouches for (%d):\n", len(outgoing))
+	for _, v := range outgoing {
+		fmt.Fprintf(stdout, "  %s at %s\n", v.To, v.Timestamp.Format(time.RFC3339Nano))
+	}
+
+	incoming := state.UserVouchesTo(user)
+	sort.Slice(incoming, func(i, j int) bool { return incoming[i].From < incoming[j].From })
+	fmt.Fprintf(stdout, "Vouched by (%d):\n", len(incoming))
+	for _, v := range incoming {
+		fmt.Fprintf(stdout, "  %s at %s\n", v.From, v.Timestamp.Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// Registers the base64 encoded ed25519 public key of a user, replacing any
+// prior key. Users who already have vouches, a proof or penalties can only
+// get a key this way, since logins only register keys of new users.
+func setUserKey(state *AppState, user string, encoded string, stdout io.Writer) error {
+	if !validUserID(user) {
+		return ErrInvalidUserID
+	}
+	if IsRemoteUser(user) {
+		return ErrRemoteLogin
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid ed25519 public key")
+	}
+	if err := state.storage.SetKey(KeyEvent{User: user, PublicKey: publicKey, Timestamp: state.currentTime()}); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Registered key for %s\n", user)
+	return nil
+}
+
+// Prints the incoming or outgoing vouch tree of a user.
+func treeCommand(args []string, _ io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("tree", flag.ContinueOnError)
+	var config storageConfig
+	config.register(flags)
+	direction := flags.String("direction", "in", "tree direction: in for vouchers, out for vouchees")
+	depth := flags.Int("depth", DefaultTreeDepth, "maximum tree depth, negative for unlimited")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: tree <id> [-direction in|out] [-depth N]")
+	}
+	if *direction != "in" && *direction != "out" {
+		return fmt.Errorf("unknown direction %q", *direction)
+	}
+
+	state, err := openAppState(config)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	var tree *VouchTreeNode
+	if *direction == "out" {
+		tree = OutgoingTree(state, positional[0], *depth)
+	} else {
+		tree = IncomingTree(state, positional[0], *depth)
+	}
+	printTree(stdout, tree)
+	return nil
+}
+
+// Prints the whole vouch graph or a user's tree in a graph format.
+func graphCommand(args []string, _ io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("graph", flag.ContinueOnError)
+	var config storageConfig
+	config.register(flags)
+	formatName := flags.String("format", string(GraphFormatDOT), "graph format: dot, graphml or json")
+	user := flags.String("user", "", "export only the tree of this user")
+	direction := flags.String("direction", "in", "tree direction: in for vouchers, out for vouchees")
+	depth := flags.Int("depth", DefaultTreeDepth, "maximum tree depth, negative for unlimited")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	format, err := ParseGraphFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	if *direction != "in" && *direction != "out" {
+		return fmt.Errorf("unknown direction %q", *direction)
+	}
+
+	state, err := openAppState(config)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	var graph Graph
+	switch {
+	case *user == "":
+		graph = FullGraph(state)
+	case *direction == "out":
+		graph = TreeGraph(state, OutgoingTree(state, *user, *depth))
+	default:
+		graph = TreeGraph(state, IncomingTree(state, *user, *depth))
+	}
+	return WriteGraph(stdout, graph, format)
+}
+
+// Prints the tree with one user per line, indented by depth.
+func printTree(w io.Writer, root *VouchTreeNode) {
+	stack := []*VouchTreeNode{root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		fmt.Fprintf(w, "%s%s\n", strings.Repeat("  ", node.Depth), node.User)
+
+		peers := make([]*VouchTreeNode, 0, len(node.Peers))
+		for _, edge := range node.Peers {
+			if edge.Peer != nil {
+				peers = append(peers, edge.Peer)
+			}
+		}
+		// Push in reverse order so that peers are printed alphabetically
+		sort.Slice(peers, func(i, j int) bool { return peers[i].User > peers[j].User })
+		stack = append(stack, peers...)
+	}
+}
+
+// Recomputes and prints the balance and penalty of every user.
+func recomputeCommand(args []string, _ io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("recompute", flag.ContinueOnError)
+	var config storageConfig
+	config.register(flags)
+	scoringName := flags.String("scoring", string(ScoringModeTree), "scoring mode: tree or pagerank")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	scoring, idtErr := ParseScoringMode(*scoringName, ScoringModeTree)
+	if idtErr != nil {
+		return idtErr
+	}
+
+	state, err := openAppState(config)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	users := state.Users()
+	sort.Strings(users)
+	writer := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "USER\tBALANCE\tPENALTY\tTIER")
+	for _, user := range users {
+		info, idtErr := ScoredIdtHandler(state, user, scoring)
+		if idtErr != nil {
+			return idtErr
+		}
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%s\n", info.User, info.Balance, info.Penalty, info.Tier)
+	}
+	return writer.Flush()
+}
+
+// Upgrades the storage schema to the current version.
+// Storage backends apply pending migrations when opened, so this only has
+// to open and close the storage.
+func migrateCommand(args []string, _ io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	var config storageConfig
+	config.register(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	storage, err := config.open()
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if sqlite, ok := storage.(*SQLiteStorage); ok {
+		version, err := sqlite.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Schema is at version %d\n", version)
+		return nil
+	}
+	fmt.Fprintf(stdout, "Storage %q has no schema to migrate\n", config.kind)
+	return nil
+}
+
+// Writes a dump of the configured storage.
+func exportCommand(args []string, _ io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	var config storageConfig
+	config.register(flags)
+	formatName := flags.String("format", string(ExportFormatJSONL), "dump format: jsonl or csv")
+	output := flags.String("o", "", "output file, standard output if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	format, err := ParseExportFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	storage, err := config.open()
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if *output == "" {
+		return ExportStorage(storage, stdout, format)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := ExportStorage(storage, file, format); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Replays a dump into the configured storage.
+func importCommand(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	var config storageConfig
+	config.register(flags)
+	formatName := flags.String("format", string(ExportFormatJSONL), "dump format: jsonl or csv")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	format, err := ParseExportFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	input := stdin
+	if flags.NArg() > 0 {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	storage, err := config.open()
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	count, err := ImportStorage(storage, input, format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Imported %d events\n", count)
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCommandUnknown(t *testing.T) {
+	var out bytes.Buffer
+	if err := RunCommand([]string{"frobnicate"}, nil, &out); err == nil {
+		t.Fatal("expected error for unknown command")
+	}
+	if err := RunCommand(nil, nil, &out); err == nil {
+		t.Fatal("expected error for missing command")
+	}
+}
+
+func TestExportImportCommands(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "source.db")
+	source, err := NewBoltStorage(sourcePath)
+	if err != nil {
+		t.Fatalf("Failed to create bbolt storage: %v", err)
+	}
+	populateExportStorage(t, source)
+	source.Close()
+
+	dumpPath := filepath.Join(dir, "dump.csv")
+	var out bytes.Buffer
+	args := []string{"export", "-storage", "bolt", "-db", sourcePath, "-format", "csv", "-o", dumpPath}
+	if err := RunCommand(args, nil, &out); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	targetPath := filepath.Join(dir, "target")
+	args = []string{"import", "-storage", "eventlog", "-db", targetPath, "-format", "csv", dumpPath}
+	if err := RunCommand(args, nil, &out); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "Imported 7 events") {
+		t.Fatalf("unexpected import output: %q", out.String())
+	}
+
+	source, err = NewBoltStorage(sourcePath)
+	if err != nil {
+		t.Fatalf("Failed to reopen bbolt storage: %v", err)
+	}
+	defer source.Close()
+	target, err := NewEventLogStorage(targetPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen event log storage: %v", err)
+	}
+	defer target.Close()
+	compareStorages(t, source, target)
+}
+
+func TestImportCommandReadsStdin(t *testing.T) {
+	var dump bytes.Buffer
+	source := NewMemoryStorage()
+	populateExportStorage(t, source)
+	if err := ExportStorage(source, &dump, ExportFormatJSONL); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	targetPath := filepath.Join(t.TempDir(), "target.db")
+	var out bytes.Buffer
+	if err := RunCommand([]string{"import", "-storage", "sqlite", "-db", targetPath}, &dump, &out); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	target, err := NewSQLiteStorage(targetPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen SQLite storage: %v", err)
+	}
+	defer target.Close()
+	compareStorages(t, source, target)
+}
+
+// Creates a bbolt storage file populated with the export test graph.
+func createCommandStorage(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "identity.db")
+	storage, err := NewBoltStorage(path)
+	if err != nil {
+		t.Fatalf("Failed to create bbolt storage: %v", err)
+	}
+	populateExportStorage(t, storage)
+	storage.Close()
+	return path
+}
+
+func TestUserShowCommand(t *testing.T) {
+	path := createCommandStorage(t)
+	var out bytes.Buffer
+	if err := RunCommand([]string{"user", "show", "bob", "-storage", "bolt", "-db", path}, nil, &out); err != nil {
+		t.Fatalf("user show failed: %v", err)
+	}
+	for _, expected := range []string{"User: bob\n", "Proof: none\n", "Vouches for (1):\n  carol at", "Vouched by (1):\n  alice at"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected output to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+
+	if err := RunCommand([]string{"user", "bob", "-storage", "bolt", "-db", path}, nil, &out); err == nil {
+		t.Fatal("expected usage error without show")
+	}
+}
+
+func TestTreeCommand(t *testing.T) {
+	path := createCommandStorage(t)
+
+	var out bytes.Buffer
+	args := []string{"tree", "alice", "--direction", "out", "--depth", "2", "-storage", "bolt", "-db", path}
+	if err := RunCommand(args, nil, &out); err != nil {
+		t.Fatalf("tree failed: %v", err)
+	}
+	if out.String() != "alice\n  bob\n    carol\n" {
+		t.Fatalf("unexpected outgoing tree:\n%s", out.String())
+	}
+
+	out.Reset()
+	args = []string{"tree", "carol", "-direction", "in", "-depth", "1", "-storage", "bolt", "-db", path}
+	if err := RunCommand(args, nil, &out); err != nil {
+		t.Fatalf("tree failed: %v", err)
+	}
+	if out.String() != "carol\n  bob\n" {
+		t.Fatalf("unexpected incoming tree:\n%s", out.String())
+	}
+
+	if err := RunCommand([]string{"tree", "alice", "-direction", "sideways"}, nil, &out); err == nil {
+		t.Fatal("expected error for unknown direction")
+	}
+}
+
+func TestRecomputeCommand(t *testing.T) {
+	path := createCommandStorage(t)
+	var out bytes.Buffer
+	if err := RunCommand([]string{"recompute", "-storage", "bolt", "-db", path}, nil, &out); err != nil {
+		t.Fatalf("recompute failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected header and 3 users, got:\n%s", out.String())
+	}
+	if !strings.HasPrefix(lines[0], "USER") || !strings.HasPrefix(lines[1], "alice") || !strings.HasPrefix(lines[3], "carol") {
+		t.Fatalf("unexpected recompute output:\n%s", out.String())
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.db")
+	var out bytes.Buffer
+	if err := RunCommand([]string{"migrate", "-storage", "sqlite", "-db", path}, nil, &out); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	expected := fmt.Sprintf("Schema is at version %d\n", len(sqliteMigrations))
+	if out.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestGraphCommand(t *testing.T) {
+	path := createCommandStorage(t)
+	var out bytes.Buffer
+	args := []string{"graph", "-format", "dot", "-user", "carol", "-direction", "in", "-depth", "1", "-storage", "bolt", "-db", path}
+	if err := RunCommand(args, nil, &out); err != nil {
+		t.Fatalf("graph failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `"bob" -> "carol"`) || strings.Contains(out.String(), `"alice" -> "bob"`) {
+		t.Fatalf("unexpected graph output:\n%s", out.String())
+	}
+}
+
+func TestUserSetKeyCommand(t *testing.T) {
+	path := createCommandStorage(t)
+	public, _, _ := ed25519.GenerateKey(nil)
+	var out bytes.Buffer
+	args := []string{"user", "set-key", "bob", base64.StdEncoding.EncodeToString(public), "-storage", "bolt", "-db", path}
+	if err := RunCommand(args, nil, &out); err != nil {
+		t.Fatalf("user set-key failed: %v", err)
+	}
+
+	storage, err := NewBoltStorage(path)
+	if err != nil {
+		t.Fatalf("Failed to open bbolt storage: %v", err)
+	}
+	key, err := storage.KeyRecord("bob")
+	storage.Close()
+	if err != nil || !public.Equal(ed25519.PublicKey(key.PublicKey)) {
+		t.Fatalf("expected the key to be registered, got %#v, %v", key, err)
+	}
+
+	args = []string{"user", "set-key", "carol", "short", "-storage", "bolt", "-db", path}
+	if err := RunCommand(args, nil, &out); err == nil {
+		t.Fatal("expected error for an invalid key")
+	}
+	args = []string{"user", "set-key", "carol@peer", base64.StdEncoding.EncodeToString(public), "-storage", "bolt", "-db", path}
+	if err := RunCommand(args, nil, &out); err != ErrRemoteLogin {
+		t.Fatalf("expected %v for a remote user, got %v", ErrRemoteLogin, err)
+	}
+}
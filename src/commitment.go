@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+)
+
+// JWS type of signed state commitments.
+const commitmentType = "idt-commitment+jwt"
+
+// How often the state is committed when no interval is configured.
+const defaultCommitInterval = time.Hour
+
+// Represents the claims of a signed commitment to the stored events.
+type Commitment struct {
+	Issuer   string `json:"iss"`
+	IssuedAt int64  `json:"iat"`
+	// Hex encoded Merkle root over the leaf hashes of all events
+	Root string `json:"root"`
+	Size int    `json:"size"`
+}
+
+// Represents the proof that an event is or is not in a commitment.
+// An included event has `Proof`. For an absent event, `Before` and `After`
+// prove the leaves on either side of where it would be.
+type MembershipProof struct {
+	Root     string       `json:"root"`
+	Size     int          `json:"size"`
+	LeafHash string       `json:"leaf_hash"`
+	Included bool         `json:"included"`
+	Proof    *MerkleProof `json:"proof,omitempty"`
+	Before   *MerkleProof `json:"before,omitempty"`
+	After    *MerkleProof `json:"after,omitempty"`
+}
+
+// Represents a committed event with its inclusion proof.
+type CommittedEvent struct {
+	Event Event       `json:"event"`
+	Proof MerkleProof `json:"proof"`
+}
+
+// Keeps the latest commitment and the tree it was computed over, so that
+// proofs are always against the published root.
+type commitmentStore struct {
+	mu         sync.Mutex
+	tree       *MerkleTree
+	events     []Event
+	commitment Commitment
+	signed     string
+}
+
+// Builds a Merkle tree over every stored event and publishes its signed root.
+func CommitHandler(state *AppState) (Commitment, string, error) {
+	events, err := StorageEvents(state.storage)
+	if err != nil {
+		return Commitment{}, "", err
+	}
+	leaves := make([][]byte, len(events))
+	for i, event := range events {
+		leaves[i] = EventLeafHash(event)
+	}
+	tree := NewMerkleTree(leaves)
+	commitment := Commitment{
+		Issuer:   state.issuerURL(),
+		IssuedAt: state.currentTime().Unix(),
+		Root:     hex.EncodeToString(tree.Root()),
+		Size:     tree.Size(),
+	}
+	signed, err := state.signer().Sign(commitment, commitmentType)
+	if err != nil {
+		return Commitment{}, "", err
+	}
+
+	state.commitments.mu.Lock()
+	defer state.commitments.mu.Unlock()
+	state.commitments.tree = tree
+	state.commitments.events = events
+	state.commitments.commitment = commitment
+	state.commitments.signed = signed
+	return commitment, signed, nil
+}
+
+// Returns the latest commitment, committing the current state if there is none.
+func LatestCommitmentHandler(state *AppState) (Commitment, string, error) {
+	state.commitments.mu.Lock()
+	commitment, signed := state.commitments.commitment, state.commitments.signed
+	state.commitments.mu.Unlock()
+	if signed != "" {
+		return commitment, signed, nil
+	}
+	return CommitHandler(state)
+}
+
+// Returns the latest tree and its events, committing the current state if
+// there is none.
+func committedTree(state *AppState) (*MerkleTree, []Event, Commitment, error) {
+	if _, _, err := LatestCommitmentHandler(state); err != nil {
+		return nil, nil, Commitment{}, err
+	}
+	state.commitments.mu.Lock()
+	defer state.commitments.mu.Unlock()
+	return state.commitments.tree, state.commitments.events, state.commitments.commitment, nil
+}
+
+// Proves whether the event with the given leaf hash is in the latest commitment.
+func MembershipHandler(state *AppState, leaf []byte) (MembershipProof, error) {
+	tree, _, commitment, err := committedTree(state)
+	if err != nil {
+		return MembershipProof{}, err
+	}
+	result := MembershipProof{Root: commitment.Root, Size: commitment.Size, LeafHash: hex.EncodeToString(leaf)}
+	index, found := tree.Search(leaf)
+	if found {
+		proof := tree.InclusionProof(index)
+		result.Included = true
+		result.Proof = &proof
+		return result, nil
+	}
+	if index > 0 {
+		before := tree.InclusionProof(index - 1)
+		result.Before = &before
+	}
+	if index < tree.Size() {
+		after := tree.InclusionProof(index)
+		result.After = &after
+	}
+	return result, nil
+}
+
+// Returns the committed events that involve the user with their inclusion
+// proofs: vouches by and for the user, and the user's proof, penalties and key.
+func UserCommitmentHandler(state *AppState, user string) ([]CommittedEvent, Commitment, error) {
+	tree, events, commitment, err := committedTree(state)
+	if err != nil {
+		return nil, Commitment{}, err
+	}
+	committed := []CommittedEvent{}
+	for _, event := range events {
+		if !event.Involves(user) {
+			continue
+		}
+		index, _ := tree.Search(EventLeafHash(event))
+		committed = append(committed, CommittedEvent{Event: event, Proof: tree.InclusionProof(index)})
+	}
+	return committed, commitment, nil
+}
+
+// Verifies a signed commitment against the issuer's published keys.
+func VerifyCommitment(token string, keys JWKSet) (Commitment, error) {
+	var commitment Commitment
+	if err := VerifyJWS(token, keys, commitmentType, &commitment); err != nil {
+		return Commitment{}, err
+	}
+	return commitment, nil
+}
+
+// Commits the state now and then periodically until the context is done.
+// Each commitment is published with the configured anchor, if any, unless
+// its root is the last anchored one.
+func RunCommitments(ctx context.Context, state *AppState, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultCommitInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		commitment, signed, err := CommitHandler(state)
+		if err != nil {
+			log.Printf("Error committing state: %v", err)
+		} else {
+			log.Printf("Committed %d events with root %s", commitment.Size, commitment.Root)
+		}
+		if err == nil && state.anchor != nil {
+			if latest, ok := state.LatestAnchorReceipt(); ok && latest.Root == commitment.Root {
+				log.Printf("State root %s is already anchored", commitment.Root)
+			} else if _, err := AnchorHandler(state, commitment, signed); err != nil {
+				log.Printf("Error anchoring state root: %v", err)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestCommitmentProvesUserEvents(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp})
+	state.AddPenalty(PenaltyEvent{User: "bob", Amount: 5, Timestamp: timestamp})
+
+	claims, signed, err := CommitHandler(state)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	verified, err := VerifyCommitment(signed, state.publicKeys())
+	if err != nil || verified != claims || claims.Size != 3 {
+		t.Fatalf("unexpected commitment: %#v/%v", verified, err)
+	}
+	root, _ := hex.DecodeString(claims.Root)
+
+	events, _, err := UserCommitmentHandler(state, "alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected the proof and vouch of alice, got %#v", events)
+	}
+	for _, committed := range events {
+		if committed.Proof.LeafHash != hex.EncodeToString(EventLeafHash(committed.Event)) {
+			t.Fatalf("proof is for another event: %#v", committed)
+		}
+		if !VerifyInclusion(committed.Proof, claims.Size, root) {
+			t.Fatalf("inclusion proof did not verify: %#v", committed)
+		}
+	}
+}
+
+func TestCommitmentDetectsChangedProof(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	original := ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp}
+	state.SetProof(original)
+	state.SetProof(ProofEvent{User: "bob", Balance: 50, Timestamp: timestamp})
+	committed, _, _ := CommitHandler(state)
+
+	// A moderator silently replaces the proof after the commitment
+	state.SetProof(ProofEvent{User: "alice", Balance: 1, Timestamp: timestamp})
+	changed, _, _ := CommitHandler(state)
+	if changed.Root == committed.Root {
+		t.Fatal("expected the root to change")
+	}
+
+	leaf := EventLeafHash(EventFromProof(original))
+	membership, err := MembershipHandler(state, leaf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	root, _ := hex.DecodeString(changed.Root)
+	if membership.Included || !VerifyNonInclusion(leaf, membership.Before, membership.After, membership.Size, root) {
+		t.Fatalf("expected a verifiable proof of absence, got %#v", membership)
+	}
+}
@@ -6,4 +6,27 @@ import "errors"
 type IdentityError error
 
 var ErrUserNotFound IdentityError = errors.New("User not found")
+var ErrInvalidUserID IdentityError = errors.New("Invalid user ID")
 var ErrInvalidSignature IdentityError = errors.New("Invalid signature")
+var ErrUnknownScoringMode IdentityError = errors.New("Unknown scoring mode")
+var ErrInvalidVouchWeight IdentityError = errors.New("Invalid vouch weight")
+var ErrInvalidVouchExpiry IdentityError = errors.New("Invalid vouch expiry")
+var ErrVouchNotFound IdentityError = errors.New("Vouch not found")
+var ErrVouchQuotaExceeded IdentityError = errors.New("Vouch quota exceeded")
+var ErrVouchRateLimited IdentityError = errors.New("Vouch rate limit exceeded")
+var ErrInsufficientStake IdentityError = errors.New("Insufficient balance for stake")
+var ErrKeyNotRegistered IdentityError = errors.New("No key registered for existing user")
+var ErrInvalidChallenge IdentityError = errors.New("Invalid or expired challenge")
+var ErrTooManyPending IdentityError = errors.New("Too many pending logins, try again later")
+var ErrInvalidClient IdentityError = errors.New("Unknown client or redirect URI")
+var ErrInvalidAuthorizationRequest IdentityError = errors.New("Invalid authorization request")
+var ErrInvalidGrant IdentityError = errors.New("Invalid authorization code")
+var ErrInvalidToken IdentityError = errors.New("Invalid or expired token")
+var ErrSessionUserMismatch IdentityError = errors.New("Session belongs to another user")
+var ErrReadOnlyReplica IdentityError = errors.New("Read-only replica")
+var ErrReplicationDisabled IdentityError = errors.New("Replication is disabled")
+var ErrFederationDisabled IdentityError = errors.New("Federation is disabled")
+var ErrRemoteUser IdentityError = errors.New("Remote users cannot vouch on this instance")
+var ErrAdminDisabled IdentityError = errors.New("Admin endpoints are disabled")
+var ErrInvalidAdminToken IdentityError = errors.New("Invalid admin token")
+var ErrRemoteLogin IdentityError = errors.New("Remote users must log in on their home instance")
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of published events kept for clients resuming a stream.
+const eventBusHistory = 1000
+
+// Number of events buffered per stream client. A client falling further
+// behind is disconnected and resumes from the history when it reconnects.
+const eventStreamBuffer = 256
+
+// Interval of the comments keeping idle streams open through proxies.
+const eventStreamKeepAlive = 15 * time.Second
+
+// Tells why an existing record was written again. Writes of new records
+// have no reason.
+type WriteReason string
+
+const (
+	// The expiry of a vouch was extended
+	WriteReasonRenew WriteReason = "renew"
+	// The stake of a vouch was slashed by a penalty on the vouchee
+	WriteReasonSlash WriteReason = "slash"
+)
+
+// Represents an accepted write published on the event bus.
+type BusEvent struct {
+	// Bus ID and sequence number, unique across restarts
+	ID     string      `json:"id"`
+	Event  Event       `json:"event"`
+	Reason WriteReason `json:"reason,omitempty"`
+	seq    uint64
+}
+
+// Fans out accepted writes to in-process subscribers, such as stream
+// clients and webhooks. The latest events are kept so that a client can
+// resume from the last event it received.
+type EventBus struct {
+	id       string
+	capacity int
+
+	mu          sync.Mutex
+	seq         uint64
+	history     []BusEvent
+	subscribers map[uint64]func(BusEvent)
+	// key of the next subscriber
+	next uint64
+}
+
+// Creates a bus keeping up to `capacity` events for resuming subscribers.
+func NewEventBus(capacity int) *EventBus {
+	return &EventBus{
+		id:          rand.Text(),
+		capacity:    capacity,
+		subscribers: make(map[uint64]func(BusEvent)),
+	}
+}
+
+// Assigns the event the next ID and passes it to every subscriber.
+// Subscribers are called in publication order with the bus locked, so they
+// must not block.
+func (b *EventBus) Publish(event Event, reason WriteReason) BusEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	published := BusEvent{ID: fmt.Sprintf("%s-%d", b.id, b.seq), Event: event, Reason: reason, seq: b.seq}
+	b.history = append(b.history, published)
+	if len(b.history) > b.capacity {
+		b.history = b.history[len(b.history)-b.capacity:]
+	}
+	for _, handler := range b.subscribers {
+		handler(published)
+	}
+	return published
+}
+
+// Registers a handler for the events published from now on. With the ID
+// of an earlier event, the events published after it are returned as
+// backlog. Reports false if the bus cannot resume from the ID because it
+// is unknown or has left the history; the backlog is empty then.
+func (b *EventBus) Subscribe(after string, handler func(BusEvent)) ([]BusEvent, bool, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	backlog, resumed := []BusEvent{}, true
+	if after != "" {
+		backlog, resumed = b.since(after)
+	}
+	key := b.next
+	b.next++
+	b.subscribers[key] = handler
+	return backlog, resumed, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, key)
+	}
+}
+
+// Returns the events published after the one with the ID.
+// Must be called with the mutex held.
+func (b *EventBus) since(after string) ([]BusEvent, bool) {
+	id, rawSeq, ok := strings.Cut(after, "-")
+	seq, err := strconv.ParseUint(rawSeq, 10, 64)
+	if !ok || err != nil || id != b.id || seq > b.seq {
+		return []BusEvent{}, false
+	}
+	// The oldest kept event must directly follow the last received one
+	oldest := b.seq + 1
+	if len(b.history) > 0 {
+		oldest = b.history[0].seq
+	}
+	if seq+1 < oldest {
+		return []BusEvent{}, false
+	}
+	backlog := make([]BusEvent, 0, b.seq-seq)
+	for _, event := range b.history {
+		if event.seq > seq {
+			backlog = append(backlog, event)
+		}
+	}
+	return backlog, true
+}
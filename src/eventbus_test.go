@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestEventBusResume(t *testing.T) {
+	bus := NewEventBus(2)
+	first := bus.Publish(EventFromProof(ProofEvent{User: "alice"}), "")
+	second := bus.Publish(EventFromProof(ProofEvent{User: "bob"}), "")
+
+	received := []BusEvent{}
+	backlog, resumed, unsubscribe := bus.Subscribe(first.ID, func(published BusEvent) {
+		received = append(received, published)
+	})
+	if !resumed || len(backlog) != 1 || backlog[0].ID != second.ID {
+		t.Fatalf("expected the event after the first, got %v/%#v", resumed, backlog)
+	}
+	third := bus.Publish(EventFromProof(ProofEvent{User: "carol"}), "")
+	unsubscribe()
+	bus.Publish(EventFromProof(ProofEvent{User: "dave"}), "")
+	if len(received) != 1 || received[0].ID != third.ID || received[0].Event.Proof.User != "carol" {
+		t.Fatalf("expected only the event published while subscribed, got %#v", received)
+	}
+
+	// The latest event has nothing after it
+	backlog, resumed, _ = bus.Subscribe(received[0].ID, func(BusEvent) {})
+	if !resumed || len(backlog) != 1 || backlog[0].Event.Proof.User != "dave" {
+		t.Fatalf("unexpected backlog: %v/%#v", resumed, backlog)
+	}
+	// The first event has left the history of two events
+	if _, resumed, _ := bus.Subscribe(first.ID, func(BusEvent) {}); resumed {
+		t.Fatal("expected an event outside of the history not to resume")
+	}
+	for _, id := range []string{"unknown-1", NewEventBus(2).Publish(EventFromProof(ProofEvent{}), "").ID, "garbage"} {
+		if _, resumed, _ := bus.Subscribe(id, func(BusEvent) {}); resumed {
+			t.Fatalf("expected %q not to resume", id)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Identifies the kind of state change carried by an Event.
+type EventKind string
+
+const (
+	EventKindVouch   EventKind = "vouch"
+	EventKindProof   EventKind = "proof"
+	EventKindPenalty EventKind = "penalty"
+	EventKindKey     EventKind = "key"
+)
+
+// Wraps a single vouch, proof, penalty or key event so that they can be stored
+// and transferred as one stream. Exactly one of the payload fields is set,
+// matching Kind.
+type Event struct {
+	Kind    EventKind     `json:"kind"`
+	Vouch   *VouchEvent   `json:"vouch,omitempty"`
+	Proof   *ProofEvent   `json:"proof,omitempty"`
+	Penalty *PenaltyEvent `json:"penalty,omitempty"`
+	Key     *KeyEvent     `json:"key,omitempty"`
+}
+
+// Wraps a vouch into an Event.
+func EventFromVouch(vouch VouchEvent) Event {
+	return Event{Kind: EventKindVouch, Vouch: &vouch}
+}
+
+// Wraps a proof into an Event.
+func EventFromProof(proof ProofEvent) Event {
+	return Event{Kind: EventKindProof, Proof: &proof}
+}
+
+// Wraps a penalty into an Event.
+func EventFromPenalty(penalty PenaltyEvent) Event {
+	return Event{Kind: EventKindPenalty, Penalty: &penalty}
+}
+
+// Wraps a key registration into an Event.
+func EventFromKey(key KeyEvent) Event {
+	return Event{Kind: EventKindKey, Key: &key}
+}
+
+// Checks that the payload matches the event kind and names valid users,
+// and that a vouch is not addressed to the voucher.
+func (e Event) Validate() error {
+	switch e.Kind {
+	case EventKindVouch:
+		if e.Vouch == nil {
+			return fmt.Errorf("vouch event without vouch payload")
+		}
+		if e.Vouch.From == e.Vouch.To {
+			return fmt.Errorf("vouch event from %q to itself", e.Vouch.From)
+		}
+	case EventKindProof:
+		if e.Proof == nil {
+			return fmt.Errorf("proof event without proof payload")
+		}
+	case EventKindPenalty:
+		if e.Penalty == nil {
+			return fmt.Errorf("penalty event without penalty payload")
+		}
+	case EventKindKey:
+		if e.Key == nil {
+			return fmt.Errorf("key event without key payload")
+		}
+	default:
+		return fmt.Errorf("unknown event kind %q", e.Kind)
+	}
+	for _, user := range e.users() {
+		if user == "" || !validUserID(user) {
+			return fmt.Errorf("%s event with invalid user ID %q", e.Kind, user)
+		}
+	}
+	return nil
+}
+
+// Returns the users named by a valid event.
+func (e Event) users() []string {
+	switch e.Kind {
+	case EventKindVouch:
+		return []string{e.Vouch.From, e.Vouch.To}
+	case EventKindProof:
+		return []string{e.Proof.User}
+	case EventKindPenalty:
+		return []string{e.Penalty.User}
+	case EventKindKey:
+		return []string{e.Key.User}
+	}
+	return nil
+}
+
+// Reports whether the event involves the user: a vouch by or for the user,
+// or the user's proof, penalty or key.
+func (e Event) Involves(user string) bool {
+	return slices.Contains(e.users(), user)
+}
+
+// Writes the event into the storage.
+func (e Event) Apply(storage Storage) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	switch e.Kind {
+	case EventKindVouch:
+		return storage.AddVouch(*e.Vouch)
+	case EventKindProof:
+		return storage.SetProof(*e.Proof)
+	case EventKindKey:
+		return storage.SetKey(*e.Key)
+	default:
+		return storage.AddPenalty(*e.Penalty)
+	}
+}
+
+// Returns all events needed to rebuild the storage contents: every vouch,
+// every stored proof, every penalty in per-user order and every key.
+func StorageEvents(storage Storage) ([]Event, error) {
+	users, err := storage.Users()
+	if err != nil {
+		return nil, err
+	}
+
+	events := []Event{}
+	for _, user := range users {
+		vouches, err := storage.UserVouchesFrom(user)
+		if err != nil {
+			return nil, err
+		}
+		for _, vouch := range vouches {
+			events = append(events, EventFromVouch(vouch))
+		}
+
+		proof, err := storage.ProofRecord(user)
+		if err != nil {
+			return nil, err
+		}
+		// ProofRecord returns an empty record for users without a proof
+		if proof != (ProofEvent{User: user}) {
+			events = append(events, EventFromProof(proof))
+		}
+
+		penalties, err := storage.Penalties(user)
+		if err != nil {
+			return nil, err
+		}
+		for _, penalty := range penalties {
+			events = append(events, EventFromPenalty(penalty))
+		}
+
+		key, err := storage.KeyRecord(user)
+		if err != nil {
+			return nil, err
+		}
+		if len(key.PublicKey) > 0 {
+			events = append(events, EventFromKey(key))
+		}
+	}
+	return events, nil
+}
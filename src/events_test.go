@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventValidate(t *testing.T) {
+	if err := EventFromVouch(VouchEvent{From: "alice", To: "bob"}).Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := (Event{Kind: EventKindProof}).Validate(); err == nil {
+		t.Fatal("expected error for proof event without payload")
+	}
+	if err := (Event{Kind: "unknown"}).Validate(); err == nil {
+		t.Fatal("expected error for unknown event kind")
+	}
+	// NUL separates the parts of composite bbolt keys
+	if err := EventFromVouch(VouchEvent{From: "a\x00b", To: "c"}).Validate(); err == nil {
+		t.Fatal("expected error for a user ID with a NUL byte")
+	}
+}
+
+func TestHandlersRejectNulInUserID(t *testing.T) {
+	state := NewAppState()
+	if err := VouchHandler(state, "alice", "", "", "b\x00ob", 0, time.Time{}, 0); err != ErrInvalidUserID {
+		t.Fatalf("expected ErrInvalidUserID for the vouchee, got %v", err)
+	}
+	if err := RenewHandler(state, "alice", "b\x00ob", time.Time{}); err != ErrInvalidUserID {
+		t.Fatalf("expected ErrInvalidUserID for a renewal, got %v", err)
+	}
+	if err := ProveHandler(state, "a\x00", 100); err != ErrInvalidUserID {
+		t.Fatalf("expected ErrInvalidUserID for a proof, got %v", err)
+	}
+	if err := PunishHandler(state, "a\x00", 100); err != ErrInvalidUserID {
+		t.Fatalf("expected ErrInvalidUserID for a penalty, got %v", err)
+	}
+	if users := state.Users(); len(users) != 0 {
+		t.Fatalf("expected nothing stored, got %v", users)
+	}
+}
+
+func TestStorageEventsRoundTrip(t *testing.T) {
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	source := NewMemoryStorage()
+	source.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp})
+	source.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+	source.AddPenalty(PenaltyEvent{User: "bob", Amount: 10, Timestamp: timestamp})
+	source.AddPenalty(PenaltyEvent{User: "bob", Amount: 20, Timestamp: timestamp})
+
+	events, err := StorageEvents(source)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// bob has no proof, so no empty proof event is emitted for him
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+
+	target := NewMemoryStorage()
+	for _, event := range events {
+		if err := event.Apply(target); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	vouches, _ := target.UserVouchesTo("bob")
+	if len(vouches) != 1 || vouches[0].From != "alice" {
+		t.Fatalf("unexpected vouches: %#v", vouches)
+	}
+	proof, _ := target.ProofRecord("alice")
+	if proof.Balance != 100 {
+		t.Fatalf("unexpected proof: %#v", proof)
+	}
+	penalties, _ := target.Penalties("bob")
+	if len(penalties) != 2 || penalties[0].Amount != 10 || penalties[1].Amount != 20 {
+		t.Fatalf("unexpected penalties: %#v", penalties)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Identifies dump files produced by ExportStorage.
+const exportFormatName = "identity-export"
+
+// Version of the dump layout. Increase it when the meaning of existing fields changes.
+const exportVersion = 1
+
+// Serialization format of a dump.
+type ExportFormat string
+
+const (
+	// One JSON object per line: a header followed by one Event per line.
+	ExportFormatJSONL ExportFormat = "jsonl"
+	// A version row, a column header row and one row per event.
+	ExportFormatCSV ExportFormat = "csv"
+)
+
+// Represents the first line of a JSON Lines dump.
+type exportHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+// Columns of CSV dumps. Columns are looked up by name on import, so dumps
+// written before a column was added can still be imported.
+var exportCSVColumns = []string{"kind", "from", "to", "user", "balance", "amount", "timestamp", "weight", "expires_at", "stake", "origin", "public_key", "id"}
+
+// Parses a format name, defaulting to JSON Lines when empty.
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch ExportFormat(name) {
+	case "", ExportFormatJSONL:
+		return ExportFormatJSONL, nil
+	case ExportFormatCSV:
+		return ExportFormatCSV, nil
+	}
+	return "", fmt.Errorf("unsupported export format %q", name)
+}
+
+// Writes every vouch, proof, penalty and key in the storage to w.
+func ExportStorage(storage Storage, w io.Writer, format ExportFormat) error {
+	events, err := StorageEvents(storage)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case ExportFormatJSONL:
+		return exportJSONL(events, w)
+	case ExportFormatCSV:
+		return exportCSV(events, w)
+	}
+	return fmt.Errorf("unsupported export format %q", format)
+}
+
+// Replays every event from a dump into the storage.
+// Returns the number of imported events.
+func ImportStorage(storage Storage, r io.Reader, format ExportFormat) (int, error) {
+	return importEvents(storage, r, format, func(event Event) error {
+		return event.Apply(storage)
+	})
+}
+
+// Imports a dump into the state, publishing the imported events like local
+// writes.
+func ImportState(state *AppState, r io.Reader, format ExportFormat) (int, error) {
+	return importEvents(state.storage, r, format, state.ApplyEvent)
+}
+
+// Parses a dump and passes its events in order to `apply`. Keys that would
+// replace a different registered key are skipped, since whoever controls
+// the dump could otherwise take over the user's login.
+func importEvents(storage Storage, r io.Reader, format ExportFormat, apply func(Event) error) (int, error) {
+	var events []Event
+	var err error
+	switch format {
+	case ExportFormatJSONL:
+		events, err = importJSONL(r)
+	case ExportFormatCSV:
+		events, err = importCSV(r)
+	default:
+		err = fmt.Errorf("unsupported export format %q", format)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// The whole dump is parsed before anything is written, so a malformed
+	// dump does not leave the storage partially imported.
+	imported := 0
+	for _, event := range events {
+		if event.Kind == EventKindKey {
+			key, err := storage.KeyRecord(event.Key.User)
+			if err != nil {
+				return imported, err
+			}
+			if len(key.PublicKey) > 0 && !bytes.Equal(key.PublicKey, event.Key.PublicKey) {
+				log.Printf("Skipping imported key of %s, another key is registered", event.Key.User)
+				continue
+			}
+		}
+		if err := apply(event); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// Checks that a dump header is supported.
+func checkExportHeader(format string, version int) error {
+	if format != exportFormatName {
+		return fmt.Errorf("not an identity export: format %q", format)
+	}
+	if version < 1 || version > exportVersion {
+		return fmt.Errorf("unsupported export version %d", version)
+	}
+	return nil
+}
+
+func exportJSONL(events []Event, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(exportHeader{Format: exportFormatName, Version: exportVersion}); err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func importJSONL(r io.Reader) ([]Event, error) {
+	scanner := bufio.NewScanner(r)
+	// Allow long lines for events with long user identifiers
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("empty export")
+	}
+	var header exportHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("invalid export header: %w", err)
+	}
+	if err := checkExportHeader(header.Format, header.Version); err != nil {
+		return nil, err
+	}
+
+	events := []Event{}
+	line := 1
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := event.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func exportCSV(events []Event, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{exportFormatName, strconv.Itoa(exportVersion)}); err != nil {
+		return err
+	}
+	if err := writer.Write(exportCSVColumns); err != nil {
+		return err
+	}
+	for _, event := range events {
+		row := make([]string, len(exportCSVColumns))
+		row[0] = string(event.Kind)
+		switch event.Kind {
+		case EventKindVouch:
+			row[1] = event.Vouch.From
+			row[2] = event.Vouch.To
+			row[6] = event.Vouch.Timestamp.Format(time.RFC3339Nano)
+			row[7] = strconv.FormatUint(event.Vouch.Weight, 10)
+			if !event.Vouch.ExpiresAt.IsZero() {
+				row[8] = event.Vouch.ExpiresAt.Format(time.RFC3339Nano)
+			}
+			row[9] = strconv.FormatUint(event.Vouch.Stake, 10)
+		case EventKindProof:
+			row[3] = event.Proof.User
+			row[4] = strconv.FormatUint(event.Proof.Balance, 10)
+			row[6] = event.Proof.Timestamp.Format(time.RFC3339Nano)
+		case EventKindPenalty:
+			row[3] = event.Penalty.User
+			row[5] = strconv.FormatUint(event.Penalty.Amount, 10)
+			row[6] = event.Penalty.Timestamp.Format(time.RFC3339Nano)
+			row[10] = event.Penalty.Origin
+			row[12] = event.Penalty.ID
+		case EventKindKey:
+			row[3] = event.Key.User
+			row[6] = event.Key.Timestamp.Format(time.RFC3339Nano)
+			row[11] = base64.StdEncoding.EncodeToString(event.Key.PublicKey)
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func importCSV(r io.Reader) ([]Event, error) {
+	reader := csv.NewReader(r)
+	// The version row has fewer fields than event rows
+	reader.FieldsPerRecord = -1
+
+	version, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("empty export")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(version) != 2 {
+		return nil, fmt.Errorf("invalid export header")
+	}
+	versionNumber, err := strconv.Atoi(version[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid export version %q", version[1])
+	}
+	if err := checkExportHeader(version[0], versionNumber); err != nil {
+		return nil, err
+	}
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing column header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"kind", "timestamp"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	events := []Event{}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(row) != len(header) {
+			return nil, fmt.Errorf("line %d: expected %d fields, got %d", line, len(header), len(row))
+		}
+		event, err := csvRowEvent(columns, row)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Converts a CSV row into an event using the column positions from the header.
+func csvRowEvent(columns map[string]int, row []string) (Event, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return row[i]
+		}
+		return ""
+	}
+	number := func(name string) (uint64, error) {
+		value := field(name)
+		if value == "" {
+			return 0, nil
+		}
+		return strconv.ParseUint(value, 10, 64)
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, field("timestamp"))
+	if err != nil {
+		return Event{}, err
+	}
+	timestamp = timestamp.UTC()
+
+	switch EventKind(field("kind")) {
+	case EventKindVouch:
+		weight, err := number("weight")
+		if err != nil {
+			return Event{}, err
+		}
+		stake, err := number("stake")
+		if err != nil {
+			return Event{}, err
+		}
+		vouch := VouchEvent{From: field("from"), To: field("to"), Timestamp: timestamp, Weight: weight, Stake: stake}
+		if value := field("expires_at"); value != "" {
+			expiresAt, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return Event{}, err
+			}
+			vouch.ExpiresAt = expiresAt.UTC()
+		}
+		return EventFromVouch(vouch), nil
+	case EventKindProof:
+		balance, err := number("balance")
+		if err != nil {
+			return Event{}, err
+		}
+		return EventFromProof(ProofEvent{User: field("user"), Balance: balance, Timestamp: timestamp}), nil
+	case EventKindPenalty:
+		amount, err := number("amount")
+		if err != nil {
+			return Event{}, err
+		}
+		return EventFromPenalty(PenaltyEvent{ID: field("id"), User: field("user"), Amount: amount, Timestamp: timestamp, Origin: field("origin")}), nil
+	case EventKindKey:
+		publicKey, err := base64.StdEncoding.DecodeString(field("public_key"))
+		if err != nil {
+			return Event{}, err
+		}
+		return EventFromKey(KeyEvent{User: field("user"), PublicKey: publicKey, Timestamp: timestamp}), nil
+	}
+	return Event{}, fmt.Errorf("unknown event kind %q", field("kind"))
+}
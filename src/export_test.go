@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Fills the storage with a small graph used by export tests.
+func populateExportStorage(t *testing.T, storage Storage) {
+	timestamp := time.Date(2024, time.July, 8, 9, 10, 11, 12, time.UTC)
+	events := []Event{
+		EventFromVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp, Stake: 20}),
+		EventFromVouch(VouchEvent{From: "bob", To: "carol", Timestamp: timestamp, Weight: 60, ExpiresAt: timestamp.AddDate(100, 0, 0)}),
+		EventFromProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp}),
+		EventFromPenalty(PenaltyEvent{User: "carol", Amount: 5, Timestamp: timestamp}),
+		EventFromPenalty(PenaltyEvent{ID: "p1", User: "carol", Amount: 7, Timestamp: timestamp.Add(time.Second)}),
+		EventFromPenalty(PenaltyEvent{User: "bob", Amount: 3, Timestamp: timestamp.Add(time.Second), Origin: "p1"}),
+		EventFromKey(KeyEvent{User: "alice", PublicKey: bytes.Repeat([]byte{7}, 32), Timestamp: timestamp}),
+	}
+	for _, event := range events {
+		if err := event.Apply(storage); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+// Verifies that two storages hold the same events.
+func compareStorages(t *testing.T, expected Storage, actual Storage) {
+	for _, user := range []string{"alice", "bob", "carol"} {
+		expectedVouches, _ := expected.UserVouchesFrom(user)
+		actualVouches, _ := actual.UserVouchesFrom(user)
+		if len(expectedVouches) != len(actualVouches) {
+			t.Fatalf("vouch count mismatch for %s: %d vs %d", user, len(expectedVouches), len(actualVouches))
+		}
+		for i := range expectedVouches {
+			if expectedVouches[i] != actualVouches[i] {
+				t.Fatalf("vouch mismatch for %s: %#v vs %#v", user, expectedVouches[i], actualVouches[i])
+			}
+		}
+
+		expectedProof, _ := expected.ProofRecord(user)
+		actualProof, _ := actual.ProofRecord(user)
+		if expectedProof != actualProof {
+			t.Fatalf("proof mismatch for %s: %#v vs %#v", user, expectedProof, actualProof)
+		}
+
+		expectedPenalties, _ := expected.Penalties(user)
+		actualPenalties, _ := actual.Penalties(user)
+		if len(expectedPenalties) != len(actualPenalties) {
+			t.Fatalf("penalty count mismatch for %s: %d vs %d", user, len(expectedPenalties), len(actualPenalties))
+		}
+		for i := range expectedPenalties {
+			if expectedPenalties[i] != actualPenalties[i] {
+				t.Fatalf("penalty mismatch for %s: %#v vs %#v", user, expectedPenalties[i], actualPenalties[i])
+			}
+		}
+
+		expectedKey, _ := expected.KeyRecord(user)
+		actualKey, _ := actual.KeyRecord(user)
+		if expectedKey.User != actualKey.User || !bytes.Equal(expectedKey.PublicKey, actualKey.PublicKey) || !expectedKey.Timestamp.Equal(actualKey.Timestamp) {
+			t.Fatalf("key mismatch for %s: %#v vs %#v", user, expectedKey, actualKey)
+		}
+	}
+}
+
+func TestExportImportJSONL(t *testing.T) {
+	source := NewMemoryStorage()
+	populateExportStorage(t, source)
+
+	var buf bytes.Buffer
+	if err := ExportStorage(source, &buf, ExportFormatJSONL); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), `{"format":"identity-export","version":1}`) {
+		t.Fatalf("missing export header: %q", buf.String())
+	}
+	dump := buf.String()
+
+	testStorageImplementations(t, "ImportJSONL", func(t *testing.T, storage Storage) {
+		count, err := ImportStorage(storage, strings.NewReader(dump), ExportFormatJSONL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count != 7 {
+			t.Fatalf("expected 7 imported events, got %d", count)
+		}
+		compareStorages(t, source, storage)
+	})
+}
+
+func TestImportStatePublishesEvents(t *testing.T) {
+	source := NewMemoryStorage()
+	populateExportStorage(t, source)
+	var buf bytes.Buffer
+	if err := ExportStorage(source, &buf, ExportFormatJSONL); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	state := NewAppState()
+	published := 0
+	state.events.Subscribe("", func(BusEvent) { published++ })
+	if _, err := ImportState(state, &buf, ExportFormatJSONL); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Every imported event but the key
+	if published != 6 {
+		t.Fatalf("expected 6 published events, got %d", published)
+	}
+	compareStorages(t, source, state.storage)
+}
+
+func TestImportDoesNotReplaceKeys(t *testing.T) {
+	source := NewMemoryStorage()
+	populateExportStorage(t, source)
+	var buf bytes.Buffer
+	if err := ExportStorage(source, &buf, ExportFormatJSONL); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	storage := NewMemoryStorage()
+	registered := KeyEvent{User: "alice", PublicKey: bytes.Repeat([]byte{9}, 32), Timestamp: time.Now().UTC()}
+	if err := storage.SetKey(registered); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	count, err := ImportStorage(storage, &buf, ExportFormatJSONL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 6 {
+		t.Fatalf("expected every event but the key to be imported, got %d", count)
+	}
+	if key, _ := storage.KeyRecord("alice"); !bytes.Equal(key.PublicKey, registered.PublicKey) {
+		t.Fatalf("expected the registered key to be kept, got %#v", key)
+	}
+}
+
+func TestExportImportCSV(t *testing.T) {
+	source := NewMemoryStorage()
+	populateExportStorage(t, source)
+
+	var buf bytes.Buffer
+	if err := ExportStorage(source, &buf, ExportFormatCSV); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dump := buf.String()
+
+	testStorageImplementations(t, "ImportCSV", func(t *testing.T, storage Storage) {
+		count, err := ImportStorage(storage, strings.NewReader(dump), ExportFormatCSV)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count != 7 {
+			t.Fatalf("expected 7 imported events, got %d", count)
+		}
+		compareStorages(t, source, storage)
+	})
+}
+
+func TestImportCSVWithoutWeightColumn(t *testing.T) {
+	dump := "identity-export,1\n" +
+		"kind,from,to,user,balance,amount,timestamp\n" +
+		"vouch,alice,bob,,,,2024-01-02T03:04:05Z\n"
+	storage := NewMemoryStorage()
+	if _, err := ImportStorage(storage, strings.NewReader(dump), ExportFormatCSV); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vouches, _ := storage.UserVouchesFrom("alice")
+	if len(vouches) != 1 || vouches[0].EffectiveWeight() != maxVouchWeight {
+		t.Fatalf("expected full weight vouch, got %#v", vouches)
+	}
+}
+
+func TestImportRejectsUnsupportedVersion(t *testing.T) {
+	dump := `{"format":"identity-export","version":99}` + "\n"
+	if _, err := ImportStorage(NewMemoryStorage(), strings.NewReader(dump), ExportFormatJSONL); err == nil {
+		t.Fatal("expected error for unsupported version")
+	}
+
+	dump = "identity-export,99\n"
+	if _, err := ImportStorage(NewMemoryStorage(), strings.NewReader(dump), ExportFormatCSV); err == nil {
+		t.Fatal("expected error for unsupported version")
+	}
+}
+
+func TestImportMalformedDumpWritesNothing(t *testing.T) {
+	dump := `{"format":"identity-export","version":1}` + "\n" +
+		`{"kind":"vouch","vouch":{"from":"alice","to":"bob"}}` + "\n" +
+		`{"kind":"proof"}` + "\n"
+	storage := NewMemoryStorage()
+	if _, err := ImportStorage(storage, strings.NewReader(dump), ExportFormatJSONL); err == nil {
+		t.Fatal("expected error for malformed dump")
+	}
+	users, _ := storage.Users()
+	if len(users) != 0 {
+		t.Fatalf("expected no imported users, got %v", users)
+	}
+}
+
+func TestParseExportFormat(t *testing.T) {
+	if format, err := ParseExportFormat(""); err != nil || format != ExportFormatJSONL {
+		t.Fatalf("expected jsonl default, got %q/%v", format, err)
+	}
+	if format, err := ParseExportFormat("csv"); err != nil || format != ExportFormatCSV {
+		t.Fatalf("expected csv, got %q/%v", format, err)
+	}
+	if _, err := ParseExportFormat("xml"); err == nil {
+		t.Fatal("expected error for unsupported format")
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWS type of event batches exchanged between instances.
+const federationBatchType = "idt-federation+jwt"
+
+// Percentage of a remote voucher's balance withheld when no discount is configured.
+const defaultRemoteDiscount = 50
+
+// How often peers are pulled when no interval is configured.
+const defaultPeerSyncInterval = time.Minute
+
+// Represents another instance whose users are recognized by this one.
+type FederationPeer struct {
+	Name string
+	URL  string
+	// ID of the key the peer signs its events with, the RFC 7638
+	// thumbprint of the key. Batches signed by other keys are rejected.
+	KeyID string
+}
+
+// Configures federation with other instances.
+type FederationConfig struct {
+	// Name of this instance. Remote instances see local users as user@Instance.
+	Instance string
+	Peers    []FederationPeer
+	// Percentage from 0 to 100 of a remote voucher's balance withheld from
+	// the local users they vouch for.
+	RemoteDiscount uint64
+	SyncInterval   time.Duration
+}
+
+// Represents the signed payload of a federation batch.
+type FederatedBatch struct {
+	Issuer     string  `json:"iss"`
+	Instance   string  `json:"instance"`
+	IssuedAt   int64   `json:"iat"`
+	LogID      string  `json:"log_id"`
+	Offset     int     `json:"offset"`
+	NextOffset int     `json:"next_offset"`
+	Events     []Event `json:"events"`
+}
+
+// Parses a peer definition of the form name=url#kid=key_id.
+func ParseFederationPeer(value string) (FederationPeer, error) {
+	name, rest, ok := strings.Cut(value, "=")
+	if !ok || name == "" || strings.Contains(name, "@") {
+		return FederationPeer{}, fmt.Errorf("invalid peer %q, expected name=url#kid=key_id", value)
+	}
+	rawURL, keyID, ok := strings.Cut(rest, "#kid=")
+	if !ok || keyID == "" {
+		return FederationPeer{}, fmt.Errorf("invalid peer %q, expected name=url#kid=key_id", value)
+	}
+	if _, err := url.ParseRequestURI(rawURL); err != nil {
+		return FederationPeer{}, fmt.Errorf("invalid peer %q: %w", value, err)
+	}
+	return FederationPeer{Name: name, URL: strings.TrimSuffix(rawURL, "/"), KeyID: keyID}, nil
+}
+
+// Reports whether the user belongs to another instance.
+func IsRemoteUser(user string) bool {
+	return strings.Contains(user, "@")
+}
+
+// Withholds the remote trust discount from a balance passing through a
+// vouch by a remote user.
+func discountRemote(state *AppState, amount int64, vouch VouchEvent) int64 {
+	if !IsRemoteUser(vouch.From) {
+		return amount
+	}
+	discount := uint64(defaultRemoteDiscount)
+	if state.federation != nil {
+		discount = min(state.federation.RemoteDiscount, 100)
+	}
+	return amount * int64(100-discount) / 100
+}
+
+// Returns the events of the log that this instance shares with its peers:
+// vouches by and proofs of local users. Events of remote users are not
+// forwarded, so every event is only ever signed by its home instance.
+func localEvents(events []Event) []Event {
+	local := []Event{}
+	for _, event := range events {
+		switch event.Kind {
+		case EventKindVouch:
+			if !IsRemoteUser(event.Vouch.From) {
+				local = append(local, event)
+			}
+		case EventKindProof:
+			if !IsRemoteUser(event.Proof.User) {
+				local = append(local, event)
+			}
+		}
+	}
+	return local
+}
+
+// Signs a batch of local events read from the replication log.
+func FederationEventsHandler(state *AppState, offset int, limit int) (string, error) {
+	if state.replication == nil || state.federation == nil || state.federation.Instance == "" {
+		return "", ErrFederationDisabled
+	}
+	batch, err := state.replication.Read(context.Background(), offset, limit, 0)
+	if err != nil {
+		return "", err
+	}
+	return state.signer().Sign(FederatedBatch{
+		Issuer:     state.issuerURL(),
+		Instance:   state.federation.Instance,
+		IssuedAt:   state.currentTime().Unix(),
+		LogID:      batch.LogID,
+		Offset:     batch.Offset,
+		NextOffset: batch.NextOffset,
+		Events:     localEvents(batch.Events),
+	}, federationBatchType)
+}
+
+// Maps a user of the peer into this instance's namespace. Users of the peer
+// get the peer's name as suffix, and users of this instance lose theirs.
+func namespaceUser(user string, peer string, instance string) string {
+	if !IsRemoteUser(user) {
+		return user + "@" + peer
+	}
+	if local, ok := strings.CutSuffix(user, "@"+instance); ok && instance != "" {
+		return local
+	}
+	return user
+}
+
+// Converts an event signed by the peer into this instance's namespace.
+// Reports false for events the peer cannot speak for: vouches by and
+// proofs of users of other instances.
+func importFederatedEvent(event Event, peer string, instance string) (Event, bool) {
+	switch event.Kind {
+	case EventKindVouch:
+		if event.Vouch == nil || IsRemoteUser(event.Vouch.From) {
+			return Event{}, false
+		}
+		vouch := *event.Vouch
+		vouch.From = namespaceUser(vouch.From, peer, instance)
+		vouch.To = namespaceUser(vouch.To, peer, instance)
+		// Stakes are only backed by the balance on the home instance
+		vouch.Stake = 0
+		return EventFromVouch(vouch), true
+	case EventKindProof:
+		if event.Proof == nil || IsRemoteUser(event.Proof.User) {
+			return Event{}, false
+		}
+		proof := *event.Proof
+		proof.User = namespaceUser(proof.User, peer, instance)
+		return EventFromProof(proof), true
+	}
+	return Event{}, false
+}
+
+// Pulls the signed events of one peer into the local storage.
+type peerSync struct {
+	peer   FederationPeer
+	client *http.Client
+
+	mu     sync.Mutex
+	logID  string
+	offset int
+}
+
+func newPeerSync(peer FederationPeer) *peerSync {
+	return &peerSync{peer: peer, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Fetches a JSON document from the peer. Returns the response status code,
+// or zero if there was no response.
+func (p *peerSync) get(ctx context.Context, path string, out any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.peer.URL+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var body AnyResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, fmt.Errorf("peer %s responded with %d: %s", p.peer.Name, resp.StatusCode, body.Message)
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Returns the pinned key of the peer from its key set. The key ID is the
+// thumbprint of the key, so the peer cannot serve another key under it.
+func (p *peerSync) pinnedKey(keys JWKSet) (JWKSet, error) {
+	for _, key := range keys.Keys {
+		if key.KeyID != p.peer.KeyID {
+			continue
+		}
+		pinned := JWKSet{Keys: []JWK{key}}
+		public, err := pinned.publicKey(key.KeyID)
+		if err != nil {
+			return JWKSet{}, fmt.Errorf("peer %s: %w", p.peer.Name, err)
+		}
+		if keyThumbprint(public) != p.peer.KeyID {
+			return JWKSet{}, fmt.Errorf("peer %s serves another key as %q", p.peer.Name, p.peer.KeyID)
+		}
+		return pinned, nil
+	}
+	return JWKSet{}, fmt.Errorf("peer %s does not serve the pinned key %q", p.peer.Name, p.peer.KeyID)
+}
+
+// Imports every new event of the peer. Returns the number of imported events.
+func (p *peerSync) pull(ctx context.Context, state *AppState) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var published JWKSet
+	if _, err := p.get(ctx, "/.well-known/jwks.json", &published); err != nil {
+		return 0, err
+	}
+	// Only the pinned key is trusted, since the peer's key set proves nothing
+	keys, err := p.pinnedKey(published)
+	if err != nil {
+		return 0, err
+	}
+	instance := ""
+	if state.federation != nil {
+		instance = state.federation.Instance
+	}
+
+	imported := 0
+	for {
+		var resp FederationEventsResponse
+		status, err := p.get(ctx, "/federation/events?offset="+strconv.Itoa(p.offset), &resp)
+		if err != nil {
+			// A new log can be shorter than the old offset. Start over.
+			if status == http.StatusBadRequest && p.logID != "" {
+				p.logID, p.offset = "", 0
+			}
+			return imported, err
+		}
+		var batch FederatedBatch
+		if err := VerifyJWS(resp.Batch, keys, federationBatchType, &batch); err != nil {
+			return imported, fmt.Errorf("peer %s: %w", p.peer.Name, err)
+		}
+		if batch.Instance != p.peer.Name {
+			return imported, fmt.Errorf("peer %s signed events as %q", p.peer.Name, batch.Instance)
+		}
+		if p.logID != "" && batch.LogID != p.logID {
+			// The peer has started a new log. Replay it from the start.
+			p.logID, p.offset = "", 0
+			continue
+		}
+
+		for _, event := range batch.Events {
+			event, ok := importFederatedEvent(event, p.peer.Name, instance)
+			if !ok {
+				continue
+			}
+			if err := state.ApplyEvent(event); err != nil {
+				return imported, err
+			}
+			imported++
+		}
+		p.logID, p.offset = batch.LogID, batch.NextOffset
+		if batch.NextOffset == batch.Offset {
+			return imported, nil
+		}
+	}
+}
+
+// Pulls every configured peer periodically until the context is done.
+func RunFederation(ctx context.Context, state *AppState) {
+	if state.federation == nil || len(state.federation.Peers) == 0 {
+		return
+	}
+	interval := state.federation.SyncInterval
+	if interval <= 0 {
+		interval = defaultPeerSyncInterval
+	}
+	peers := make([]*peerSync, 0, len(state.federation.Peers))
+	for _, peer := range state.federation.Peers {
+		peers = append(peers, newPeerSync(peer))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, peer := range peers {
+			count, err := peer.pull(ctx, state)
+			if err != nil {
+				log.Printf("Error pulling events from peer %s: %v", peer.peer.Name, err)
+			}
+			if count > 0 {
+				log.Printf("Imported %d events from peer %s", count, peer.peer.Name)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Starts an in-process instance with the given name and peers.
+func newTestInstance(t *testing.T, name string, peers ...FederationPeer) (*AppState, *httptest.Server) {
+	t.Helper()
+	replication, err := NewReplicationLog(NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state := NewAppStateWithStorage(replication)
+	state.replication = replication
+	state.federation = &FederationConfig{Instance: name, Peers: peers, RemoteDiscount: defaultRemoteDiscount}
+	server := httptest.NewServer(SetupRouterWithState(state))
+	t.Cleanup(server.Close)
+	return state, server
+}
+
+func TestParseFederationPeer(t *testing.T) {
+	peer, err := ParseFederationPeer("b=https://b.example/#kid=key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if peer.Name != "b" || peer.URL != "https://b.example" || peer.KeyID != "key" {
+		t.Fatalf("unexpected peer: %#v", peer)
+	}
+	for _, value := range []string{"", "b", "=https://b.example#kid=key", "b@c=https://b.example#kid=key", "b=not a url#kid=key", "b=https://b.example", "b=https://b.example#kid="} {
+		if _, err := ParseFederationPeer(value); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+}
+
+func TestImportFederatedEvent(t *testing.T) {
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		event    Event
+		expected Event
+		ok       bool
+	}{
+		{
+			"vouch between peer users",
+			EventFromVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp, Stake: 10}),
+			EventFromVouch(VouchEvent{From: "alice@b", To: "bob@b", Timestamp: timestamp}),
+			true,
+		},
+		{
+			"vouch for a local user",
+			EventFromVouch(VouchEvent{From: "alice", To: "carol@a", Timestamp: timestamp}),
+			EventFromVouch(VouchEvent{From: "alice@b", To: "carol", Timestamp: timestamp}),
+			true,
+		},
+		{
+			"vouch for a user of a third instance",
+			EventFromVouch(VouchEvent{From: "alice", To: "dave@c", Timestamp: timestamp}),
+			EventFromVouch(VouchEvent{From: "alice@b", To: "dave@c", Timestamp: timestamp}),
+			true,
+		},
+		{
+			"proof of a peer user",
+			EventFromProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp}),
+			EventFromProof(ProofEvent{User: "alice@b", Balance: 100, Timestamp: timestamp}),
+			true,
+		},
+		{"vouch by a user of another instance", EventFromVouch(VouchEvent{From: "carol@a", To: "alice", Timestamp: timestamp}), Event{}, false},
+		{"proof of a user of another instance", EventFromProof(ProofEvent{User: "carol@a", Balance: 100, Timestamp: timestamp}), Event{}, false},
+		{"penalty", EventFromPenalty(PenaltyEvent{User: "alice", Amount: 5, Timestamp: timestamp}), Event{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, ok := importFederatedEvent(tt.event, "b", "a")
+			if ok != tt.ok {
+				t.Fatalf("expected ok %v, got %v", tt.ok, ok)
+			}
+			if !ok {
+				return
+			}
+			if event.Kind != tt.expected.Kind || (event.Vouch != nil && *event.Vouch != *tt.expected.Vouch) || (event.Proof != nil && *event.Proof != *tt.expected.Proof) {
+				t.Fatalf("expected %#v, got %#v", tt.expected, event)
+			}
+		})
+	}
+}
+
+func TestFederationPullsSignedEvents(t *testing.T) {
+	b, serverB := newTestInstance(t, "b")
+	a, _ := newTestInstance(t, "a", FederationPeer{Name: "b", URL: serverB.URL, KeyID: b.signer().ID})
+	now := time.Now().UTC()
+	a.now = func() time.Time { return now }
+	b.now = func() time.Time { return now }
+
+	b.SetProof(ProofEvent{User: "alice", Balance: 1000, Timestamp: now})
+	VouchHandler(b, "alice", "sig", "nonce", "carol@a", 0, time.Time{}, 0)
+	// Vouches b imported from others are not forwarded
+	b.AddVouch(VouchEvent{From: "mallory@c", To: "carol@a", Timestamp: now})
+
+	published := 0
+	a.events.Subscribe("", func(BusEvent) { published++ })
+	peer := newPeerSync(a.federation.Peers[0])
+	count, err := peer.pull(context.Background(), a)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 2 || published != 2 {
+		t.Fatalf("expected 2 imported and published events, got %d and %d", count, published)
+	}
+	incoming := a.UserVouchesTo("carol")
+	if len(incoming) != 1 || incoming[0].From != "alice@b" {
+		t.Fatalf("expected a vouch from alice@b, got %#v", incoming)
+	}
+
+	// The remote voucher's balance is discounted by half
+	info, _ := IdtHandler(a, "carol")
+	if info.Balance != 50 {
+		t.Fatalf("expected discounted balance 50, got %d", info.Balance)
+	}
+	a.federation.RemoteDiscount = 0
+	info, _ = IdtHandler(a, "carol")
+	if info.Balance != 100 {
+		t.Fatalf("expected undiscounted balance 100, got %d", info.Balance)
+	}
+
+	// Later pulls only import new events
+	VouchHandler(b, "alice", "sig", "nonce", "dave@a", 0, time.Time{}, 0)
+	count, err = peer.pull(context.Background(), a)
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 imported event, got %d/%v", count, err)
+	}
+}
+
+func TestFederationRestartDoesNotRepeatWebhooks(t *testing.T) {
+	b, serverB := newTestInstance(t, "b")
+	a, _ := newTestInstance(t, "a", FederationPeer{Name: "b", URL: serverB.URL, KeyID: b.signer().ID})
+	receiver := &webhookReceiver{t: t, secret: []byte("secret"), now: a.currentTime}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	dispatcher := NewWebhookDispatcher(a, WebhookConfig{
+		Subscriptions: []WebhookSubscription{{URL: server.URL, Events: []WebhookEventType{WebhookEventVouch, WebhookEventProof}}},
+		Secret:        receiver.secret,
+	})
+
+	b.SetProof(ProofEvent{User: "alice", Balance: 1000, Timestamp: time.Now().UTC()})
+	VouchHandler(b, "alice", "sig", "nonce", "carol@a", 0, time.Time{}, 0)
+	if _, err := newPeerSync(a.federation.Peers[0]).pull(context.Background(), a); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dispatcher.step(context.Background(), a)
+	if received := receiver.received(); len(received) != 2 {
+		t.Fatalf("expected 2 notifications, got %#v", received)
+	}
+
+	// A restarted instance pulls the peer from the start again
+	if _, err := newPeerSync(a.federation.Peers[0]).pull(context.Background(), a); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dispatcher.step(context.Background(), a)
+	deliveries, _ := WebhookDeliveriesHandler(a, "")
+	if received := receiver.received(); len(received) != 2 || len(deliveries) != 2 {
+		t.Fatalf("expected no repeated notifications, got %#v", deliveries)
+	}
+}
+
+func TestFederationRejectsMismatchedInstance(t *testing.T) {
+	c, server := newTestInstance(t, "c")
+	a, _ := newTestInstance(t, "a")
+
+	// The peer is configured as b but signs its events as c
+	peer := newPeerSync(FederationPeer{Name: "b", URL: server.URL, KeyID: c.signer().ID})
+	if _, err := peer.pull(context.Background(), a); err == nil {
+		t.Fatal("expected error for events signed by another instance")
+	}
+}
+
+func TestFederationRejectsUnpinnedKeys(t *testing.T) {
+	b, server := newTestInstance(t, "b")
+	a, _ := newTestInstance(t, "a")
+	b.SetProof(ProofEvent{User: "alice", Balance: 1000, Timestamp: time.Now().UTC()})
+	other, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The peer signs with a key other than the pinned one
+	peer := newPeerSync(FederationPeer{Name: "b", URL: server.URL, KeyID: other.ID})
+	if _, err := peer.pull(context.Background(), a); err == nil {
+		t.Fatal("expected error for a batch signed by another key")
+	}
+
+	// The peer serves another key under the pinned ID
+	forged := other.JWK()
+	forged.X = b.signer().JWK().X
+	if _, err := peer.pinnedKey(JWKSet{Keys: []JWK{forged}}); err == nil {
+		t.Fatal("expected error for a key that does not match the pinned ID")
+	}
+	if proof, _ := a.ProofRecord("alice@b"); !proof.Timestamp.IsZero() {
+		t.Fatalf("expected no imported proof, got %#v", proof)
+	}
+}
+
+func TestVouchRejectsRemoteVoucher(t *testing.T) {
+	state := NewAppState()
+	if err := VouchHandler(state, "alice@b", "sig", "nonce", "carol", 0, time.Time{}, 0); err != ErrRemoteUser {
+		t.Fatalf("expected %v, got %v", ErrRemoteUser, err)
+	}
+}
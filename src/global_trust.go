@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Selects how a user's balance is computed.
+type ScoringMode string
+
+const (
+	// Aggregates the top vouchers of the user's incoming vouch tree (see Balance).
+	ScoringModeTree ScoringMode = "tree"
+	// Distributes proven balances over the whole vouch graph with
+	// personalized PageRank (see GlobalBalance).
+	ScoringModePageRank ScoringMode = "pagerank"
+)
+
+// Probability of following a vouch instead of jumping back to a proven user.
+const pageRankDamping = 0.85
+
+// Iteration stops once the total rank change drops below this value.
+const pageRankTolerance = 1e-9
+
+const pageRankMaxIterations = 100
+
+// Parses a scoring mode name. An empty name selects the default mode.
+func ParseScoringMode(name string, defaultMode ScoringMode) (ScoringMode, IdentityError) {
+	switch ScoringMode(name) {
+	case "":
+		return defaultMode, nil
+	case ScoringModeTree, ScoringModePageRank:
+		return ScoringMode(name), nil
+	}
+	return "", ErrUnknownScoringMode
+}
+
+// Computes EigenTrust-style global trust with personalized PageRank.
+// Each user splits its trust between the users it vouches for in proportion
+// to the vouch weights, and with probability 1 - pageRankDamping trust
+// returns to proven users in proportion to their decayed proof balance.
+// Users without outgoing vouches return all their trust to proven users.
+// The ranks sum to 1, or are all zero if there are no proven users.
+func GlobalTrust(state *AppState, now time.Time) map[string]float64 {
+	users := state.Users()
+	ranks := make(map[string]float64, len(users))
+
+	// Pre-trusted distribution over proven users
+	seeds := make(map[string]float64)
+	seedTotal := 0.0
+	for _, user := range users {
+		ranks[user] = 0
+		proof, err := state.ProofRecord(user)
+		if err != nil {
+			continue
+		}
+		if balance := DecayedAmount(proof.Balance, proof.Timestamp, now); balance > 0 {
+			seeds[user] = float64(balance)
+			seedTotal += float64(balance)
+		}
+	}
+	if seedTotal == 0 {
+		return ranks
+	}
+	for user := range seeds {
+		seeds[user] /= seedTotal
+		ranks[user] = seeds[user]
+	}
+
+	outgoing := make(map[string][]VouchEvent, len(users))
+	outgoingWeights := make(map[string]float64, len(users))
+	for _, user := range users {
+		for _, vouch := range state.UserVouchesFrom(user) {
+			// Snapshot queries ignore vouches made after `now` or expired by then
+			if vouch.Timestamp.After(now) || vouch.ExpiredAt(now) {
+				continue
+			}
+			outgoing[user] = append(outgoing[user], vouch)
+			outgoingWeights[user] += float64(vouch.EffectiveWeight())
+		}
+	}
+
+	for i := 0; i < pageRankMaxIterations; i++ {
+		next := make(map[string]float64, len(ranks))
+		dangling := 0.0
+		for user, rank := range ranks {
+			peers := outgoing[user]
+			if len(peers) == 0 {
+				dangling += rank
+				continue
+			}
+			share := pageRankDamping * rank / outgoingWeights[user]
+			for _, vouch := range peers {
+				next[vouch.To] += share * float64(vouch.EffectiveWeight())
+			}
+		}
+		// Teleport and dangling trust go back to proven users
+		returned := (1 - pageRankDamping) + pageRankDamping*dangling
+		for user, weight := range seeds {
+			next[user] += returned * weight
+		}
+
+		delta := 0.0
+		for _, user := range users {
+			delta += math.Abs(next[user] - ranks[user])
+		}
+		ranks = next
+		if delta < pageRankTolerance {
+			break
+		}
+	}
+
+	for _, user := range users {
+		if _, ok := ranks[user]; !ok {
+			ranks[user] = 0
+		}
+	}
+	return ranks
+}
+
+// Computes a user's balance from global trust.
+// The sum of decayed proof balances is distributed according to GlobalTrust,
+// and the user's aggregated penalty is subtracted as in Balance.
+// Optional parameter `now` allows to compute the balance at a specific point
+// in time.
+func GlobalBalance(state *AppState, user string, now *time.Time) int64 {
+	if now == nil {
+		currentTime := state.currentTime()
+		now = &currentTime
+	}
+	return newGlobalBalances(state, *now).balance(user)
+}
+
+// Holds global trust at a point in time, so that the balances of many users
+// can be computed from a single GlobalTrust run.
+type globalBalances struct {
+	state *AppState
+	now   time.Time
+	ranks map[string]float64
+	// sum of decayed proof balances distributed by the ranks
+	total uint64
+}
+
+func newGlobalBalances(state *AppState, now time.Time) globalBalances {
+	total := uint64(0)
+	for _, u := range state.Users() {
+		proof, err := state.ProofRecord(u)
+		if err != nil {
+			continue
+		}
+		total += DecayedAmount(proof.Balance, proof.Timestamp, now)
+	}
+	return globalBalances{state: state, now: now, ranks: GlobalTrust(state, now), total: total}
+}
+
+// Returns the user's global balance, see GlobalBalance.
+func (g globalBalances) balance(user string) int64 {
+	balance := int64(g.ranks[user] * float64(g.total))
+	return balance - int64(Penalty(g.state, user, nil, &g.now))
+}
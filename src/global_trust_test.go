@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGlobalTrustWithoutProvenUsers(t *testing.T) {
+	state := NewAppState()
+	state.AddVouch(VouchEvent{From: "alice", To: "bob"})
+	ranks := GlobalTrust(state, time.Now().UTC())
+	if ranks["alice"] != 0 || ranks["bob"] != 0 {
+		t.Fatalf("expected zero ranks, got %#v", ranks)
+	}
+}
+
+func TestGlobalTrustChain(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return timestamp }
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp})
+
+	ranks := GlobalTrust(state, timestamp)
+	// alice = (1 - d) + d * bob, bob = d * alice
+	expectedAlice := (1 - pageRankDamping) / (1 - pageRankDamping*pageRankDamping)
+	if math.Abs(ranks["alice"]-expectedAlice) > 1e-6 {
+		t.Fatalf("expected alice rank %v, got %v", expectedAlice, ranks["alice"])
+	}
+	if math.Abs(ranks["bob"]-pageRankDamping*expectedAlice) > 1e-6 {
+		t.Fatalf("expected bob rank %v, got %v", pageRankDamping*expectedAlice, ranks["bob"])
+	}
+
+	if got := GlobalBalance(state, "alice", nil); got != 54 {
+		t.Fatalf("expected alice balance 54, got %d", got)
+	}
+	if got := GlobalBalance(state, "bob", nil); got != 45 {
+		t.Fatalf("expected bob balance 45, got %d", got)
+	}
+}
+
+func TestGlobalTrustIgnoresDisconnectedRing(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "s1", To: "s2", Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "s2", To: "s3", Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "s3", To: "s1", Timestamp: timestamp})
+
+	ranks := GlobalTrust(state, timestamp)
+	for _, sybil := range []string{"s1", "s2", "s3"} {
+		if ranks[sybil] != 0 {
+			t.Fatalf("expected zero rank for %s, got %v", sybil, ranks[sybil])
+		}
+	}
+	total := 0.0
+	for _, rank := range ranks {
+		total += rank
+	}
+	if math.Abs(total-1) > 1e-6 {
+		t.Fatalf("expected ranks to sum to 1, got %v", total)
+	}
+}
+
+func TestGlobalBalanceSubtractsPenalty(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return timestamp }
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+	state.AddPenalty(PenaltyEvent{User: "alice", Amount: 30, Timestamp: timestamp})
+
+	if got := GlobalBalance(state, "alice", nil); got != 70 {
+		t.Fatalf("expected balance 70, got %d", got)
+	}
+}
+
+func TestParseScoringMode(t *testing.T) {
+	if mode, err := ParseScoringMode("", ScoringModePageRank); err != nil || mode != ScoringModePageRank {
+		t.Fatalf("expected default mode, got %q/%v", mode, err)
+	}
+	if mode, err := ParseScoringMode("tree", ScoringModePageRank); err != nil || mode != ScoringModeTree {
+		t.Fatalf("expected tree mode, got %q/%v", mode, err)
+	}
+	if _, err := ParseScoringMode("eigen", ScoringModeTree); err != ErrUnknownScoringMode {
+		t.Fatalf("expected ErrUnknownScoringMode, got %v", err)
+	}
+}
+
+func TestIdtHandlerUsesDefaultScoring(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return timestamp }
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp})
+
+	info, err := IdtHandler(state, "bob")
+	if err != nil || info.Scoring != ScoringModeTree || info.Balance != 10 {
+		t.Fatalf("unexpected tree info: %#v/%v", info, err)
+	}
+
+	state.scoring = ScoringModePageRank
+	info, err = IdtHandler(state, "bob")
+	if err != nil || info.Scoring != ScoringModePageRank || info.Balance != 45 {
+		t.Fatalf("unexpected pagerank info: %#v/%v", info, err)
+	}
+}
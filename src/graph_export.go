@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Serialization format of an exported vouch graph.
+type GraphFormat string
+
+const (
+	GraphFormatDOT     GraphFormat = "dot"
+	GraphFormatGraphML GraphFormat = "graphml"
+	GraphFormatJSON    GraphFormat = "json"
+)
+
+// Represents a user in an exported graph, annotated with identity scores.
+type GraphNode struct {
+	ID      string `json:"id"`
+	Balance int64  `json:"balance"`
+	Penalty uint64 `json:"penalty"`
+}
+
+// Represents a vouch in an exported graph.
+type GraphEdge struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Timestamp time.Time `json:"timestamp"`
+	Weight    uint64    `json:"weight"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// Represents a vouch graph with nodes and edges sorted for stable output.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// Parses a graph format name, defaulting to JSON when empty.
+func ParseGraphFormat(name string) (GraphFormat, error) {
+	switch GraphFormat(name) {
+	case "", GraphFormatJSON:
+		return GraphFormatJSON, nil
+	case GraphFormatDOT:
+		return GraphFormatDOT, nil
+	case GraphFormatGraphML:
+		return GraphFormatGraphML, nil
+	}
+	return "", fmt.Errorf("unsupported graph format %q", name)
+}
+
+// Returns the MIME type of the graph format.
+func (f GraphFormat) ContentType() string {
+	switch f {
+	case GraphFormatDOT:
+		return "text/vnd.graphviz"
+	case GraphFormatGraphML:
+		return "application/graphml+xml"
+	}
+	return "application/json"
+}
+
+// Builds an annotated graph from the given users and vouches.
+func newGraph(state *AppState, users map[string]struct{}, vouches []VouchEvent) Graph {
+	graph := Graph{Nodes: make([]GraphNode, 0, len(users)), Edges: make([]GraphEdge, 0, len(vouches))}
+	for user := range users {
+		info, _ := IdtHandler(state, user)
+		graph.Nodes = append(graph.Nodes, GraphNode{ID: user, Balance: info.Balance, Penalty: info.Penalty})
+	}
+	for _, vouch := range vouches {
+		graph.Edges = append(graph.Edges, GraphEdge{
+			From:      vouch.From,
+			To:        vouch.To,
+			Timestamp: vouch.Timestamp,
+			Weight:    vouch.EffectiveWeight(),
+			ExpiresAt: vouch.ExpiresAt,
+		})
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].ID < graph.Nodes[j].ID })
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		return graph.Edges[i].To < graph.Edges[j].To
+	})
+	return graph
+}
+
+// Returns the whole vouch graph.
+func FullGraph(state *AppState) Graph {
+	users := make(map[string]struct{})
+	vouches := []VouchEvent{}
+	for _, user := range state.Users() {
+		users[user] = struct{}{}
+		vouches = append(vouches, state.UserVouchesFrom(user)...)
+	}
+	return newGraph(state, users, vouches)
+}
+
+// Returns the users and vouches of a vouch tree. Users that appear on several
+// branches of the tree are merged into one node.
+func TreeGraph(state *AppState, tree *VouchTreeNode) Graph {
+	users := make(map[string]struct{})
+	type edgeKey struct{ from, to string }
+	edges := make(map[edgeKey]VouchEvent)
+	WalkTreePostOrder(tree, func(node *VouchTreeNode, _ map[*VouchTreeNode]struct{}) struct{} {
+		users[node.User] = struct{}{}
+		for _, edge := range node.Peers {
+			if edge.Peer == nil {
+				continue
+			}
+			edges[edgeKey{edge.Event.From, edge.Event.To}] = edge.Event
+		}
+		return struct{}{}
+	})
+
+	vouches := make([]VouchEvent, 0, len(edges))
+	for _, vouch := range edges {
+		vouches = append(vouches, vouch)
+	}
+	return newGraph(state, users, vouches)
+}
+
+// Writes the graph in the given format.
+func WriteGraph(w io.Writer, graph Graph, format GraphFormat) error {
+	switch format {
+	case GraphFormatDOT:
+		return writeGraphDOT(w, graph)
+	case GraphFormatGraphML:
+		return writeGraphML(w, graph)
+	case GraphFormatJSON:
+		return json.NewEncoder(w).Encode(graph)
+	}
+	return fmt.Errorf("unsupported graph format %q", format)
+}
+
+// Quotes a string as a DOT identifier.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func writeGraphDOT(w io.Writer, graph Graph) error {
+	var buf bytes.Buffer
+	buf.WriteString("digraph vouches {\n")
+	for _, node := range graph.Nodes {
+		label := fmt.Sprintf("%s\nbalance: %d\npenalty: %d", node.ID, node.Balance, node.Penalty)
+		fmt.Fprintf(&buf, "  %s [label=%s, balance=%d, penalty=%d];\n", dotQuote(node.ID), dotQuote(label), node.Balance, node.Penalty)
+	}
+	for _, edge := range graph.Edges {
+		attributes := fmt.Sprintf("timestamp=%s, weight=%d", dotQuote(edge.Timestamp.Format(time.RFC3339Nano)), edge.Weight)
+		if !edge.ExpiresAt.IsZero() {
+			attributes += fmt.Sprintf(", expires_at=%s", dotQuote(edge.ExpiresAt.Format(time.RFC3339Nano)))
+		}
+		fmt.Fprintf(&buf, "  %s -> %s [%s];\n", dotQuote(edge.From), dotQuote(edge.To), attributes)
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Escapes text for use in XML character data and attribute values.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func writeGraphML(w io.Writer, graph Graph) error {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	buf.WriteString(`  <key id="balance" for="node" attr.name="balance" attr.type="long"/>` + "\n")
+	buf.WriteString(`  <key id="penalty" for="node" attr.name="penalty" attr.type="long"/>` + "\n")
+	buf.WriteString(`  <key id="timestamp" for="edge" attr.name="timestamp" attr.type="string"/>` + "\n")
+	buf.WriteString(`  <key id="weight" for="edge" attr.name="weight" attr.type="long"/>` + "\n")
+	buf.WriteString(`  <key id="expires_at" for="edge" attr.name="expires_at" attr.type="string"/>` + "\n")
+	buf.WriteString(`  <graph id="vouches" edgedefault="directed">` + "\n")
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(&buf, "    <node id=\"%s\">\n", xmlEscape(node.ID))
+		fmt.Fprintf(&buf, "      <data key=\"balance\">%d</data>\n", node.Balance)
+		fmt.Fprintf(&buf, "      <data key=\"penalty\">%d</data>\n", node.Penalty)
+		buf.WriteString("    </node>\n")
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&buf, "    <edge source=\"%s\" target=\"%s\">\n", xmlEscape(edge.From), xmlEscape(edge.To))
+		fmt.Fprintf(&buf, "      <data key=\"timestamp\">%s</data>\n", edge.Timestamp.Format(time.RFC3339Nano))
+		fmt.Fprintf(&buf, "      <data key=\"weight\">%d</data>\n", edge.Weight)
+		if !edge.ExpiresAt.IsZero() {
+			fmt.Fprintf(&buf, "      <data key=\"expires_at\">%s</data>\n", edge.ExpiresAt.Format(time.RFC3339Nano))
+		}
+		buf.WriteString("    </edge>\n")
+	}
+	buf.WriteString("  </graph>\n")
+	buf.WriteString("</graphml>\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Creates a state with a small graph: alice -> bob -> carol, dan -> carol.
+func newGraphExportState() *AppState {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return timestamp }
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "bob", To: "carol", Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "dan", To: "carol", Timestamp: timestamp})
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+	state.AddPenalty(PenaltyEvent{User: "carol", Amount: 5, Timestamp: timestamp})
+	return state
+}
+
+func TestFullGraph(t *testing.T) {
+	graph := FullGraph(newGraphExportState())
+	if len(graph.Nodes) != 4 {
+		t.Fatalf("expected 4 nodes, got %d", len(graph.Nodes))
+	}
+	if len(graph.Edges) != 3 {
+		t.Fatalf("expected 3 edges, got %d", len(graph.Edges))
+	}
+	if graph.Nodes[0].ID != "alice" || graph.Nodes[0].Balance != 100 {
+		t.Fatalf("unexpected first node: %#v", graph.Nodes[0])
+	}
+	if graph.Edges[0].From != "alice" || graph.Edges[0].To != "bob" {
+		t.Fatalf("unexpected first edge: %#v", graph.Edges[0])
+	}
+}
+
+func TestTreeGraph(t *testing.T) {
+	state := newGraphExportState()
+	graph := TreeGraph(state, IncomingTree(state, "carol", 1))
+	if len(graph.Nodes) != 3 {
+		t.Fatalf("expected carol and 2 vouchers, got %#v", graph.Nodes)
+	}
+	if len(graph.Edges) != 2 {
+		t.Fatalf("expected 2 edges, got %#v", graph.Edges)
+	}
+	for _, node := range graph.Nodes {
+		if node.ID == "carol" && node.Penalty != 5 {
+			t.Fatalf("expected carol penalty 5, got %d", node.Penalty)
+		}
+	}
+}
+
+func TestWriteGraphFormats(t *testing.T) {
+	graph := FullGraph(newGraphExportState())
+
+	var buf bytes.Buffer
+	if err := WriteGraph(&buf, graph, GraphFormatDOT); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dot := buf.String()
+	if !strings.HasPrefix(dot, "digraph vouches {") || !strings.Contains(dot, `"alice" -> "bob"`) {
+		t.Fatalf("unexpected DOT output:\n%s", dot)
+	}
+
+	buf.Reset()
+	if err := WriteGraph(&buf, graph, GraphFormatGraphML); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var graphml struct {
+		Nodes []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"graph>node"`
+		Edges []struct {
+			Source string `xml:"source,attr"`
+		} `xml:"graph>edge"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &graphml); err != nil {
+		t.Fatalf("invalid GraphML: %v", err)
+	}
+	if len(graphml.Nodes) != 4 || len(graphml.Edges) != 3 {
+		t.Fatalf("unexpected GraphML contents: %#v", graphml)
+	}
+
+	buf.Reset()
+	if err := WriteGraph(&buf, graph, GraphFormatJSON); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded Graph
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(decoded.Nodes) != 4 || len(decoded.Edges) != 3 {
+		t.Fatalf("unexpected JSON graph: %#v", decoded)
+	}
+}
+
+// Verifies that every format carries the vouch expiry.
+func TestWriteGraphExpiry(t *testing.T) {
+	state := newGraphExportState()
+	timestamp := state.currentTime()
+	state.AddVouch(VouchEvent{From: "alice", To: "dan", Timestamp: timestamp, ExpiresAt: timestamp.Add(time.Hour)})
+	graph := FullGraph(state)
+	expiry := "2024-01-02T04:04:05Z"
+
+	var buf bytes.Buffer
+	if err := WriteGraph(&buf, graph, GraphFormatDOT); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dot := buf.String(); !strings.Contains(dot, `"alice" -> "dan" [timestamp="2024-01-02T03:04:05Z", weight=100, expires_at="`+expiry+`"]`) ||
+		strings.Count(dot, "expires_at") != 1 {
+		t.Fatalf("expected the expiry on the alice -> dan edge only:\n%s", dot)
+	}
+
+	buf.Reset()
+	if err := WriteGraph(&buf, graph, GraphFormatGraphML); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var graphml struct {
+		Edges []struct {
+			Target string `xml:"target,attr"`
+			Data   []struct {
+				Key   string `xml:"key,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"data"`
+		} `xml:"graph>edge"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &graphml); err != nil {
+		t.Fatalf("invalid GraphML: %v", err)
+	}
+	expiries := map[string]string{}
+	for _, edge := range graphml.Edges {
+		for _, data := range edge.Data {
+			if data.Key == "expires_at" {
+				expiries[edge.Target] = data.Value
+			}
+		}
+	}
+	if len(expiries) != 1 || expiries["dan"] != expiry {
+		t.Fatalf("expected the expiry on the alice -> dan edge only, got %v", expiries)
+	}
+}
+
+func TestDotQuoteEscapes(t *testing.T) {
+	if got := dotQuote(`a"b\c`); got != `"a\"b\\c"` {
+		t.Fatalf("unexpected quoting: %s", got)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import "sort"
+
+// Represents aggregate statistics of the vouch graph.
+type GraphStats struct {
+	Users       int `json:"users"`
+	Vouches     int `json:"vouches"`
+	ProvenUsers int `json:"proven_users"`
+	// Maps a number of incoming vouches to the number of users having it.
+	InDegrees map[int]int `json:"in_degree_distribution"`
+	// Maps a number of outgoing vouches to the number of users having it.
+	OutDegrees                  map[int]int `json:"out_degree_distribution"`
+	StronglyConnectedComponents int         `json:"strongly_connected_components"`
+	// Share of users reachable from proven users by following vouches.
+	ReachableFromProven float64 `json:"reachable_from_proven"`
+	AverageBalance      float64 `json:"average_balance"`
+}
+
+// Computes statistics over all users and vouches.
+// Expired vouches are left out, as in the balance computation.
+// Balances use the deployment's default scoring mode.
+func ComputeGraphStats(state *AppState) GraphStats {
+	now := state.currentTime()
+	users := state.Users()
+	sort.Strings(users)
+
+	stats := GraphStats{
+		Users:      len(users),
+		InDegrees:  make(map[int]int),
+		OutDegrees: make(map[int]int),
+	}
+
+	outgoing := make(map[string][]string, len(users))
+	inDegrees := make(map[string]int, len(users))
+	for _, user := range users {
+		for _, vouch := range state.UserVouchesFrom(user) {
+			if vouch.ExpiredAt(now) {
+				continue
+			}
+			outgoing[user] = append(outgoing[user], vouch.To)
+			inDegrees[vouch.To]++
+			stats.Vouches++
+		}
+	}
+	for _, user := range users {
+		stats.InDegrees[inDegrees[user]]++
+		stats.OutDegrees[len(outgoing[user])]++
+	}
+
+	proven := provenUsers(state, users)
+	stats.ProvenUsers = len(proven)
+	stats.StronglyConnectedComponents = countStronglyConnectedComponents(users, outgoing)
+	if len(users) > 0 {
+		stats.ReachableFromProven = float64(countReachable(proven, outgoing)) / float64(len(users))
+
+		// Global trust is computed once for all users rather than per user
+		balance := func(user string) int64 { return Balance(state, user, nil, &now) }
+		if state.defaultScoring() == ScoringModePageRank {
+			balance = newGlobalBalances(state, now).balance
+		}
+		total := int64(0)
+		for _, user := range users {
+			total += balance(user)
+		}
+		stats.AverageBalance = float64(total) / float64(len(users))
+	}
+	return stats
+}
+
+// Returns the number of users reachable from the sources, including the sources.
+func countReachable(sources []string, outgoing map[string][]string) int {
+	visited := make(map[string]bool, len(sources))
+	stack := []string{}
+	for _, source := range sources {
+		if !visited[source] {
+			visited[source] = true
+			stack = append(stack, source)
+		}
+	}
+	for len(stack) > 0 {
+		user := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, peer := range outgoing[user] {
+			if !visited[peer] {
+				visited[peer] = true
+				stack = append(stack, peer)
+			}
+		}
+	}
+	return len(visited)
+}
+
+// Counts strongly connected components with an iterative Tarjan's algorithm.
+func countStronglyConnectedComponents(users []string, outgoing map[string][]string) int {
+	index := make(map[string]int, len(users))
+	lowlink := make(map[string]int, len(users))
+	onStack := make(map[string]bool, len(users))
+	componentStack := []string{}
+	nextIndex := 0
+	components := 0
+
+	type frame struct {
+		user string
+		// next outgoing vouch to visit
+		edge int
+	}
+
+	for _, root := range users {
+		if _, ok := index[root]; ok {
+			continue
+		}
+		callStack := []frame{{user: root}}
+		index[root] = nextIndex
+		lowlink[root] = nextIndex
+		nextIndex++
+		componentStack = append(componentStack, root)
+		onStack[root] = true
+
+		for len(callStack) > 0 {
+			top := &callStack[len(callStack)-1]
+			peers := outgoing[top.user]
+			if top.edge < len(peers) {
+				peer := peers[top.edge]
+				top.edge++
+				if _, ok := index[peer]; !ok {
+					index[peer] = nextIndex
+					lowlink[peer] = nextIndex
+					nextIndex++
+					componentStack = append(componentStack, peer)
+					onStack[peer] = true
+					callStack = append(callStack, frame{user: peer})
+				} else if onStack[peer] {
+					lowlink[top.user] = min(lowlink[top.user], index[peer])
+				}
+				continue
+			}
+
+			// All peers visited, close the component if this user is its root
+			user := top.user
+			callStack = callStack[:len(callStack)-1]
+			if lowlink[user] == index[user] {
+				for {
+					member := componentStack[len(componentStack)-1]
+					componentStack = componentStack[:len(componentStack)-1]
+					onStack[member] = false
+					if member == user {
+						break
+					}
+				}
+				components++
+			}
+			if len(callStack) > 0 {
+				parent := callStack[len(callStack)-1].user
+				lowlink[parent] = min(lowlink[parent], lowlink[user])
+			}
+		}
+	}
+	return components
+}
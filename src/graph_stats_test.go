@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeGraphStatsEmpty(t *testing.T) {
+	stats := ComputeGraphStats(NewAppState())
+	if stats.Users != 0 || stats.Vouches != 0 || stats.StronglyConnectedComponents != 0 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+	if stats.ReachableFromProven != 0 || stats.AverageBalance != 0 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+}
+
+func TestComputeGraphStats(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return timestamp }
+	// alice <-> bob form one component, carol and dan are separate components
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "bob", To: "alice", Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "bob", To: "carol", Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "dan", To: "carol", Timestamp: timestamp})
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+
+	stats := ComputeGraphStats(state)
+	if stats.Users != 4 {
+		t.Fatalf("expected 4 users, got %d", stats.Users)
+	}
+	if stats.Vouches != 4 {
+		t.Fatalf("expected 4 vouches, got %d", stats.Vouches)
+	}
+	if stats.ProvenUsers != 1 {
+		t.Fatalf("expected 1 proven user, got %d", stats.ProvenUsers)
+	}
+	if stats.StronglyConnectedComponents != 3 {
+		t.Fatalf("expected 3 strongly connected components, got %d", stats.StronglyConnectedComponents)
+	}
+	// alice, bob and carol are reachable from alice
+	if stats.ReachableFromProven != 0.75 {
+		t.Fatalf("expected reachable fraction 0.75, got %v", stats.ReachableFromProven)
+	}
+	// in-degrees: alice 1, bob 1, carol 2, dan 0
+	if stats.InDegrees[0] != 1 || stats.InDegrees[1] != 2 || stats.InDegrees[2] != 1 {
+		t.Fatalf("unexpected in-degree distribution: %#v", stats.InDegrees)
+	}
+	// out-degrees: alice 1, bob 2, carol 0, dan 1
+	if stats.OutDegrees[0] != 1 || stats.OutDegrees[1] != 2 || stats.OutDegrees[2] != 1 {
+		t.Fatalf("unexpected out-degree distribution: %#v", stats.OutDegrees)
+	}
+	// alice 100 + bob 10 + carol 1 + dan 0
+	if stats.AverageBalance != 111.0/4 {
+		t.Fatalf("expected average balance %v, got %v", 111.0/4, stats.AverageBalance)
+	}
+}
+
+func TestCountStronglyConnectedComponentsCycle(t *testing.T) {
+	users := []string{"a", "b", "c", "d"}
+	outgoing := map[string][]string{
+		"a": {"b"},
+		"b": {"c"},
+		"c": {"a", "d"},
+	}
+	if got := countStronglyConnectedComponents(users, outgoing); got != 2 {
+		t.Fatalf("expected 2 components, got %d", got)
+	}
+}
+
+// Verifies that expired vouches do not count as edges of the graph.
+func TestComputeGraphStatsSkipsExpiredVouches(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return timestamp }
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp.Add(-2 * time.Hour), ExpiresAt: timestamp.Add(-time.Hour)})
+	state.AddVouch(VouchEvent{From: "alice", To: "carol", Timestamp: timestamp})
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+
+	stats := ComputeGraphStats(state)
+	if stats.Vouches != 1 {
+		t.Fatalf("expected 1 vouch, got %d", stats.Vouches)
+	}
+	// in-degrees: alice 0, bob 0, carol 1
+	if stats.InDegrees[0] != 2 || stats.InDegrees[1] != 1 {
+		t.Fatalf("unexpected in-degree distribution: %#v", stats.InDegrees)
+	}
+	// alice and carol are reachable from alice
+	if stats.ReachableFromProven != 2.0/3 {
+		t.Fatalf("expected reachable fraction %v, got %v", 2.0/3, stats.ReachableFromProven)
+	}
+}
+
+// Verifies that pagerank balances match the per-user computation.
+func TestComputeGraphStatsPageRank(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return timestamp }
+	state.scoring = ScoringModePageRank
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp})
+	state.AddVouch(VouchEvent{From: "bob", To: "carol", Timestamp: timestamp})
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+
+	total := int64(0)
+	for _, user := range []string{"alice", "bob", "carol"} {
+		total += GlobalBalance(state, user, nil)
+	}
+	stats := ComputeGraphStats(state)
+	if stats.AverageBalance != float64(total)/3 {
+		t.Fatalf("expected average balance %v, got %v", float64(total)/3, stats.AverageBalance)
+	}
+}
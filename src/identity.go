@@ -1,15 +1,41 @@
 package main
 
+import "strings"
+
 // User's identity information
 type IdtInfo struct {
 	User    string
 	Balance int64
 	Penalty uint64
+	Scoring ScoringMode
+	Tier    Tier
+}
+
+// Reports whether the user ID can be stored. NUL bytes are rejected because
+// they separate the parts of composite storage keys.
+func validUserID(user string) bool {
+	return !strings.ContainsRune(user, 0)
 }
 
-// Handles identity requests
+// Handles identity requests using the deployment's default scoring mode
 func IdtHandler(state *AppState, user string) (IdtInfo, IdentityError) {
-	userBalance := Balance(state, user, nil, nil)
+	return ScoredIdtHandler(state, user, state.defaultScoring())
+}
+
+// Handles identity requests with an explicit scoring mode
+func ScoredIdtHandler(state *AppState, user string, scoring ScoringMode) (IdtInfo, IdentityError) {
+	var userBalance int64
+	switch scoring {
+	case ScoringModeTree:
+		userBalance = Balance(state, user, nil, nil)
+	case ScoringModePageRank:
+		userBalance = GlobalBalance(state, user, nil)
+	default:
+		return IdtInfo{}, ErrUnknownScoringMode
+	}
 	userPenalty := Penalty(state, user, nil, nil)
-	return IdtInfo{User: user, Balance: userBalance, Penalty: userPenalty}, nil
+	proof, err := state.ProofRecord(user)
+	proven := err == nil && proof.Balance > 0
+	tier := ComputeTier(state.tierThresholds(), userBalance, userPenalty, proven)
+	return IdtInfo{User: user, Balance: userBalance, Penalty: userPenalty, Scoring: scoring, Tier: tier}, nil
 }
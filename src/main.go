@@ -1,13 +1,16 @@
 package main
 
 import (
-	"fmt"
 	"log"
-	"net/http"
+	"os"
 )
 
 func main() {
-	router := SetupRouter()
-	log.Printf("Starting server on :%d\n", PORT)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", PORT), router))
+	args := os.Args[1:]
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	if err := RunCommand(args, os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
 }
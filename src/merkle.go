@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+)
+
+// Domain separation prefixes of leaf and interior node hashes (RFC 6962), so
+// that an interior node cannot be passed off as a leaf.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// Represents a Merkle tree over sorted leaf hashes, hashed as in RFC 6962.
+// Sorting makes the tree independent of storage order and allows proving
+// that a leaf is absent by proving its neighbours are adjacent.
+type MerkleTree struct {
+	leaves [][]byte
+}
+
+// Represents the path from a leaf to the root of a tree.
+// Hashes are hex encoded, and the path starts next to the leaf.
+type MerkleProof struct {
+	LeafHash string   `json:"leaf_hash"`
+	Index    int      `json:"index"`
+	Path     []string `json:"path"`
+}
+
+// Returns the leaf hash of an event. Timestamps are normalized to UTC so that
+// the hash does not depend on the storage backend.
+func EventLeafHash(event Event) []byte {
+	switch event.Kind {
+	case EventKindVouch:
+		vouch := *event.Vouch
+		vouch.Timestamp = vouch.Timestamp.UTC()
+		vouch.ExpiresAt = vouch.ExpiresAt.UTC()
+		event = EventFromVouch(vouch)
+	case EventKindProof:
+		proof := *event.Proof
+		proof.Timestamp = proof.Timestamp.UTC()
+		event = EventFromProof(proof)
+	case EventKindPenalty:
+		penalty := *event.Penalty
+		penalty.Timestamp = penalty.Timestamp.UTC()
+		event = EventFromPenalty(penalty)
+	case EventKindKey:
+		key := *event.Key
+		key.Timestamp = key.Timestamp.UTC()
+		event = EventFromKey(key)
+	}
+	// Marshaling a struct of strings, numbers and times cannot fail
+	data, _ := json.Marshal(event)
+	return hashMerkleLeaf(data)
+}
+
+func hashMerkleLeaf(data []byte) []byte {
+	sum := sha256.Sum256(append([]byte{merkleLeafPrefix}, data...))
+	return sum[:]
+}
+
+func hashMerkleNode(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Returns the largest power of two smaller than n, for n > 1.
+func merkleSplit(n int) int {
+	k := 1
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
+
+// Creates a tree over the leaf hashes.
+func NewMerkleTree(leaves [][]byte) *MerkleTree {
+	sorted := slices.Clone(leaves)
+	slices.SortFunc(sorted, bytes.Compare)
+	return &MerkleTree{leaves: sorted}
+}
+
+// Returns the number of leaves.
+func (t *MerkleTree) Size() int {
+	return len(t.leaves)
+}
+
+// Returns the root hash. The root of an empty tree is the hash of no data.
+func (t *MerkleTree) Root() []byte {
+	return merkleRoot(t.leaves)
+}
+
+func merkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+	k := merkleSplit(len(leaves))
+	return hashMerkleNode(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// Returns the position at which the leaf is or would be in the tree, and
+// whether it is in the tree.
+func (t *MerkleTree) Search(leaf []byte) (int, bool) {
+	return slices.BinarySearchFunc(t.leaves, leaf, bytes.Compare)
+}
+
+// Returns the inclusion proof of the leaf at the index.
+func (t *MerkleTree) InclusionProof(index int) MerkleProof {
+	proof := MerkleProof{LeafHash: hex.EncodeToString(t.leaves[index]), Index: index, Path: []string{}}
+	for _, hash := range merklePath(index, t.leaves) {
+		proof.Path = append(proof.Path, hex.EncodeToString(hash))
+	}
+	return proof
+}
+
+func merklePath(index int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := merkleSplit(len(leaves))
+	if index < k {
+		return append(merklePath(index, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+	return append(merklePath(index-k, leaves[k:]), merkleRoot(leaves[:k]))
+}
+
+// Checks that the proof leads from its leaf to the root of a tree of the
+// given size (RFC 9162, section 2.1.3.2).
+func VerifyInclusion(proof MerkleProof, size int, root []byte) bool {
+	if proof.Index < 0 || proof.Index >= size {
+		return false
+	}
+	hash, err := hex.DecodeString(proof.LeafHash)
+	if err != nil {
+		return false
+	}
+	fn, sn := proof.Index, size-1
+	for _, encoded := range proof.Path {
+		sibling, err := hex.DecodeString(encoded)
+		if err != nil || sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			hash = hashMerkleNode(sibling, hash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			hash = hashMerkleNode(hash, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(hash, root)
+}
+
+// Checks that the leaf is not in a tree of the given size. The proofs are
+// for the leaves just before and after the position the leaf would have;
+// `before` is nil if the leaf would be first, `after` if it would be last.
+func VerifyNonInclusion(leaf []byte, before *MerkleProof, after *MerkleProof, size int, root []byte) bool {
+	if before == nil && after == nil {
+		// Only the empty tree has no neighbours
+		return size == 0 && bytes.Equal(root, merkleRoot(nil))
+	}
+	if before != nil {
+		hash, err := hex.DecodeString(before.LeafHash)
+		if err != nil || bytes.Compare(hash, leaf) >= 0 || !VerifyInclusion(*before, size, root) {
+			return false
+		}
+	}
+	if after != nil {
+		hash, err := hex.DecodeString(after.LeafHash)
+		if err != nil || bytes.Compare(hash, leaf) <= 0 || !VerifyInclusion(*after, size, root) {
+			return false
+		}
+	}
+	switch {
+	case before == nil:
+		return after.Index == 0
+	case after == nil:
+		return before.Index == size-1
+	}
+	return after.Index == before.Index+1
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// Returns n distinct leaf hashes.
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = hashMerkleLeaf([]byte{byte(i), byte(i >> 8)})
+	}
+	return leaves
+}
+
+func TestMerkleRoot(t *testing.T) {
+	empty := sha256.Sum256(nil)
+	if root := NewMerkleTree(nil).Root(); !bytes.Equal(root, empty[:]) {
+		t.Fatalf("unexpected empty root %x", root)
+	}
+
+	leaves := NewMerkleTree(testLeaves(3)).leaves
+	expected := hashMerkleNode(hashMerkleNode(leaves[0], leaves[1]), leaves[2])
+	if root := NewMerkleTree(testLeaves(3)).Root(); !bytes.Equal(root, expected) {
+		t.Fatalf("expected root %x, got %x", expected, root)
+	}
+
+	// The root does not depend on the order of the leaves
+	reversed := testLeaves(5)
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	if !bytes.Equal(NewMerkleTree(reversed).Root(), NewMerkleTree(testLeaves(5)).Root()) {
+		t.Fatal("expected the same root for reordered leaves")
+	}
+}
+
+func TestMerkleInclusionProofs(t *testing.T) {
+	for size := 1; size <= 17; size++ {
+		tree := NewMerkleTree(testLeaves(size))
+		root := tree.Root()
+		for index := range size {
+			proof := tree.InclusionProof(index)
+			if !VerifyInclusion(proof, size, root) {
+				t.Fatalf("proof of leaf %d in tree of size %d did not verify", index, size)
+			}
+			if VerifyInclusion(proof, size, hashMerkleLeaf(nil)) {
+				t.Fatalf("proof of leaf %d in tree of size %d verified for the wrong root", index, size)
+			}
+			if size > 1 {
+				tampered := proof
+				tampered.Index = (index + 1) % size
+				if VerifyInclusion(tampered, size, root) {
+					t.Fatalf("proof of leaf %d verified for the wrong index", index)
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleNonInclusion(t *testing.T) {
+	tree := NewMerkleTree(testLeaves(6))
+	root := tree.Root()
+	absent := hashMerkleLeaf([]byte("absent"))
+
+	index, found := tree.Search(absent)
+	if found {
+		t.Fatal("expected the leaf to be absent")
+	}
+	var before, after *MerkleProof
+	if index > 0 {
+		proof := tree.InclusionProof(index - 1)
+		before = &proof
+	}
+	if index < tree.Size() {
+		proof := tree.InclusionProof(index)
+		after = &proof
+	}
+	if !VerifyNonInclusion(absent, before, after, tree.Size(), root) {
+		t.Fatal("non-inclusion proof did not verify")
+	}
+
+	// Neighbours of a present leaf do not prove it absent
+	present := tree.leaves[3]
+	first, second := tree.InclusionProof(2), tree.InclusionProof(4)
+	if VerifyNonInclusion(present, &first, &second, tree.Size(), root) {
+		t.Fatal("expected non-adjacent neighbours to be rejected")
+	}
+	if !VerifyNonInclusion(absent, nil, nil, 0, NewMerkleTree(nil).Root()) {
+		t.Fatal("expected every leaf to be absent from the empty tree")
+	}
+}
+
+func TestEventLeafHashIgnoresTimeZone(t *testing.T) {
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 6, time.UTC)
+	local := timestamp.In(time.FixedZone("UTC+2", 2*60*60))
+	utcHash := EventLeafHash(EventFromProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp}))
+	localHash := EventLeafHash(EventFromProof(ProofEvent{User: "alice", Balance: 100, Timestamp: local}))
+	if !bytes.Equal(utcHash, localHash) {
+		t.Fatalf("expected equal hashes, got %s and %s", hex.EncodeToString(utcHash), hex.EncodeToString(localHash))
+	}
+}
@@ -1,38 +1,57 @@
 package main
 
-import "time"
+import (
+	"crypto/rand"
+	"time"
+)
 
 // Represents a moderation action that sets a user's balance.
 // Only one proof record is stored per user; newer proofs replace older ones.
 type ProofEvent struct {
-	User      string
-	Balance   uint64
-	Timestamp time.Time
+	User      string    `json:"user"`
+	Balance   uint64    `json:"balance"`
+	Timestamp time.Time `json:"timestamp"`
 }
 
 // Represents a moderation action that penalizes a user.
 type PenaltyEvent struct {
-	User      string
-	Amount    uint64
-	Timestamp time.Time
+	// Identifies the penalty across storages, replicas and dumps
+	ID        string    `json:"id,omitempty"`
+	User      string    `json:"user"`
+	Amount    uint64    `json:"amount"`
+	Timestamp time.Time `json:"timestamp"`
+	// ID of the penalty on the vouchee that slashed the stake of this user's
+	// vouch. Slashing penalties share the timestamp of the origin penalty.
+	// Empty for penalties issued directly by moderators.
+	Origin string `json:"origin,omitempty"`
 }
 
 // Sets a user's balance by storing the latest proof record.
 func ProveHandler(state *AppState, user string, balance uint64) IdentityError {
+	if !validUserID(user) {
+		return ErrInvalidUserID
+	}
 	state.SetProof(ProofEvent{
 		User:      user,
 		Balance:   balance,
-		Timestamp: time.Now().UTC(),
+		Timestamp: state.currentTime(),
 	})
 	return nil
 }
 
-// Records a penalty for the user.
+// Records a penalty for the user and slashes the stakes of its vouchers.
 func PunishHandler(state *AppState, user string, amount uint64) IdentityError {
-	state.AddPenalty(PenaltyEvent{
+	if !validUserID(user) {
+		return ErrInvalidUserID
+	}
+	penalty := PenaltyEvent{
+		// Known before storing, so that slashing penalties can refer to it
+		ID:        rand.Text(),
 		User:      user,
 		Amount:    amount,
-		Timestamp: time.Now().UTC(),
-	})
+		Timestamp: state.currentTime(),
+	}
+	state.AddPenalty(penalty)
+	slashStakes(state, penalty)
 	return nil
 }
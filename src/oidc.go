@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// How long an authorization code can be exchanged for tokens.
+const authorizationCodeLifetime = time.Minute
+
+// JWS type of ID tokens. ID tokens are signed with RS256 and access tokens
+// with EdDSA, so an ID token handed to a client cannot be used as an access
+// token.
+const idTokenType = "JWT"
+
+// Represents a relying party allowed to use the OpenID Connect flow.
+// Clients are public: they authenticate with PKCE instead of a secret.
+type OIDCClient struct {
+	ID           string
+	RedirectURIs []string
+}
+
+// Parses a client definition of the form id=uri[,uri...].
+func ParseOIDCClient(value string) (OIDCClient, error) {
+	id, uris, ok := strings.Cut(value, "=")
+	if !ok || id == "" || uris == "" {
+		return OIDCClient{}, fmt.Errorf("invalid client %q, expected id=redirect_uri[,redirect_uri...]", value)
+	}
+	return OIDCClient{ID: id, RedirectURIs: strings.Split(uris, ",")}, nil
+}
+
+// Represents the OpenID Connect discovery document.
+type OIDCDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// Represents the parameters of an authorization request.
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// Represents the claims of an ID token.
+type IDTokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	AuthTime  int64  `json:"auth_time"`
+	Nonce     string `json:"nonce,omitempty"`
+}
+
+// Represents the tokens issued for an authorization code.
+type OIDCTokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// Represents the claims returned by the userinfo endpoint.
+type UserInfo struct {
+	Subject string `json:"sub"`
+	Balance int64  `json:"idt_balance"`
+	Penalty uint64 `json:"idt_penalty"`
+	Tier    Tier   `json:"idt_tier"`
+}
+
+// Represents an issued authorization code waiting to be exchanged.
+type authorizationCode struct {
+	request  AuthorizationRequest
+	authTime time.Time
+}
+
+// Returns the discovery document of the provider.
+func OIDCDiscoveryHandler(state *AppState) OIDCDiscovery {
+	issuer := state.issuerURL()
+	return OIDCDiscovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oidc/authorize",
+		TokenEndpoint:                     issuer + "/oidc/token",
+		UserInfoEndpoint:                  issuer + "/oidc/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{rsaAlgorithm},
+		ScopesSupported:                   []string{"openid"},
+		TokenEndpointAuthMethodsSupported: []string{"none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "idt_balance", "idt_penalty", "idt_tier"},
+	}
+}
+
+// Checks that the client and redirect URI are registered.
+// Errors from this check must not be redirected to the redirect URI.
+func ValidateOIDCClient(state *AppState, clientID string, redirectURI string) IdentityError {
+	client, ok := state.oidcClients[clientID]
+	if !ok || !slices.Contains(client.RedirectURIs, redirectURI) {
+		return ErrInvalidClient
+	}
+	return nil
+}
+
+// Checks the parameters of an authorization request for a registered client.
+// Only the authorization code flow with S256 PKCE is supported.
+func ValidateAuthorizationRequest(state *AppState, req AuthorizationRequest) IdentityError {
+	if err := ValidateOIDCClient(state, req.ClientID, req.RedirectURI); err != nil {
+		return err
+	}
+	if req.ResponseType != "code" || !slices.Contains(strings.Fields(req.Scope), "openid") {
+		return ErrInvalidAuthorizationRequest
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return ErrInvalidAuthorizationRequest
+	}
+	return nil
+}
+
+// Authenticates the user with a signed login challenge and issues an
+// authorization code for the request.
+func AuthorizeHandler(state *AppState, req AuthorizationRequest, user string, nonce string, signature []byte, publicKey []byte) (string, IdentityError) {
+	if err := ValidateAuthorizationRequest(state, req); err != nil {
+		return "", err
+	}
+	if err := verifyChallenge(state, user, nonce, signature, publicKey); err != nil {
+		return "", err
+	}
+	now := state.currentTime()
+	return state.authorizationCodes.issue(user, authorizationCode{request: req, authTime: now}, now.Add(authorizationCodeLifetime), now)
+}
+
+// Exchanges an authorization code for an access token and an ID token.
+// The code verifier must match the PKCE challenge of the authorization request.
+func ExchangeCodeHandler(state *AppState, value string, clientID string, redirectURI string, verifier string) (OIDCTokens, IdentityError) {
+	now := state.currentTime()
+	entry, ok := state.authorizationCodes.consume(value, now)
+	code := entry.value
+	if !ok || code.request.ClientID != clientID || code.request.RedirectURI != redirectURI {
+		return OIDCTokens{}, ErrInvalidGrant
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	if jwsEncoding.EncodeToString(challenge[:]) != code.request.CodeChallenge {
+		return OIDCTokens{}, ErrInvalidGrant
+	}
+
+	accessToken, claims, err := issueAccessToken(state, entry.user, clientID)
+	if err != nil {
+		return OIDCTokens{}, err
+	}
+	idToken, err := state.idTokenSigner().Sign(IDTokenClaims{
+		Issuer:    state.issuerURL(),
+		Subject:   entry.user,
+		Audience:  clientID,
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+		AuthTime:  code.authTime.Unix(),
+		Nonce:     code.request.Nonce,
+	}, idTokenType)
+	if err != nil {
+		return OIDCTokens{}, err
+	}
+	return OIDCTokens{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   claims.ExpiresAt - claims.IssuedAt,
+		IDToken:     idToken,
+		Scope:       code.request.Scope,
+	}, nil
+}
+
+// Returns the current scores of the user the access token was issued to.
+func UserInfoHandler(state *AppState, accessToken string) (UserInfo, IdentityError) {
+	claims, err := VerifyToken(accessToken, state.publicKeys(), state.currentTime())
+	if err != nil {
+		return UserInfo{}, ErrInvalidToken
+	}
+	info, err := IdtHandler(state, claims.Subject)
+	if err != nil {
+		return UserInfo{}, err
+	}
+	return UserInfo{Subject: info.User, Balance: info.Balance, Penalty: info.Penalty, Tier: info.Tier}, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+// Returns a state with a single registered client.
+func newOIDCState() *AppState {
+	state := NewAppState()
+	state.oidcClients = map[string]OIDCClient{
+		"app": {ID: "app", RedirectURIs: []string{"https://app.example/callback"}},
+	}
+	return state
+}
+
+// Returns an authorization request for the registered client with a PKCE
+// challenge derived from the verifier.
+func newAuthorizationRequest(verifier string) AuthorizationRequest {
+	challenge := sha256.Sum256([]byte(verifier))
+	return AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            "app",
+		RedirectURI:         "https://app.example/callback",
+		Scope:               "openid",
+		State:               "xyz",
+		Nonce:               "n-0S6",
+		CodeChallenge:       jwsEncoding.EncodeToString(challenge[:]),
+		CodeChallengeMethod: "S256",
+	}
+}
+
+// Authorizes the request as the user and returns the code.
+func authorizeWithKey(t *testing.T, state *AppState, req AuthorizationRequest, user string, private ed25519.PrivateKey, publicKey []byte) (string, IdentityError) {
+	t.Helper()
+	nonce, _, _ := ChallengeHandler(state, user)
+	return AuthorizeHandler(state, req, user, nonce, ed25519.Sign(private, []byte(nonce)), publicKey)
+}
+
+func TestParseOIDCClient(t *testing.T) {
+	client, err := ParseOIDCClient("app=https://a.example/cb,https://b.example/cb")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.ID != "app" || len(client.RedirectURIs) != 2 || client.RedirectURIs[1] != "https://b.example/cb" {
+		t.Fatalf("unexpected client: %#v", client)
+	}
+	for _, value := range []string{"", "app", "app=", "=https://a.example/cb"} {
+		if _, err := ParseOIDCClient(value); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+}
+
+func TestValidateAuthorizationRequest(t *testing.T) {
+	state := newOIDCState()
+	valid := newAuthorizationRequest("verifier")
+	if err := ValidateAuthorizationRequest(state, valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	unknownClient := valid
+	unknownClient.ClientID = "other"
+	otherRedirect := valid
+	otherRedirect.RedirectURI = "https://evil.example/callback"
+	for _, req := range []AuthorizationRequest{unknownClient, otherRedirect} {
+		if err := ValidateAuthorizationRequest(state, req); err != ErrInvalidClient {
+			t.Fatalf("expected %v for %#v, got %v", ErrInvalidClient, req, err)
+		}
+	}
+
+	implicit := valid
+	implicit.ResponseType = "token"
+	noOpenID := valid
+	noOpenID.Scope = "profile"
+	plain := valid
+	plain.CodeChallengeMethod = "plain"
+	noChallenge := valid
+	noChallenge.CodeChallenge = ""
+	for _, req := range []AuthorizationRequest{implicit, noOpenID, plain, noChallenge} {
+		if err := ValidateAuthorizationRequest(state, req); err != ErrInvalidAuthorizationRequest {
+			t.Fatalf("expected %v for %#v, got %v", ErrInvalidAuthorizationRequest, req, err)
+		}
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	state := newOIDCState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: now})
+	public, private, _ := ed25519.GenerateKey(nil)
+	state.SetKey(KeyEvent{User: "alice", PublicKey: public, Timestamp: now})
+	req := newAuthorizationRequest("verifier")
+
+	code, err := authorizeWithKey(t, state, req, "alice", private, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tokens, err := ExchangeCodeHandler(state, code, "app", req.RedirectURI, "verifier")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tokens.TokenType != "Bearer" || tokens.Scope != "openid" {
+		t.Fatalf("unexpected tokens: %#v", tokens)
+	}
+
+	var idToken IDTokenClaims
+	if verifyErr := VerifyIDToken(tokens.IDToken, state.publicKeys(), &idToken); verifyErr != nil {
+		t.Fatalf("ID token did not verify: %v", verifyErr)
+	}
+	if idToken.Subject != "alice" || idToken.Audience != "app" || idToken.Nonce != "n-0S6" || idToken.Issuer != state.issuerURL() {
+		t.Fatalf("unexpected ID token claims: %#v", idToken)
+	}
+
+	info, err := UserInfoHandler(state, tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Subject != "alice" || info.Balance != 100 || info.Tier != TierModeratorVerified {
+		t.Fatalf("unexpected userinfo: %#v", info)
+	}
+	// ID tokens are not access tokens
+	if _, err := UserInfoHandler(state, tokens.IDToken); err != ErrInvalidToken {
+		t.Fatalf("expected %v for an ID token, got %v", ErrInvalidToken, err)
+	}
+	if _, verifyErr := VerifyToken(tokens.IDToken, state.publicKeys(), now); verifyErr == nil {
+		t.Fatal("expected VerifyToken to reject an ID token")
+	}
+
+	// Codes can only be exchanged once
+	if _, err := ExchangeCodeHandler(state, code, "app", req.RedirectURI, "verifier"); err != ErrInvalidGrant {
+		t.Fatalf("expected %v for a used code, got %v", ErrInvalidGrant, err)
+	}
+}
+
+func TestExchangeCodeRejectsMismatches(t *testing.T) {
+	state := newOIDCState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	public, private, _ := ed25519.GenerateKey(nil)
+	req := newAuthorizationRequest("verifier")
+
+	tests := []struct {
+		name        string
+		clientID    string
+		redirectURI string
+		verifier    string
+		delay       time.Duration
+	}{
+		{"wrong verifier", "app", req.RedirectURI, "other", 0},
+		{"wrong client", "other", req.RedirectURI, "verifier", 0},
+		{"wrong redirect", "app", "https://app.example/other", "verifier", 0},
+		{"expired", "app", req.RedirectURI, "verifier", authorizationCodeLifetime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+			code, err := authorizeWithKey(t, state, req, "alice", private, public)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			now = now.Add(tt.delay)
+			if _, err := ExchangeCodeHandler(state, code, tt.clientID, tt.redirectURI, tt.verifier); err != ErrInvalidGrant {
+				t.Fatalf("expected %v, got %v", ErrInvalidGrant, err)
+			}
+		})
+	}
+}
+
+func TestUserInfoRejectsInvalidTokens(t *testing.T) {
+	state := newOIDCState()
+	if _, err := UserInfoHandler(state, "not-a-token"); err != ErrInvalidToken {
+		t.Fatalf("expected %v, got %v", ErrInvalidToken, err)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Maximum number of events returned by one replication request.
+const replicationBatchSize = 1000
+
+// Number of recent events a replication log keeps in memory. Followers
+// further behind receive a snapshot of the storage instead.
+const replicationLogCapacity = 10000
+
+// Longest time a replication request waits for new events.
+const replicationMaxWait = 30 * time.Second
+
+// Delay before a follower retries after a failed request.
+const replicationRetryDelay = time.Second
+
+// Represents a batch of events read from a replication log.
+type ReplicationBatch struct {
+	// Identifies the log. A leader starts a new log on every start, so
+	// offsets are only meaningful within the same log.
+	LogID      string `json:"log_id"`
+	Offset     int    `json:"offset"`
+	NextOffset int    `json:"next_offset"`
+	// Set when the events are a snapshot of the storage contents rather
+	// than the log entries starting at the offset
+	Snapshot bool    `json:"snapshot,omitempty"`
+	Events   []Event `json:"events"`
+}
+
+// Wraps the storage of a leader and records every write in an ordered event
+// stream that followers read by offset. Offset 0 stands for the storage
+// contents: reading it, or any offset whose events are no longer kept,
+// returns a snapshot of the storage, so new followers receive the full state.
+// Writes get offsets from 1, and only the latest of them are kept in memory.
+type ReplicationLog struct {
+	Storage
+	id       string
+	capacity int
+	mu       sync.Mutex
+	// serializes writes so that the stream has the order of the storage
+	writeMu sync.Mutex
+	// offset of the first kept event
+	base   int
+	events []Event
+	// closed and replaced whenever events are appended
+	appended chan struct{}
+}
+
+// Creates a replication log over the storage.
+func NewReplicationLog(storage Storage) (*ReplicationLog, error) {
+	return &ReplicationLog{
+		Storage:  storage,
+		id:       rand.Text(),
+		capacity: replicationLogCapacity,
+		base:     1,
+		appended: make(chan struct{}),
+	}, nil
+}
+
+// Returns the identifier of the log.
+func (l *ReplicationLog) ID() string {
+	return l.id
+}
+
+// Applies the event to the wrapped storage and appends it to the stream.
+func (l *ReplicationLog) write(event Event) error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	if err := event.Apply(l.Storage); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+	if dropped := len(l.events) - l.capacity; dropped > 0 {
+		l.events = l.events[dropped:]
+		l.base += dropped
+	}
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return nil
+}
+
+// Records a vouch event and appends it to the stream.
+func (l *ReplicationLog) AddVouch(vouch VouchEvent) error {
+	return l.write(EventFromVouch(vouch))
+}
+
+// Stores a proof event and appends it to the stream.
+func (l *ReplicationLog) SetProof(proof ProofEvent) error {
+	return l.write(EventFromProof(proof))
+}
+
+// Records a penalty event and appends it to the stream.
+func (l *ReplicationLog) AddPenalty(penalty PenaltyEvent) error {
+	// The ID is part of the stream, so followers store the same one
+	if penalty.ID == "" {
+		penalty.ID = rand.Text()
+	}
+	return l.write(EventFromPenalty(penalty))
+}
+
+// Stores a key and appends it to the stream.
+func (l *ReplicationLog) SetKey(key KeyEvent) error {
+	return l.write(EventFromKey(key))
+}
+
+// Returns up to `limit` events starting at `offset`. If there are none yet,
+// waits up to `wait` for new events or until the context is done. Offsets
+// before the kept events return a snapshot of the storage, which is not
+// limited.
+func (l *ReplicationLog) Read(ctx context.Context, offset int, limit int, wait time.Duration) (ReplicationBatch, error) {
+	if offset < 0 {
+		return ReplicationBatch{}, fmt.Errorf("offset %d is outside of the log", offset)
+	}
+	l.mu.Lock()
+	if offset < l.base {
+		l.mu.Unlock()
+		return l.snapshot(offset)
+	}
+	end := l.base + len(l.events)
+	if offset > end {
+		l.mu.Unlock()
+		return ReplicationBatch{}, fmt.Errorf("offset %d is outside of the log", offset)
+	}
+	if offset == end && wait > 0 {
+		appended := l.appended
+		l.mu.Unlock()
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-appended:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		l.mu.Lock()
+	}
+	defer l.mu.Unlock()
+
+	// Events may have been appended, and old ones dropped, while waiting
+	if offset < l.base {
+		return ReplicationBatch{LogID: l.id, Offset: offset, NextOffset: offset, Events: []Event{}}, nil
+	}
+	start := offset - l.base
+	stop := min(len(l.events), start+limit)
+	events := make([]Event, stop-start)
+	copy(events, l.events[start:stop])
+	return ReplicationBatch{LogID: l.id, Offset: offset, NextOffset: l.base + stop, Events: events}, nil
+}
+
+// Returns the current storage contents as events, followed by the offset
+// of the next write.
+func (l *ReplicationLog) snapshot(offset int) (ReplicationBatch, error) {
+	// Blocks writes so that the snapshot matches the offset
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	events, err := StorageEvents(l.Storage)
+	if err != nil {
+		return ReplicationBatch{}, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	next := l.base + len(l.events)
+	return ReplicationBatch{LogID: l.id, Offset: offset, NextOffset: next, Snapshot: true, Events: events}, nil
+}
+
+// Reads the event stream of a leader over HTTP and applies it to a local
+// state, which publishes the applied events. Applying is idempotent, so the
+// follower can replay the stream from the start after a restart or when the
+// leader starts a new log.
+type Follower struct {
+	leader string
+	state  *AppState
+	client *http.Client
+
+	mu     sync.Mutex
+	logID  string
+	offset int
+}
+
+// Creates a follower of the leader at the given base URL.
+func NewFollower(leader string, state *AppState) *Follower {
+	return &Follower{
+		leader: leader,
+		state:  state,
+		client: &http.Client{Timeout: replicationMaxWait + 10*time.Second},
+	}
+}
+
+// Returns the log followed and the offset of the next event to apply.
+func (f *Follower) Position() (string, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logID, f.offset
+}
+
+// Fetches one batch of events from the leader, waiting up to `wait` for new
+// events, and applies it. Returns the number of applied events.
+func (f *Follower) Sync(ctx context.Context, wait time.Duration) (int, error) {
+	logID, offset := f.Position()
+
+	query := url.Values{}
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("wait", wait.String())
+	req, err := http.NewRequestWithContext(ctx, "GET", f.leader+"/replication/events?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var body AnyResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		// A new log can be shorter than the old offset. Start over.
+		if resp.StatusCode == http.StatusBadRequest && logID != "" {
+			f.reset()
+		}
+		return 0, fmt.Errorf("leader responded with %d: %s", resp.StatusCode, body.Message)
+	}
+	var batch ReplicationBatch
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return 0, err
+	}
+	if logID != "" && batch.LogID != logID {
+		// The leader has started a new log. Replay it from the start.
+		log.Printf("Leader started log %s, replaying from the start", batch.LogID)
+		f.reset()
+		return 0, nil
+	}
+
+	for i, event := range batch.Events {
+		if err := f.state.ApplyEvent(event); err != nil {
+			// A snapshot has no offsets of its own, so it is read again
+			if !batch.Snapshot {
+				f.advance(batch.LogID, batch.Offset+i)
+			}
+			return i, err
+		}
+	}
+	f.advance(batch.LogID, batch.NextOffset)
+	return len(batch.Events), nil
+}
+
+// Follows the leader until the context is done, retrying after errors.
+func (f *Follower) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if _, err := f.Sync(ctx, replicationMaxWait); err != nil && ctx.Err() == nil {
+			log.Printf("Error replicating from %s: %v", f.leader, err)
+			select {
+			case <-time.After(replicationRetryDelay):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+func (f *Follower) advance(logID string, offset int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logID = logID
+	f.offset = offset
+}
+
+func (f *Follower) reset() {
+	f.advance("", 0)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Starts an in-process leader with a replication log over memory storage.
+func newTestLeader(t *testing.T) (*AppState, *httptest.Server) {
+	t.Helper()
+	replication, err := NewReplicationLog(NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state := NewAppStateWithStorage(replication)
+	state.replication = replication
+	server := httptest.NewServer(SetupRouterWithState(state))
+	t.Cleanup(server.Close)
+	return state, server
+}
+
+// Syncs the follower until it has applied every event of the leader.
+func syncFollower(t *testing.T, follower *Follower) {
+	t.Helper()
+	for range 10 {
+		count, err := follower.Sync(context.Background(), 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count == 0 {
+			if logID, _ := follower.Position(); logID != "" {
+				return
+			}
+		}
+	}
+	t.Fatal("follower did not catch up")
+}
+
+// Verifies that the follower reports the same identity as the leader.
+func compareIdentities(t *testing.T, leader *AppState, follower *AppState, users ...string) {
+	t.Helper()
+	for _, user := range users {
+		expected, _ := IdtHandler(leader, user)
+		actual, _ := IdtHandler(follower, user)
+		if expected != actual {
+			t.Fatalf("identity mismatch for %s: %#v vs %#v", user, expected, actual)
+		}
+	}
+}
+
+func TestReplicationFollowersCatchUp(t *testing.T) {
+	leader, server := newTestLeader(t)
+	ProveHandler(leader, "alice", 100)
+	VouchHandler(leader, "alice", "sig", "nonce", "bob", 0, time.Time{}, 0)
+
+	followers := []*AppState{NewAppState(), NewAppState()}
+	replicas := []*Follower{}
+	for _, state := range followers {
+		replica := NewFollower(server.URL, state)
+		syncFollower(t, replica)
+		compareIdentities(t, leader, state, "alice", "bob")
+		replicas = append(replicas, replica)
+	}
+
+	// The second follower is offline while the leader takes more writes
+	VouchHandler(leader, "bob", "sig", "nonce", "carol", 0, time.Time{}, 0)
+	PunishHandler(leader, "carol", 7)
+	syncFollower(t, replicas[0])
+	compareIdentities(t, leader, followers[0], "alice", "bob", "carol")
+
+	published := 0
+	followers[1].events.Subscribe("", func(BusEvent) { published++ })
+	_, offset := replicas[1].Position()
+	if offset != 3 {
+		t.Fatalf("expected the offline follower at offset 3, got %d", offset)
+	}
+	syncFollower(t, replicas[1])
+	compareIdentities(t, leader, followers[1], "alice", "bob", "carol")
+	// The replicated writes reach the follower's stream
+	if published != 2 {
+		t.Fatalf("expected 2 published events, got %d", published)
+	}
+}
+
+func TestReplicationReplaysNewLog(t *testing.T) {
+	leader, server := newTestLeader(t)
+	PunishHandler(leader, "alice", 5)
+	PunishHandler(leader, "alice", 3)
+
+	follower := NewAppState()
+	replica := NewFollower(server.URL, follower)
+	syncFollower(t, replica)
+	oldLog, _ := replica.Position()
+
+	// The leader restarts and seeds a new log from its storage
+	replication, err := NewReplicationLog(leader.replication.Storage)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	leader.storage = replication
+	leader.replication = replication
+	PunishHandler(leader, "alice", 2)
+
+	// The new log is shorter than the old offset, so the follower starts over
+	if _, err := replica.Sync(context.Background(), 0); err == nil {
+		t.Fatal("expected an error for an offset outside of the new log")
+	}
+	syncFollower(t, replica)
+	if newLog, _ := replica.Position(); newLog == oldLog {
+		t.Fatal("expected the follower to switch to the new log")
+	}
+	if penalties := follower.Penalties("alice"); len(penalties) != 3 {
+		t.Fatalf("expected 3 penalties after replay, got %#v", penalties)
+	}
+	compareIdentities(t, leader, follower, "alice")
+}
+
+func TestReplicationWaitsForEvents(t *testing.T) {
+	leader, server := newTestLeader(t)
+	replica := NewFollower(server.URL, NewAppState())
+	syncFollower(t, replica)
+
+	done := make(chan int)
+	go func() {
+		count, err := replica.Sync(context.Background(), 5*time.Second)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		done <- count
+	}()
+	time.Sleep(50 * time.Millisecond)
+	ProveHandler(leader, "alice", 100)
+
+	select {
+	case count := <-done:
+		if count != 1 {
+			t.Fatalf("expected 1 event, got %d", count)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("follower was not woken by the new event")
+	}
+}
+
+func TestReplicationSnapshotsForLaggingFollowers(t *testing.T) {
+	leader, server := newTestLeader(t)
+	leader.replication.capacity = 2
+	ProveHandler(leader, "alice", 100)
+
+	follower := NewAppState()
+	replica := NewFollower(server.URL, follower)
+	syncFollower(t, replica)
+
+	// The follower falls behind the events kept by the leader
+	VouchHandler(leader, "alice", "sig", "nonce", "bob", 0, time.Time{}, 0)
+	PunishHandler(leader, "bob", 5)
+	PunishHandler(leader, "bob", 5)
+	if batch, err := leader.replication.Read(context.Background(), 1, replicationBatchSize, 0); err != nil || !batch.Snapshot || batch.NextOffset != 5 {
+		t.Fatalf("expected a snapshot up to offset 5, got %#v, %v", batch, err)
+	}
+
+	syncFollower(t, replica)
+	if _, offset := replica.Position(); offset != 5 {
+		t.Fatalf("expected the follower at offset 5, got %d", offset)
+	}
+	if penalties := follower.Penalties("bob"); len(penalties) != 2 {
+		t.Fatalf("expected 2 penalties after the snapshot, got %#v", penalties)
+	}
+	compareIdentities(t, leader, follower, "alice", "bob")
+
+	// Kept events are read from the log again
+	PunishHandler(leader, "bob", 1)
+	if batch, _ := leader.replication.Read(context.Background(), 5, replicationBatchSize, 0); batch.Snapshot || len(batch.Events) != 1 {
+		t.Fatalf("expected the kept event, got %#v", batch)
+	}
+	syncFollower(t, replica)
+	compareIdentities(t, leader, follower, "alice", "bob")
+
+	// Replaying from the start neither duplicates nor republishes events
+	published := 0
+	follower.events.Subscribe("", func(BusEvent) { published++ })
+	syncFollower(t, NewFollower(server.URL, follower))
+	if penalties := follower.Penalties("bob"); len(penalties) != 3 {
+		t.Fatalf("expected 3 penalties after replay, got %#v", penalties)
+	}
+	if published != 0 {
+		t.Fatalf("expected no events to be republished, got %d", published)
+	}
+}
+
+func TestApplyReplicatedIsIdempotent(t *testing.T) {
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	events := []Event{
+		EventFromVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp}),
+		EventFromProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp}),
+		// Identical penalties are told apart by their IDs
+		EventFromPenalty(PenaltyEvent{ID: "p1", User: "bob", Amount: 5, Timestamp: timestamp}),
+		EventFromPenalty(PenaltyEvent{ID: "p2", User: "bob", Amount: 5, Timestamp: timestamp}),
+	}
+	testStorageImplementations(t, "ApplyReplicated", func(t *testing.T, storage Storage) {
+		for range 2 {
+			for _, event := range events {
+				if err := event.Apply(storage); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+		}
+		vouches, _ := storage.UserVouchesFrom("alice")
+		penalties, _ := storage.Penalties("bob")
+		if len(vouches) != 1 || len(penalties) != 2 {
+			t.Fatalf("expected 1 vouch and 2 penalties, got %#v and %#v", vouches, penalties)
+		}
+	})
+}
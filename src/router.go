@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -16,6 +24,22 @@ type VouchRequest struct {
 	Signature string `json:"signature"`
 	Nonce     string `json:"nonce"`
 	To        string `json:"to"`
+	// Optional confidence from 1 to 100, full weight if omitted
+	Weight uint64 `json:"weight,omitempty"`
+	// Optional expiry, the deployment's vouch TTL applies if omitted
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// Optional part of the voucher's balance put at risk by the vouch
+	Stake uint64 `json:"stake,omitempty"`
+}
+
+// Represents the request body for the vouch renewal endpoint
+type RenewRequest struct {
+	From      string `json:"from"`
+	Signature string `json:"signature"`
+	Nonce     string `json:"nonce"`
+	To        string `json:"to"`
+	// Optional new expiry, the deployment's vouch TTL applies if omitted
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
 }
 
 // Represents the request body for the prove endpoint
@@ -36,6 +60,109 @@ type PunishRequest struct {
 	// TODO: add moderator's credentials
 }
 
+// Represents the response for the attestation endpoint
+type AttestationResponse struct {
+	// Compact JWS signed with a key from /.well-known/jwks.json
+	Attestation string      `json:"attestation"`
+	Claims      Attestation `json:"claims"`
+	// Latest receipt of the state root published to an external ledger
+	AnchorReceipt *AnchorReceipt `json:"anchor_receipt,omitempty"`
+}
+
+// Represents the request body for the challenge endpoint
+type ChallengeRequest struct {
+	User string `json:"user"`
+}
+
+// Represents the response for the challenge endpoint
+type ChallengeResponse struct {
+	User      string    `json:"user"`
+	Challenge string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Represents the request body for the token endpoint
+type TokenRequest struct {
+	User      string `json:"user"`
+	Challenge string `json:"challenge"`
+	// Base64 ed25519 signature over the challenge
+	Signature []byte `json:"signature"`
+	// Base64 ed25519 public key, registered on the user's first login
+	PublicKey []byte `json:"public_key,omitempty"`
+}
+
+// Represents the response for the token endpoint
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Represents the request body for the verify endpoint
+type VerifyRequest struct {
+	User      string `json:"user"`
+	Challenge string `json:"challenge"`
+	// Base64 ed25519 signature over the challenge
+	Signature []byte `json:"signature"`
+}
+
+// Represents the response for the verify endpoint
+type SessionResponse struct {
+	SessionToken string    `json:"session_token"`
+	TokenType    string    `json:"token_type"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Represents the response for the federation events endpoint
+type FederationEventsResponse struct {
+	// Compact JWS over a FederatedBatch
+	Batch string `json:"batch"`
+}
+
+// Represents the response for the commitment endpoint
+type CommitmentResponse struct {
+	// Compact JWS over the claims
+	Commitment string     `json:"commitment"`
+	Claims     Commitment `json:"claims"`
+}
+
+// Represents the response for the user commitment endpoint
+type UserCommitmentResponse struct {
+	User   string           `json:"user"`
+	Root   string           `json:"root"`
+	Size   int              `json:"size"`
+	Events []CommittedEvent `json:"events"`
+}
+
+// Represents an OAuth 2.0 error response of the OpenID Connect endpoints
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// Represents the response for the path endpoint
+type PathResponse struct {
+	From  string      `json:"from"`
+	To    string      `json:"to"`
+	Paths []TrustPath `json:"paths"`
+}
+
+// Represents the response for the sybil detection endpoint
+type SybilResponse struct {
+	Clusters []SybilCluster `json:"clusters"`
+}
+
+// Represents the response for the import endpoint
+type ImportResponse struct {
+	Success  bool `json:"success"`
+	Imported int  `json:"imported"`
+}
+
+// Represents the response for the webhook delivery log endpoint
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
 // Represents the common response
 type AnyResponse struct {
 	Success bool   `json:"success"`
@@ -44,9 +171,17 @@ type AnyResponse struct {
 
 // Represents the response for the idt endpoint
 type IdtResponse struct {
-	User    string `json:"user"`
-	Balance int64  `json:"balance"`
-	Penalty uint64 `json:"penalty"`
+	User    string      `json:"user"`
+	Balance int64       `json:"balance"`
+	Penalty uint64      `json:"penalty"`
+	Scoring ScoringMode `json:"scoring"`
+	Tier    Tier        `json:"tier"`
+}
+
+// Represents the response for the tier endpoint
+type TierResponse struct {
+	User string `json:"user"`
+	Tier Tier   `json:"tier"`
 }
 
 func contentTypeApplicationJsonMiddleware(next http.Handler) http.Handler {
@@ -56,6 +191,18 @@ func contentTypeApplicationJsonMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// Rejects every request that is not a read with 403.
+// Used on followers, which only receive writes from their leader.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			sendErrorResponse(w, http.StatusForbidden, ErrReadOnlyReplica.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Sends a JSON error response with the given status code and message.
 func sendErrorResponse(w http.ResponseWriter, statusCode int, message string) {
 	data, err := json.Marshal(AnyResponse{Success: false, Message: message})
@@ -81,14 +228,22 @@ func vouchHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
 		return
 	}
+	session, ok := authenticateSession(state, w, r, &req.From)
+	if !ok {
+		return
+	}
 
 	// Validate required fields
-	if req.From == "" || req.Signature == "" || req.Nonce == "" || req.To == "" {
+	if req.From == "" || req.To == "" || (!session && (req.Signature == "" || req.Nonce == "")) {
 		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields")
 		return
 	}
 
-	res := VouchHandler(state, req.From, req.Signature, req.Nonce, req.To)
+	res := VouchHandler(state, req.From, req.Signature, req.Nonce, req.To, req.Weight, req.ExpiresAt, req.Stake)
+	if res == ErrVouchRateLimited {
+		sendErrorResponse(w, http.StatusTooManyRequests, res.Error())
+		return
+	}
 	if res != nil {
 		sendErrorResponse(w, http.StatusBadRequest, res.Error())
 		return
@@ -103,6 +258,617 @@ func vouchHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// Checks the session token in the Authorization header, if any.
+// A request with a valid session acts as the session's user: an empty `user`
+// is filled in and a different one is rejected. Reports whether a session
+// was used, and false for `ok` after an error response has been sent.
+func authenticateSession(state *AppState, w http.ResponseWriter, r *http.Request, user *string) (bool, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return false, true
+	}
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found {
+		sendErrorResponse(w, http.StatusUnauthorized, ErrInvalidToken.Error())
+		return false, false
+	}
+	subject, res := SessionUser(state, token)
+	if res != nil {
+		sendErrorResponse(w, http.StatusUnauthorized, res.Error())
+		return false, false
+	}
+	if *user != "" && *user != subject {
+		sendErrorResponse(w, http.StatusForbidden, ErrSessionUserMismatch.Error())
+		return false, false
+	}
+	*user = subject
+	return true, true
+}
+
+// Checks the admin token in the Authorization header. Reports false after
+// an error response has been sent.
+func authenticateAdmin(state *AppState, w http.ResponseWriter, r *http.Request) bool {
+	if state.adminToken == "" {
+		sendErrorResponse(w, http.StatusForbidden, ErrAdminDisabled.Error())
+		return false
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !checkAdminToken(state, token) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		sendErrorResponse(w, http.StatusUnauthorized, ErrInvalidAdminToken.Error())
+		return false
+	}
+	return true
+}
+
+// Handles POST requests to /vouch/renew
+func renewHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	var req RenewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	session, ok := authenticateSession(state, w, r, &req.From)
+	if !ok {
+		return
+	}
+
+	// Validate required fields
+	if req.From == "" || req.To == "" || (!session && (req.Signature == "" || req.Nonce == "")) {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields")
+		return
+	}
+
+	res := RenewHandler(state, req.From, req.To, req.ExpiresAt)
+	if res == ErrVouchNotFound {
+		sendErrorResponse(w, http.StatusNotFound, res.Error())
+		return
+	}
+	if res != nil {
+		sendErrorResponse(w, http.StatusBadRequest, res.Error())
+		return
+	}
+
+	data, err := json.Marshal(AnyResponse{Success: true, Message: "Vouch renewed"})
+	if err != nil {
+		log.Printf("Failed to encode renew response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles POST requests to /auth/challenge
+func challengeHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	var req ChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.User == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields")
+		return
+	}
+
+	nonce, expiresAt, res := ChallengeHandler(state, req.User)
+	if res == ErrRemoteLogin {
+		sendErrorResponse(w, http.StatusForbidden, res.Error())
+		return
+	}
+	if res == ErrTooManyPending {
+		sendErrorResponse(w, http.StatusServiceUnavailable, res.Error())
+		return
+	}
+	if res != nil {
+		sendErrorResponse(w, http.StatusBadRequest, res.Error())
+		return
+	}
+	data, err := json.Marshal(ChallengeResponse{User: req.User, Challenge: nonce, ExpiresAt: expiresAt})
+	if err != nil {
+		log.Printf("Failed to encode challenge response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles POST requests to /auth/token
+func tokenHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	var req TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.User == "" || req.Challenge == "" || len(req.Signature) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields")
+		return
+	}
+
+	token, claims, res := TokenHandler(state, req.User, req.Challenge, req.Signature, req.PublicKey)
+	if res == ErrInvalidChallenge || res == ErrInvalidSignature || res == ErrKeyNotRegistered || res == ErrRemoteLogin {
+		sendErrorResponse(w, http.StatusUnauthorized, res.Error())
+		return
+	}
+	if res != nil {
+		sendErrorResponse(w, http.StatusBadRequest, res.Error())
+		return
+	}
+
+	response := TokenResponse{AccessToken: token, TokenType: "Bearer", ExpiresIn: claims.ExpiresAt - claims.IssuedAt}
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Failed to encode token response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles POST requests to /auth/verify
+func verifyHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	var req VerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.User == "" || req.Challenge == "" || len(req.Signature) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields")
+		return
+	}
+
+	token, claims, res := VerifyHandler(state, req.User, req.Challenge, req.Signature)
+	if res == ErrInvalidChallenge || res == ErrInvalidSignature {
+		sendErrorResponse(w, http.StatusUnauthorized, res.Error())
+		return
+	}
+	if res != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, res.Error())
+		return
+	}
+
+	response := SessionResponse{SessionToken: token, TokenType: "Bearer", ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC()}
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Failed to encode session response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Sends an OAuth 2.0 error response with the given status code.
+func sendOAuthError(w http.ResponseWriter, statusCode int, code string, description string) {
+	data, err := json.Marshal(OAuthErrorResponse{Error: code, ErrorDescription: description})
+	if err != nil {
+		log.Printf("Failed to encode OAuth error response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+// Redirects the user agent back to the client with the given parameters.
+func redirectToClient(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, ErrInvalidClient.Error())
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// Reads the OpenID Connect authorization parameters from the query or form.
+func authorizationRequestFromForm(r *http.Request) AuthorizationRequest {
+	return AuthorizationRequest{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		Nonce:               r.Form.Get("nonce"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+	}
+}
+
+// Handles GET requests to /.well-known/openid-configuration
+func oidcDiscoveryHandler(state *AppState, w http.ResponseWriter, _ *http.Request) {
+	data, err := json.Marshal(OIDCDiscoveryHandler(state))
+	if err != nil {
+		log.Printf("Failed to encode discovery document to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles GET requests to /oidc/authorize
+// Validates the authorization request and issues a login challenge for the
+// user named by `login_hint`. The user signs it and posts it back to
+// /oidc/authorize together with the same parameters.
+func oidcAuthorizeStartHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	req := authorizationRequestFromForm(r)
+	if err := ValidateOIDCClient(state, req.ClientID, req.RedirectURI); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := ValidateAuthorizationRequest(state, req); err != nil {
+		redirectToClient(w, r, req.RedirectURI, url.Values{"error": {"invalid_request"}, "error_description": {err.Error()}, "state": {req.State}})
+		return
+	}
+	user := r.Form.Get("login_hint")
+	if user == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields")
+		return
+	}
+
+	nonce, expiresAt, res := ChallengeHandler(state, user)
+	if res == ErrRemoteLogin {
+		redirectToClient(w, r, req.RedirectURI, url.Values{"error": {"access_denied"}, "error_description": {res.Error()}, "state": {req.State}})
+		return
+	}
+	if res == ErrTooManyPending {
+		redirectToClient(w, r, req.RedirectURI, url.Values{"error": {"temporarily_unavailable"}, "error_description": {res.Error()}, "state": {req.State}})
+		return
+	}
+	if res != nil {
+		redirectToClient(w, r, req.RedirectURI, url.Values{"error": {"invalid_request"}, "error_description": {res.Error()}, "state": {req.State}})
+		return
+	}
+	data, err := json.Marshal(ChallengeResponse{User: user, Challenge: nonce, ExpiresAt: expiresAt})
+	if err != nil {
+		log.Printf("Failed to encode challenge response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles POST requests to /oidc/authorize
+// Expects the authorization parameters together with `user`, `challenge`,
+// `signature` and the optional `public_key`, with binary values in unpadded
+// base64url. Redirects back to the client with an authorization code.
+func oidcAuthorizeHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid form")
+		return
+	}
+	req := authorizationRequestFromForm(r)
+	if err := ValidateOIDCClient(state, req.ClientID, req.RedirectURI); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	signature, sigErr := jwsEncoding.DecodeString(r.Form.Get("signature"))
+	publicKey, keyErr := jwsEncoding.DecodeString(r.Form.Get("public_key"))
+	if sigErr != nil || keyErr != nil {
+		redirectToClient(w, r, req.RedirectURI, url.Values{"error": {"invalid_request"}, "error_description": {"Invalid encoding"}, "state": {req.State}})
+		return
+	}
+
+	code, res := AuthorizeHandler(state, req, r.Form.Get("user"), r.Form.Get("challenge"), signature, publicKey)
+	if res == ErrInvalidChallenge || res == ErrInvalidSignature || res == ErrKeyNotRegistered || res == ErrRemoteLogin {
+		redirectToClient(w, r, req.RedirectURI, url.Values{"error": {"access_denied"}, "error_description": {res.Error()}, "state": {req.State}})
+		return
+	}
+	if res == ErrTooManyPending {
+		redirectToClient(w, r, req.RedirectURI, url.Values{"error": {"temporarily_unavailable"}, "error_description": {res.Error()}, "state": {req.State}})
+		return
+	}
+	if res != nil {
+		redirectToClient(w, r, req.RedirectURI, url.Values{"error": {"invalid_request"}, "error_description": {res.Error()}, "state": {req.State}})
+		return
+	}
+	redirectToClient(w, r, req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})
+}
+
+// Handles POST requests to /oidc/token
+func oidcTokenHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		sendOAuthError(w, http.StatusBadRequest, "invalid_request", "Invalid form")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		sendOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	tokens, res := ExchangeCodeHandler(state, r.PostForm.Get("code"), r.PostForm.Get("client_id"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+	if res == ErrInvalidGrant {
+		sendOAuthError(w, http.StatusBadRequest, "invalid_grant", res.Error())
+		return
+	}
+	if res != nil {
+		sendOAuthError(w, http.StatusInternalServerError, "server_error", res.Error())
+		return
+	}
+
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		log.Printf("Failed to encode token response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+// Handles GET requests to /oidc/userinfo
+func oidcUserInfoHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		sendOAuthError(w, http.StatusUnauthorized, "invalid_token", "Missing bearer token")
+		return
+	}
+	info, res := UserInfoHandler(state, token)
+	if res == ErrInvalidToken {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		sendOAuthError(w, http.StatusUnauthorized, "invalid_token", res.Error())
+		return
+	}
+	if res != nil {
+		sendOAuthError(w, http.StatusInternalServerError, "server_error", res.Error())
+		return
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		log.Printf("Failed to encode userinfo response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Writes one Server-Sent Event. The ID is omitted if empty.
+func writeServerSentEvent(w http.ResponseWriter, id string, name string, data []byte) error {
+	var buf bytes.Buffer
+	if id != "" {
+		buf.WriteString("id: " + id + "\n")
+	}
+	buf.WriteString("event: " + name + "\n")
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Handles GET requests to /events/stream
+// Streams accepted vouch, proof and penalty events as Server-Sent Events
+// named by event kind. The optional `user` query parameter keeps only events
+// involving the user. Clients resume after the event in the Last-Event-ID
+// header or the `last_event_id` query parameter; a `reset` event tells them
+// that events may have been missed.
+func eventStreamHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	user := r.URL.Query().Get("user")
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+
+	stream := make(chan BusEvent, eventStreamBuffer)
+	// closed when the client falls too far behind
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+	backlog, resumed, unsubscribe := state.events.Subscribe(lastID, func(published BusEvent) {
+		select {
+		case stream <- published:
+		default:
+			overflowOnce.Do(func() { close(overflow) })
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	controller := http.NewResponseController(w)
+	send := func(published BusEvent) error {
+		if user != "" && !published.Event.Involves(user) {
+			return nil
+		}
+		data, err := json.Marshal(published.Event)
+		if err != nil {
+			return err
+		}
+		return writeServerSentEvent(w, published.ID, string(published.Event.Kind), data)
+	}
+
+	if !resumed {
+		data, _ := json.Marshal(AnyResponse{Success: false, Message: "Cannot resume from " + lastID + ", events may have been missed"})
+		if err := writeServerSentEvent(w, "", "reset", data); err != nil {
+			return
+		}
+	}
+	for _, published := range backlog {
+		if err := send(published); err != nil {
+			return
+		}
+	}
+	if err := controller.Flush(); err != nil {
+		log.Printf("Event stream does not support flushing: %v", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case published := <-stream:
+			if err := send(published); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+		case <-overflow:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Handles GET requests to /replication/events
+// Query parameters: `offset` of the first event (default 0), `limit` of
+// events (default and maximum 1000) and `wait`, a duration to wait for new
+// events when there are none (default 0, maximum 30s).
+func replicationEventsHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	if state.replication == nil {
+		sendErrorResponse(w, http.StatusNotFound, ErrReplicationDisabled.Error())
+		return
+	}
+	query := r.URL.Query()
+	offset, limit, wait := 0, replicationBatchSize, time.Duration(0)
+	var err error
+	if value := query.Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid offset")
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(limit, replicationBatchSize)
+	}
+	if value := query.Get("wait"); value != "" {
+		if wait, err = time.ParseDuration(value); err != nil || wait < 0 {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid wait")
+			return
+		}
+		wait = min(wait, replicationMaxWait)
+	}
+
+	batch, err := state.replication.Read(r.Context(), offset, limit, wait)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	data, err := json.Marshal(batch)
+	if err != nil {
+		log.Printf("Failed to encode replication batch to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles GET requests to /federation/events
+// Query parameters: `offset` of the first event (default 0) and `limit` of
+// events (default and maximum 1000).
+func federationEventsHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	offset, limit := 0, replicationBatchSize
+	var err error
+	if value := query.Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid offset")
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(limit, replicationBatchSize)
+	}
+
+	batch, err := FederationEventsHandler(state, offset, limit)
+	if err == ErrFederationDisabled {
+		sendErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	data, err := json.Marshal(FederationEventsResponse{Batch: batch})
+	if err != nil {
+		log.Printf("Failed to encode federation batch to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles GET requests to /commitment
+func commitmentHandler(state *AppState, w http.ResponseWriter, _ *http.Request) {
+	claims, commitment, err := LatestCommitmentHandler(state)
+	if err != nil {
+		log.Printf("Failed to commit state: %v", err)
+		sendInternalError(w)
+		return
+	}
+	data, err := json.Marshal(CommitmentResponse{Commitment: commitment, Claims: claims})
+	if err != nil {
+		log.Printf("Failed to encode commitment response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles GET requests to /commitment/proof
+// Query parameters: `leaf`, the hex encoded leaf hash of an event.
+func membershipHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	leaf, err := hex.DecodeString(r.URL.Query().Get("leaf"))
+	if err != nil || len(leaf) != sha256.Size {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid leaf hash")
+		return
+	}
+	proof, err := MembershipHandler(state, leaf)
+	if err != nil {
+		log.Printf("Failed to prove membership: %v", err)
+		sendInternalError(w)
+		return
+	}
+	data, err := json.Marshal(proof)
+	if err != nil {
+		log.Printf("Failed to encode membership proof to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles GET requests to /commitment/users/{user}
+func userCommitmentHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	user := mux.Vars(r)["user"]
+	events, commitment, err := UserCommitmentHandler(state, user)
+	if err != nil {
+		log.Printf("Failed to prove user events: %v", err)
+		sendInternalError(w)
+		return
+	}
+	data, err := json.Marshal(UserCommitmentResponse{User: user, Root: commitment.Root, Size: commitment.Size, Events: events})
+	if err != nil {
+		log.Printf("Failed to encode user commitment response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
 // Handles POST requests to /prove
 func proveHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
 	var req ProofRequest
@@ -160,14 +926,20 @@ func punishHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
 }
 
 // Handles GET requests to /idt/:user
+// The optional `scoring` query parameter overrides the default scoring mode.
 func idtHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
 	user := mux.Vars(r)["user"]
-	res, err := IdtHandler(state, user)
+	scoring, err := ParseScoringMode(r.URL.Query().Get("scoring"), state.defaultScoring())
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, err := ScoredIdtHandler(state, user, scoring)
 	if err != nil {
 		sendErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	response := IdtResponse{User: res.User, Balance: res.Balance, Penalty: res.Penalty}
+	response := IdtResponse{User: res.User, Balance: res.Balance, Penalty: res.Penalty, Scoring: res.Scoring, Tier: res.Tier}
 	data, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Failed to encode idt response to JSON: %v", err)
@@ -177,14 +949,264 @@ func idtHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-// Creates and configures the HTTP router
+// Handles GET requests to /idt/:user/tier
+// The optional `scoring` query parameter overrides the default scoring mode.
+func tierHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	user := mux.Vars(r)["user"]
+	scoring, err := ParseScoringMode(r.URL.Query().Get("scoring"), state.defaultScoring())
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, err := ScoredIdtHandler(state, user, scoring)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	data, err := json.Marshal(TierResponse{User: res.User, Tier: res.Tier})
+	if err != nil {
+		log.Printf("Failed to encode tier response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles GET requests to /idt/:user/attestation
+// The optional `scoring` query parameter overrides the default scoring mode.
+func attestationHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	user := mux.Vars(r)["user"]
+	scoring, err := ParseScoringMode(r.URL.Query().Get("scoring"), state.defaultScoring())
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	claims, token, err := AttestationHandler(state, user, scoring)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	response := AttestationResponse{Attestation: token, Claims: claims}
+	if receipt, ok := state.LatestAnchorReceipt(); ok {
+		response.AnchorReceipt = &receipt
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Failed to encode attestation response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles GET requests to /.well-known/jwks.json
+func jwksHandler(state *AppState, w http.ResponseWriter, _ *http.Request) {
+	data, err := json.Marshal(state.publicKeys())
+	if err != nil {
+		log.Printf("Failed to encode key set to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles GET requests to /graph
+// Exports the whole vouch graph, or the tree of `user` in `direction` (in or out)
+// limited to `depth` hops when a user is given.
+func graphHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format, err := ParseGraphFormat(query.Get("format"))
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var graph Graph
+	if user := query.Get("user"); user != "" {
+		depth := DefaultTreeDepth
+		if value := query.Get("depth"); value != "" {
+			depth, err = strconv.Atoi(value)
+			if err != nil || depth < 0 {
+				sendErrorResponse(w, http.StatusBadRequest, "Invalid depth")
+				return
+			}
+		}
+		switch query.Get("direction") {
+		case "", "in":
+			graph = TreeGraph(state, IncomingTree(state, user, depth))
+		case "out":
+			graph = TreeGraph(state, OutgoingTree(state, user, depth))
+		default:
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid direction")
+			return
+		}
+	} else {
+		graph = FullGraph(state)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteGraph(&buf, graph, format); err != nil {
+		log.Printf("Failed to encode graph: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Write(buf.Bytes())
+}
+
+// Handles GET requests to /path
+// Returns the shortest vouch paths from `from` to `to` up to `depth` hops.
+func pathHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from := query.Get("from")
+	to := query.Get("to")
+	if from == "" || to == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields")
+		return
+	}
+	depth := DefaultTreeDepth
+	if value := query.Get("depth"); value != "" {
+		var err error
+		depth, err = strconv.Atoi(value)
+		if err != nil || depth < 0 {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid depth")
+			return
+		}
+	}
+
+	response := PathResponse{From: from, To: to, Paths: TrustPaths(state, from, to, depth)}
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Failed to encode path response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles GET requests to /moderation/sybil
+// TODO: add moderator's credentials
+func sybilHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(SybilResponse{Clusters: DetectSybilClusters(state)})
+	if err != nil {
+		log.Printf("Failed to encode sybil response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles GET requests to /stats
+func statsHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(ComputeGraphStats(state))
+	if err != nil {
+		log.Printf("Failed to encode stats response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles GET requests to /admin/export
+// Requires the admin token.
+func exportHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	if !authenticateAdmin(state, w, r) {
+		return
+	}
+	format, err := ParseExportFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := ExportStorage(state.storage, &buf, format); err != nil {
+		log.Printf("Failed to export storage: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Export failed")
+		return
+	}
+	if format == ExportFormatCSV {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Write(buf.Bytes())
+}
+
+// Handles POST requests to /admin/import
+// Requires the admin token.
+func importHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	if !authenticateAdmin(state, w, r) {
+		return
+	}
+	format, err := ParseExportFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	count, err := ImportState(state, r.Body, format)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, err := json.Marshal(ImportResponse{Success: true, Imported: count})
+	if err != nil {
+		log.Printf("Failed to encode import response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Handles GET requests to /admin/webhooks/deliveries
+// The optional `status` query parameter selects pending, delivered or failed deliveries.
+// Requires the admin token.
+func webhookDeliveriesHandler(state *AppState, w http.ResponseWriter, r *http.Request) {
+	if !authenticateAdmin(state, w, r) {
+		return
+	}
+	status := WebhookDeliveryStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", WebhookDeliveryPending, WebhookDeliveryDelivered, WebhookDeliveryFailed:
+	default:
+		sendErrorResponse(w, http.StatusBadRequest, "Unknown delivery status")
+		return
+	}
+	deliveries, err := WebhookDeliveriesHandler(state, status)
+	if err != nil {
+		log.Printf("Failed to read webhook deliveries: %v", err)
+		sendInternalError(w)
+		return
+	}
+	data, err := json.Marshal(WebhookDeliveriesResponse{Deliveries: deliveries})
+	if err != nil {
+		log.Printf("Failed to encode webhook deliveries response to JSON: %v", err)
+		sendInternalError(w)
+		return
+	}
+	w.Write(data)
+}
+
+// Creates and configures the HTTP router backed by in-memory storage
 func SetupRouter() *mux.Router {
-	appState := NewAppState()
+	return SetupRouterWithState(NewAppState())
+}
+
+// Creates and configures the HTTP router for the given application state
+func SetupRouterWithState(appState *AppState) *mux.Router {
 	router := mux.NewRouter()
 	router.Use(contentTypeApplicationJsonMiddleware)
+	if appState.readOnly {
+		router.Use(readOnlyMiddleware)
+	}
 	router.HandleFunc("/vouch", func(w http.ResponseWriter, r *http.Request) {
 		vouchHandler(appState, w, r)
 	}).Methods("POST")
+	router.HandleFunc("/vouch/renew", func(w http.ResponseWriter, r *http.Request) {
+		renewHandler(appState, w, r)
+	}).Methods("POST")
 	router.HandleFunc("/prove", func(w http.ResponseWriter, r *http.Request) {
 		proveHandler(appState, w, r)
 	}).Methods("POST")
@@ -194,5 +1216,77 @@ func SetupRouter() *mux.Router {
 	router.HandleFunc("/idt/{user}", func(w http.ResponseWriter, r *http.Request) {
 		idtHandler(appState, w, r)
 	}).Methods("GET")
+	router.HandleFunc("/idt/{user}/tier", func(w http.ResponseWriter, r *http.Request) {
+		tierHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/idt/{user}/attestation", func(w http.ResponseWriter, r *http.Request) {
+		attestationHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/auth/challenge", func(w http.ResponseWriter, r *http.Request) {
+		challengeHandler(appState, w, r)
+	}).Methods("POST")
+	router.HandleFunc("/auth/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenHandler(appState, w, r)
+	}).Methods("POST")
+	router.HandleFunc("/auth/verify", func(w http.ResponseWriter, r *http.Request) {
+		verifyHandler(appState, w, r)
+	}).Methods("POST")
+	router.HandleFunc("/events/stream", func(w http.ResponseWriter, r *http.Request) {
+		eventStreamHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/replication/events", func(w http.ResponseWriter, r *http.Request) {
+		replicationEventsHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/federation/events", func(w http.ResponseWriter, r *http.Request) {
+		federationEventsHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/commitment", func(w http.ResponseWriter, r *http.Request) {
+		commitmentHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/commitment/proof", func(w http.ResponseWriter, r *http.Request) {
+		membershipHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/commitment/users/{user}", func(w http.ResponseWriter, r *http.Request) {
+		userCommitmentHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		oidcDiscoveryHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/oidc/authorize", func(w http.ResponseWriter, r *http.Request) {
+		oidcAuthorizeStartHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/oidc/authorize", func(w http.ResponseWriter, r *http.Request) {
+		oidcAuthorizeHandler(appState, w, r)
+	}).Methods("POST")
+	router.HandleFunc("/oidc/token", func(w http.ResponseWriter, r *http.Request) {
+		oidcTokenHandler(appState, w, r)
+	}).Methods("POST")
+	router.HandleFunc("/oidc/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		oidcUserInfoHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		jwksHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/graph", func(w http.ResponseWriter, r *http.Request) {
+		graphHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/path", func(w http.ResponseWriter, r *http.Request) {
+		pathHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		statsHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/moderation/sybil", func(w http.ResponseWriter, r *http.Request) {
+		sybilHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/admin/export", func(w http.ResponseWriter, r *http.Request) {
+		exportHandler(appState, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/admin/import", func(w http.ResponseWriter, r *http.Request) {
+		importHandler(appState, w, r)
+	}).Methods("POST")
+	router.HandleFunc("/admin/webhooks/deliveries", func(w http.ResponseWriter, r *http.Request) {
+		webhookDeliveriesHandler(appState, w, r)
+	}).Methods("GET")
 	return router
 }
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Tests the vouch endpoint with valid input
@@ -346,4 +352,838 @@ func TestIdtHandler_Success(t *testing.T) {
 	if resp.Penalty != 0 {
 		t.Errorf("Expected penalty 0, got %d", resp.Penalty)
 	}
+	if resp.Scoring != ScoringModeTree {
+		t.Errorf("Expected scoring 'tree', got '%s'", resp.Scoring)
+	}
+	if resp.Tier != TierUnverified {
+		t.Errorf("Expected tier 'unverified', got '%s'", resp.Tier)
+	}
+}
+
+// Tests that a dump exported over HTTP can be imported into another instance
+func TestExportImportHandlers(t *testing.T) {
+	source := NewAppState()
+	source.adminToken = "admin"
+	populateExportStorage(t, source.storage)
+
+	req := httptest.NewRequest("GET", "/admin/export?format=jsonl", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	exportHandler(source, w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Fatalf("Expected content type application/x-ndjson, got %q", got)
+	}
+
+	target := NewAppState()
+	target.adminToken = "admin"
+	req = httptest.NewRequest("POST", "/admin/import?format=jsonl", bytes.NewReader(w.Body.Bytes()))
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	importHandler(target, w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var resp ImportResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !resp.Success || resp.Imported != 7 {
+		t.Fatalf("unexpected import response: %#v", resp)
+	}
+	compareStorages(t, source.storage, target.storage)
+}
+
+// Tests that admin endpoints require the configured admin token
+func TestAdminHandlers_RequireToken(t *testing.T) {
+	appState := NewAppState()
+	router := SetupRouterWithState(appState)
+	requests := []struct {
+		method, path string
+	}{
+		{"GET", "/admin/export?format=jsonl"},
+		{"POST", "/admin/import?format=jsonl"},
+		{"GET", "/admin/webhooks/deliveries"},
+	}
+	for _, token := range []string{"", "wrong"} {
+		// Without a configured token the endpoints are disabled
+		expected := http.StatusForbidden
+		if token != "" {
+			appState.adminToken = "admin"
+			expected = http.StatusUnauthorized
+		}
+		for _, r := range requests {
+			req := httptest.NewRequest(r.method, r.path, strings.NewReader(""))
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != expected {
+				t.Fatalf("Expected status %d for %s %s, got %d", expected, r.method, r.path, w.Code)
+			}
+		}
+	}
+}
+
+// Tests that an unsupported format is rejected
+func TestExportHandler_InvalidFormat(t *testing.T) {
+	appState := NewAppState()
+	appState.adminToken = "admin"
+	req := httptest.NewRequest("GET", "/admin/export?format=xml", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	exportHandler(appState, w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// Tests that the graph endpoint exports a user's tree
+func TestGraphHandler_Tree(t *testing.T) {
+	state := newGraphExportState()
+	req := httptest.NewRequest("GET", "/graph?format=json&user=carol&direction=in&depth=1", nil)
+	w := httptest.NewRecorder()
+	graphHandler(state, w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var graph Graph
+	if err := json.NewDecoder(w.Body).Decode(&graph); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(graph.Nodes) != 3 || len(graph.Edges) != 2 {
+		t.Fatalf("unexpected graph: %#v", graph)
+	}
+}
+
+// Tests that invalid graph parameters are rejected
+func TestGraphHandler_InvalidParams(t *testing.T) {
+	for _, query := range []string{"format=svg", "user=alice&direction=up", "user=alice&depth=x", "user=alice&depth=-1"} {
+		req := httptest.NewRequest("GET", "/graph?"+query, nil)
+		w := httptest.NewRecorder()
+		graphHandler(NewAppState(), w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %d for %q, got %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
+
+// Tests the path endpoint
+func TestPathHandler(t *testing.T) {
+	state := NewAppState()
+	state.AddVouch(VouchEvent{From: "alice", To: "bob"})
+	state.AddVouch(VouchEvent{From: "bob", To: "carol"})
+
+	req := httptest.NewRequest("GET", "/path?from=alice&to=carol", nil)
+	w := httptest.NewRecorder()
+	pathHandler(state, w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var resp PathResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.From != "alice" || resp.To != "carol" || len(resp.Paths) != 1 || resp.Paths[0].Hops != 2 {
+		t.Fatalf("unexpected path response: %#v", resp)
+	}
+
+	req = httptest.NewRequest("GET", "/path?from=alice", nil)
+	w = httptest.NewRecorder()
+	pathHandler(state, w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// Tests that the sybil endpoint reports suspicious clusters
+func TestSybilHandler(t *testing.T) {
+	req := httptest.NewRequest("GET", "/moderation/sybil", nil)
+	w := httptest.NewRecorder()
+	sybilHandler(newSybilState(), w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var resp SybilResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Clusters) != 1 {
+		t.Fatalf("expected 1 cluster, got %#v", resp.Clusters)
+	}
+}
+
+// Tests that the idt endpoint accepts a scoring mode per request
+func TestIdtHandler_Scoring(t *testing.T) {
+	state := NewAppState()
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: time.Now().UTC()})
+	router := SetupRouterWithState(state)
+
+	req := httptest.NewRequest("GET", "/idt/alice?scoring=pagerank", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var resp IdtResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Scoring != ScoringModePageRank || resp.Balance != 100 {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	req = httptest.NewRequest("GET", "/idt/alice?scoring=eigen", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// Tests the stats endpoint
+func TestStatsHandler(t *testing.T) {
+	state := NewAppState()
+	state.AddVouch(VouchEvent{From: "alice", To: "bob"})
+
+	req := httptest.NewRequest("GET", "/stats", nil)
+	w := httptest.NewRecorder()
+	statsHandler(state, w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var resp GraphStats
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Users != 2 || resp.Vouches != 1 || resp.StronglyConnectedComponents != 2 {
+		t.Fatalf("unexpected stats: %#v", resp)
+	}
+}
+
+// Tests that the vouch endpoint stores the requested weight and rejects invalid ones
+func TestVouchHandler_Weight(t *testing.T) {
+	appState := NewAppState()
+	body, _ := json.Marshal(VouchRequest{From: "user1", Signature: "sig", Nonce: "nonce", To: "user2", Weight: 30})
+	req := httptest.NewRequest("POST", "/vouch", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	vouchHandler(appState, w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	vouches := appState.UserVouchesFrom("user1")
+	if len(vouches) != 1 || vouches[0].Weight != 30 {
+		t.Fatalf("expected vouch with weight 30, got %#v", vouches)
+	}
+
+	body, _ = json.Marshal(VouchRequest{From: "user1", Signature: "sig", Nonce: "nonce", To: "user3"})
+	req = httptest.NewRequest("POST", "/vouch", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	vouchHandler(appState, w, req)
+	vouches = appState.UserVouchesTo("user3")
+	if len(vouches) != 1 || vouches[0].Weight != maxVouchWeight {
+		t.Fatalf("expected vouch with full weight, got %#v", vouches)
+	}
+
+	body, _ = json.Marshal(VouchRequest{From: "user1", Signature: "sig", Nonce: "nonce", To: "user4", Weight: 101})
+	req = httptest.NewRequest("POST", "/vouch", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	vouchHandler(appState, w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	var resp AnyResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Message != ErrInvalidVouchWeight.Error() {
+		t.Fatalf("Expected message %q, got %q", ErrInvalidVouchWeight.Error(), resp.Message)
+	}
+}
+
+// Tests that vouches get the deployment's TTL and that expiries beyond it are rejected
+func TestVouchHandler_Expiry(t *testing.T) {
+	appState := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	appState.now = func() time.Time { return now }
+	appState.vouchTTL = 30 * 24 * time.Hour
+
+	body, _ := json.Marshal(VouchRequest{From: "user1", Signature: "sig", Nonce: "nonce", To: "user2"})
+	req := httptest.NewRequest("POST", "/vouch", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	vouchHandler(appState, w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	vouches := appState.UserVouchesFrom("user1")
+	if len(vouches) != 1 || !vouches[0].ExpiresAt.Equal(now.Add(appState.vouchTTL)) {
+		t.Fatalf("expected vouch to expire after the TTL, got %#v", vouches)
+	}
+
+	body, _ = json.Marshal(VouchRequest{From: "user1", Signature: "sig", Nonce: "nonce", To: "user3", ExpiresAt: now.Add(7 * 24 * time.Hour)})
+	req = httptest.NewRequest("POST", "/vouch", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	vouchHandler(appState, w, req)
+	vouches = appState.UserVouchesTo("user3")
+	if len(vouches) != 1 || !vouches[0].ExpiresAt.Equal(now.Add(7*24*time.Hour)) {
+		t.Fatalf("expected vouch with requested expiry, got %#v", vouches)
+	}
+
+	for _, expiresAt := range []time.Time{now, now.Add(60 * 24 * time.Hour)} {
+		body, _ = json.Marshal(VouchRequest{From: "user1", Signature: "sig", Nonce: "nonce", To: "user4", ExpiresAt: expiresAt})
+		req = httptest.NewRequest("POST", "/vouch", bytes.NewBuffer(body))
+		w = httptest.NewRecorder()
+		vouchHandler(appState, w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %d for expiry %v, got %d", http.StatusBadRequest, expiresAt, w.Code)
+		}
+	}
+}
+
+// Tests that renewal extends an existing vouch without changing its timestamp
+func TestRenewHandler(t *testing.T) {
+	appState := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	appState.AddVouch(VouchEvent{From: "user1", To: "user2", Timestamp: timestamp, Weight: 40, ExpiresAt: timestamp.Add(time.Hour)})
+	now := timestamp.Add(2 * time.Hour)
+	appState.now = func() time.Time { return now }
+
+	body, _ := json.Marshal(RenewRequest{From: "user1", Signature: "sig", Nonce: "nonce", To: "user2", ExpiresAt: now.Add(time.Hour)})
+	req := httptest.NewRequest("POST", "/vouch/renew", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	renewHandler(appState, w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	expected := VouchEvent{From: "user1", To: "user2", Timestamp: timestamp, Weight: 40, ExpiresAt: now.Add(time.Hour)}
+	vouches := appState.UserVouchesFrom("user1")
+	if len(vouches) != 1 || vouches[0] != expected {
+		t.Fatalf("expected renewed vouch %#v, got %#v", expected, vouches)
+	}
+
+	// Renewal cannot shorten the vouch
+	body, _ = json.Marshal(RenewRequest{From: "user1", Signature: "sig", Nonce: "nonce", To: "user2", ExpiresAt: now.Add(time.Minute)})
+	req = httptest.NewRequest("POST", "/vouch/renew", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	renewHandler(appState, w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	body, _ = json.Marshal(RenewRequest{From: "user2", Signature: "sig", Nonce: "nonce", To: "user1"})
+	req = httptest.NewRequest("POST", "/vouch/renew", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	renewHandler(appState, w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	var resp AnyResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Message != ErrVouchNotFound.Error() {
+		t.Fatalf("Expected message %q, got %q", ErrVouchNotFound.Error(), resp.Message)
+	}
+}
+
+// Tests that the vouch endpoint reports rate limiting with 429
+func TestVouchHandler_RateLimited(t *testing.T) {
+	appState := NewAppState()
+	appState.vouchLimits = VouchLimits{MaxPerWindow: 1, RateWindow: time.Hour}
+
+	codes := []int{}
+	for _, to := range []string{"user2", "user3"} {
+		body, _ := json.Marshal(VouchRequest{From: "user1", Signature: "sig", Nonce: "nonce", To: to})
+		req := httptest.NewRequest("POST", "/vouch", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		vouchHandler(appState, w, req)
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Fatalf("Expected statuses [200 429], got %v", codes)
+	}
+}
+
+// Tests that the tier endpoint applies the deployment's thresholds
+func TestTierHandler(t *testing.T) {
+	appState := NewAppState()
+	appState.tiers = &TierThresholds{Basic: 5, Trusted: 50, MaxPenalty: 0}
+	timestamp := time.Now().UTC()
+	appState.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+	appState.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp})
+	router := SetupRouterWithState(appState)
+
+	for user, expected := range map[string]Tier{"alice": TierModeratorVerified, "bob": TierBasic, "carol": TierUnverified} {
+		req := httptest.NewRequest("GET", "/idt/"+user+"/tier", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		var resp TierResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.User != user || resp.Tier != expected {
+			t.Fatalf("Expected %s to be %s, got %#v", user, expected, resp)
+		}
+	}
+}
+
+// Tests that an attestation from the endpoint verifies with the published keys
+func TestAttestationHandler(t *testing.T) {
+	router := SetupRouter()
+
+	req := httptest.NewRequest("GET", "/idt/alice/attestation?scoring=pagerank", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var resp AttestationResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var keys JWKSet
+	if err := json.NewDecoder(w.Body).Decode(&keys); err != nil {
+		t.Fatalf("Failed to decode key set: %v", err)
+	}
+
+	claims, err := VerifyAttestation(resp.Attestation, keys, time.Now())
+	if err != nil {
+		t.Fatalf("attestation did not verify: %v", err)
+	}
+	if claims != resp.Claims || claims.Subject != "alice" || claims.Scoring.Damping != pageRankDamping {
+		t.Fatalf("unexpected claims: %#v", claims)
+	}
+}
+
+// Tests the challenge and token endpoints end to end
+func TestTokenHandler(t *testing.T) {
+	router := SetupRouter()
+	public, private, _ := ed25519.GenerateKey(nil)
+
+	body, _ := json.Marshal(ChallengeRequest{User: "alice"})
+	req := httptest.NewRequest("POST", "/auth/challenge", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var challenge ChallengeResponse
+	if err := json.NewDecoder(w.Body).Decode(&challenge); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	body, _ = json.Marshal(TokenRequest{User: "alice", Challenge: challenge.Challenge, Signature: ed25519.Sign(private, []byte(challenge.Challenge)), PublicKey: public})
+	req = httptest.NewRequest("POST", "/auth/token", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp TokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.TokenType != "Bearer" || resp.ExpiresIn != int64(accessTokenLifetime/time.Second) {
+		t.Fatalf("unexpected token response: %#v", resp)
+	}
+
+	// The challenge has been used
+	req = httptest.NewRequest("POST", "/auth/token", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+// Tests the OpenID Connect endpoints through a full authorization code flow
+func TestOIDCHandlers(t *testing.T) {
+	appState := newOIDCState()
+	router := SetupRouterWithState(appState)
+	public, private, _ := ed25519.GenerateKey(nil)
+	authRequest := newAuthorizationRequest("verifier")
+	params := url.Values{
+		"response_type":         {authRequest.ResponseType},
+		"client_id":             {authRequest.ClientID},
+		"redirect_uri":          {authRequest.RedirectURI},
+		"scope":                 {authRequest.Scope},
+		"state":                 {authRequest.State},
+		"nonce":                 {authRequest.Nonce},
+		"code_challenge":        {authRequest.CodeChallenge},
+		"code_challenge_method": {authRequest.CodeChallengeMethod},
+	}
+
+	req := httptest.NewRequest("GET", "/.well-known/openid-configuration", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var discovery OIDCDiscovery
+	if err := json.NewDecoder(w.Body).Decode(&discovery); err != nil {
+		t.Fatalf("Failed to decode discovery document: %v", err)
+	}
+	if discovery.TokenEndpoint != appState.issuerURL()+"/oidc/token" || len(discovery.IDTokenSigningAlgValuesSupported) != 1 || discovery.IDTokenSigningAlgValuesSupported[0] != "RS256" {
+		t.Fatalf("unexpected discovery document: %#v", discovery)
+	}
+
+	req = httptest.NewRequest("GET", "/oidc/authorize?"+params.Encode()+"&login_hint=alice", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var challenge ChallengeResponse
+	if err := json.NewDecoder(w.Body).Decode(&challenge); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	form := url.Values{}
+	for key, values := range params {
+		form[key] = values
+	}
+	form.Set("user", "alice")
+	form.Set("challenge", challenge.Challenge)
+	form.Set("signature", jwsEncoding.EncodeToString(ed25519.Sign(private, []byte(challenge.Challenge))))
+	form.Set("public_key", jwsEncoding.EncodeToString(public))
+	req = httptest.NewRequest("POST", "/oidc/authorize", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusFound, w.Code, w.Body.String())
+	}
+	location, _ := url.Parse(w.Header().Get("Location"))
+	code := location.Query().Get("code")
+	if code == "" || location.Query().Get("state") != "xyz" {
+		t.Fatalf("unexpected redirect: %s", location)
+	}
+
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {"app"},
+		"redirect_uri":  {authRequest.RedirectURI},
+		"code_verifier": {"wrong"},
+	}
+	req = httptest.NewRequest("POST", "/oidc/token", strings.NewReader(exchange.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var oauthErr OAuthErrorResponse
+	json.NewDecoder(w.Body).Decode(&oauthErr)
+	if w.Code != http.StatusBadRequest || oauthErr.Error != "invalid_grant" {
+		t.Fatalf("Expected invalid_grant, got %d: %#v", w.Code, oauthErr)
+	}
+
+	// A failed exchange burns the code, so authorize again
+	nonce, _, _ := ChallengeHandler(appState, "alice")
+	code, _ = AuthorizeHandler(appState, authRequest, "alice", nonce, ed25519.Sign(private, []byte(nonce)), nil)
+	exchange.Set("code", code)
+	exchange.Set("code_verifier", "verifier")
+	req = httptest.NewRequest("POST", "/oidc/token", strings.NewReader(exchange.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var tokens OIDCTokens
+	if err := json.NewDecoder(w.Body).Decode(&tokens); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "/oidc/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var info UserInfo
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if w.Code != http.StatusOK || info.Subject != "alice" {
+		t.Fatalf("unexpected userinfo: %d %#v", w.Code, info)
+	}
+
+	req = httptest.NewRequest("GET", "/oidc/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer invalid")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("Expected status %d with a challenge, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+// Tests that unknown clients are never redirected to
+func TestOIDCAuthorizeHandler_UnknownClient(t *testing.T) {
+	router := SetupRouterWithState(newOIDCState())
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {"other"},
+		"redirect_uri":  {"https://evil.example/callback"},
+		"scope":         {"openid"},
+		"login_hint":    {"alice"},
+	}
+	req := httptest.NewRequest("GET", "/oidc/authorize?"+params.Encode(), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || w.Header().Get("Location") != "" {
+		t.Fatalf("Expected status %d without redirect, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// Tests that vouch accepts a session token from /auth/verify instead of a signature
+func TestVouchHandler_Session(t *testing.T) {
+	appState := NewAppState()
+	router := SetupRouterWithState(appState)
+	public, private, _ := ed25519.GenerateKey(nil)
+	appState.SetKey(KeyEvent{User: "alice", PublicKey: public, Timestamp: time.Now()})
+
+	nonce, _, _ := ChallengeHandler(appState, "alice")
+	body, _ := json.Marshal(VerifyRequest{User: "alice", Challenge: nonce, Signature: ed25519.Sign(private, []byte(nonce))})
+	req := httptest.NewRequest("POST", "/auth/verify", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var session SessionResponse
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		from          string
+		expected      int
+	}{
+		{"session", "Bearer " + session.SessionToken, "", http.StatusOK},
+		{"session with matching user", "Bearer " + session.SessionToken, "alice", http.StatusOK},
+		{"session of another user", "Bearer " + session.SessionToken, "bob", http.StatusForbidden},
+		{"invalid session", "Bearer invalid", "alice", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(VouchRequest{From: tt.from, To: "carol"})
+			req := httptest.NewRequest("POST", "/vouch", bytes.NewBuffer(body))
+			req.Header.Set("Authorization", tt.authorization)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.expected {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	vouches := appState.UserVouchesFrom("alice")
+	if len(vouches) != 1 || vouches[0].To != "carol" {
+		t.Fatalf("expected a vouch from alice to carol, got %#v", vouches)
+	}
+}
+
+// Tests that followers serve reads and reject writes
+func TestReadOnlyReplica(t *testing.T) {
+	appState := NewAppState()
+	appState.readOnly = true
+	appState.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: time.Now()})
+	router := SetupRouterWithState(appState)
+
+	body, _ := json.Marshal(VouchRequest{From: "alice", Signature: "sig", Nonce: "nonce", To: "bob"})
+	req := httptest.NewRequest("POST", "/vouch", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/idt/alice", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	// Replication is not enabled on this node
+	req = httptest.NewRequest("GET", "/replication/events", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// Tests that the commitment endpoints serve proofs against the signed root
+func TestCommitmentHandlers(t *testing.T) {
+	appState := NewAppState()
+	appState.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: time.Now()})
+	router := SetupRouterWithState(appState)
+
+	req := httptest.NewRequest("GET", "/commitment", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var commitment CommitmentResponse
+	if err := json.NewDecoder(w.Body).Decode(&commitment); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if _, err := VerifyCommitment(commitment.Commitment, appState.publicKeys()); err != nil || commitment.Claims.Size != 1 {
+		t.Fatalf("unexpected commitment: %#v/%v", commitment, err)
+	}
+
+	req = httptest.NewRequest("GET", "/commitment/users/alice", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var user UserCommitmentResponse
+	if err := json.NewDecoder(w.Body).Decode(&user); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(user.Events) != 1 || user.Root != commitment.Claims.Root {
+		t.Fatalf("unexpected user commitment: %#v", user)
+	}
+
+	req = httptest.NewRequest("GET", "/commitment/proof?leaf="+user.Events[0].Proof.LeafHash, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var membership MembershipProof
+	if err := json.NewDecoder(w.Body).Decode(&membership); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !membership.Included {
+		t.Fatalf("expected the proof to be included: %#v", membership)
+	}
+
+	req = httptest.NewRequest("GET", "/commitment/proof?leaf=xyz", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// Tests listing the webhook delivery log by status
+func TestWebhookDeliveriesHandler(t *testing.T) {
+	appState := NewAppState()
+	appState.SetWebhookDelivery(WebhookDelivery{ID: "a", Status: WebhookDeliveryDelivered, Payload: json.RawMessage(`{}`)})
+	appState.SetWebhookDelivery(WebhookDelivery{ID: "b", Status: WebhookDeliveryFailed, Payload: json.RawMessage(`{}`)})
+	appState.adminToken = "admin"
+	router := SetupRouterWithState(appState)
+
+	req := httptest.NewRequest("GET", "/admin/webhooks/deliveries?status=failed", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var resp WebhookDeliveriesResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Deliveries) != 1 || resp.Deliveries[0].ID != "b" {
+		t.Fatalf("expected the failed delivery, got %#v", resp.Deliveries)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/webhooks/deliveries?status=lost", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// Represents an event read from a Server-Sent Events stream
+type serverSentEvent struct {
+	id   string
+	name string
+	data string
+}
+
+// Reads the next event of a stream, skipping comments.
+func readServerSentEvent(t *testing.T, reader *bufio.Reader) serverSentEvent {
+	var event serverSentEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event.name != "":
+			return event
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// Opens the event stream of the server with the query and Last-Event-ID.
+func openEventStream(t *testing.T, server *httptest.Server, query string, lastID string) *bufio.Reader {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/events/stream?"+query, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// Tests streaming live events filtered by user and resuming after a reconnect
+func TestEventStreamHandler(t *testing.T) {
+	appState := NewAppState()
+	server := httptest.NewServer(SetupRouterWithState(appState))
+	// Registered first so that it runs after the streams are closed
+	t.Cleanup(server.Close)
+
+	stream := openEventStream(t, server, "user=bob", "")
+	appState.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: time.Now().UTC()})
+	appState.SetProof(ProofEvent{User: "carol", Balance: 10, Timestamp: time.Now().UTC()})
+	PunishHandler(appState, "bob", 5)
+
+	vouch := readServerSentEvent(t, stream)
+	var event Event
+	if err := json.Unmarshal([]byte(vouch.data), &event); err != nil {
+		t.Fatalf("Failed to decode event: %v", err)
+	}
+	if vouch.name != "vouch" || vouch.id == "" || event.Vouch == nil || event.Vouch.To != "bob" {
+		t.Fatalf("expected the vouch for bob, got %#v", vouch)
+	}
+	// The proof of carol is filtered out
+	penalty := readServerSentEvent(t, stream)
+	if penalty.name != "penalty" {
+		t.Fatalf("expected the penalty of bob, got %#v", penalty)
+	}
+
+	// Resuming after the vouch replays the later events
+	resumed := openEventStream(t, server, "", vouch.id)
+	if proof := readServerSentEvent(t, resumed); proof.name != "proof" || !strings.Contains(proof.data, "carol") {
+		t.Fatalf("expected the proof of carol, got %#v", proof)
+	}
+	if replayed := readServerSentEvent(t, resumed); replayed.id != penalty.id {
+		t.Fatalf("expected the penalty of bob, got %#v", replayed)
+	}
+
+	// An unknown event cannot be resumed from
+	reset := openEventStream(t, server, "last_event_id=unknown-1", "")
+	if event := readServerSentEvent(t, reset); event.name != "reset" {
+		t.Fatalf("expected a reset event, got %#v", event)
+	}
 }
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// Algorithm of JWS signatures made with ed25519 keys (RFC 8037).
+const jwsAlgorithm = "EdDSA"
+
+// Algorithm of JWS signatures made with RSA keys. OpenID Connect requires
+// it for ID tokens, so they are signed with a separate RSA key.
+const rsaAlgorithm = "RS256"
+
+// Size of generated RSA keys in bits.
+const rsaKeyBits = 2048
+
+// Represents the server key used to sign attestations and tokens.
+type SigningKey struct {
+	// Key ID, the RFC 7638 thumbprint of the public key.
+	ID      string
+	Private ed25519.PrivateKey
+}
+
+// Represents the server key used to sign OpenID Connect ID tokens.
+type RSASigningKey struct {
+	// Key ID, the RFC 7638 thumbprint of the public key.
+	ID      string
+	Private *rsa.PrivateKey
+}
+
+// Represents a public key in JSON Web Key format. Ed25519 keys set the
+// curve and X, RSA keys the modulus and exponent.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+// Represents a JSON Web Key Set.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Represents the protected header of a compact JWS.
+type jwsHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ,omitempty"`
+}
+
+var jwsEncoding = base64.RawURLEncoding
+
+// Creates a signing key from an ed25519 private key.
+func newSigningKey(private ed25519.PrivateKey) *SigningKey {
+	return &SigningKey{ID: keyThumbprint(private.Public().(ed25519.PublicKey)), Private: private}
+}
+
+// Returns the RFC 7638 thumbprint of an ed25519 public key.
+func keyThumbprint(public ed25519.PublicKey) string {
+	// Members in lexicographic order without whitespace
+	thumbprint := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + jwsEncoding.EncodeToString(public) + `"}`))
+	return jwsEncoding.EncodeToString(thumbprint[:])
+}
+
+// Generates a new random signing key.
+func GenerateSigningKey() (*SigningKey, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(private), nil
+}
+
+// Loads a signing key from a file holding a base64 encoded ed25519 seed.
+// If the file does not exist, a new key is generated and saved to it.
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := GenerateSigningKey()
+		if err != nil {
+			return nil, err
+		}
+		seed := base64.StdEncoding.EncodeToString(key.Private.Seed())
+		if err := os.WriteFile(path, []byte(seed+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key: expected %d byte seed, got %d", ed25519.SeedSize, len(seed))
+	}
+	return newSigningKey(ed25519.NewKeyFromSeed(seed)), nil
+}
+
+// Returns the public part of the key in JWK format.
+func (k *SigningKey) JWK() JWK {
+	return JWK{
+		KeyType:   "OKP",
+		Curve:     "Ed25519",
+		X:         jwsEncoding.EncodeToString(k.Private.Public().(ed25519.PublicKey)),
+		KeyID:     k.ID,
+		Algorithm: jwsAlgorithm,
+		Use:       "sig",
+	}
+}
+
+// Signs the JSON encoding of the payload as a compact JWS.
+func (k *SigningKey) Sign(payload any, typ string) (string, error) {
+	signingInput, err := jwsSigningInput(jwsHeader{Algorithm: jwsAlgorithm, KeyID: k.ID, Type: typ}, payload)
+	if err != nil {
+		return "", err
+	}
+	signature := ed25519.Sign(k.Private, []byte(signingInput))
+	return signingInput + "." + jwsEncoding.EncodeToString(signature), nil
+}
+
+// Creates an ID token signing key from an RSA private key.
+func newRSASigningKey(private *rsa.PrivateKey) *RSASigningKey {
+	n, e := rsaPublicParameters(&private.PublicKey)
+	// Members in lexicographic order without whitespace
+	thumbprint := sha256.Sum256([]byte(`{"e":"` + e + `","kty":"RSA","n":"` + n + `"}`))
+	return &RSASigningKey{ID: jwsEncoding.EncodeToString(thumbprint[:]), Private: private}
+}
+
+// Returns the modulus and exponent of an RSA public key as JWK members.
+func rsaPublicParameters(public *rsa.PublicKey) (string, string) {
+	return jwsEncoding.EncodeToString(public.N.Bytes()), jwsEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+}
+
+// Generates a new random ID token signing key.
+func GenerateRSASigningKey() (*RSASigningKey, error) {
+	private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	return newRSASigningKey(private), nil
+}
+
+// Loads an ID token signing key from a PEM encoded PKCS #8 file.
+// If the file does not exist, a new key is generated and saved to it.
+func LoadRSASigningKey(path string) (*RSASigningKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := GenerateRSASigningKey()
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("invalid ID token key: expected a PEM encoded private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token key: %w", err)
+	}
+	private, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid ID token key: not an RSA key")
+	}
+	return newRSASigningKey(private), nil
+}
+
+// Returns the public part of the key in JWK format.
+func (k *RSASigningKey) JWK() JWK {
+	n, e := rsaPublicParameters(&k.Private.PublicKey)
+	return JWK{
+		KeyType:   "RSA",
+		N:         n,
+		E:         e,
+		KeyID:     k.ID,
+		Algorithm: rsaAlgorithm,
+		Use:       "sig",
+	}
+}
+
+// Signs the JSON encoding of the payload as a compact JWS with RS256.
+func (k *RSASigningKey) Sign(payload any, typ string) (string, error) {
+	signingInput, err := jwsSigningInput(jwsHeader{Algorithm: rsaAlgorithm, KeyID: k.ID, Type: typ}, payload)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, k.Private, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + jwsEncoding.EncodeToString(signature), nil
+}
+
+// Returns the encoded header and payload of a compact JWS.
+func jwsSigningInput(header jwsHeader, payload any) (string, error) {
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return jwsEncoding.EncodeToString(headerData) + "." + jwsEncoding.EncodeToString(body), nil
+}
+
+// Returns the key with the given ID as an ed25519 public key.
+func (s JWKSet) publicKey(keyID string) (ed25519.PublicKey, error) {
+	for _, key := range s.Keys {
+		if key.KeyID != keyID {
+			continue
+		}
+		if key.KeyType != "OKP" || key.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported key type %s/%s", key.KeyType, key.Curve)
+		}
+		public, err := jwsEncoding.DecodeString(key.X)
+		if err != nil || len(public) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key %q", keyID)
+		}
+		return ed25519.PublicKey(public), nil
+	}
+	return nil, fmt.Errorf("unknown key %q", keyID)
+}
+
+// Returns the key with the given ID as an RSA public key.
+func (s JWKSet) rsaPublicKey(keyID string) (*rsa.PublicKey, error) {
+	for _, key := range s.Keys {
+		if key.KeyID != keyID {
+			continue
+		}
+		if key.KeyType != "RSA" {
+			return nil, fmt.Errorf("unsupported key type %s", key.KeyType)
+		}
+		n, nErr := jwsEncoding.DecodeString(key.N)
+		e, eErr := jwsEncoding.DecodeString(key.E)
+		if nErr != nil || eErr != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid public key %q", keyID)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", keyID)
+}
+
+// Verifies a compact JWS against the key set and decodes its payload into out.
+// The header type must match typ. Only EdDSA signatures are accepted, so ID
+// tokens signed with RS256 do not pass as other documents.
+func VerifyJWS(token string, keys JWKSet, typ string, out any) error {
+	return verifyJWS(token, typ, out, jwsAlgorithm, func(keyID string, signingInput []byte, signature []byte) error {
+		public, err := keys.publicKey(keyID)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(public, signingInput, signature) {
+			return fmt.Errorf("invalid JWS signature")
+		}
+		return nil
+	})
+}
+
+// Verifies an RS256 signed ID token against the key set and decodes its
+// claims into out.
+func VerifyIDToken(token string, keys JWKSet, out any) error {
+	return verifyJWS(token, idTokenType, out, rsaAlgorithm, func(keyID string, signingInput []byte, signature []byte) error {
+		public, err := keys.rsaPublicKey(keyID)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(signingInput)
+		if err := rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("invalid JWS signature")
+		}
+		return nil
+	})
+}
+
+// Decodes a compact JWS with the algorithm and type, checks its signature
+// with `verify` and decodes its payload into out.
+func verifyJWS(token string, typ string, out any, algorithm string, verify func(keyID string, signingInput []byte, signature []byte) error) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed JWS")
+	}
+	headerData, err := jwsEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("malformed JWS header: %w", err)
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(headerData, &header); err != nil {
+		return fmt.Errorf("malformed JWS header: %w", err)
+	}
+	if header.Algorithm != algorithm {
+		return fmt.Errorf("unsupported algorithm %q", header.Algorithm)
+	}
+	if header.Type != typ {
+		return fmt.Errorf("unexpected token type %q", header.Type)
+	}
+	signature, err := jwsEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("malformed JWS signature: %w", err)
+	}
+	if err := verify(header.KeyID, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return err
+	}
+	payload, err := jwsEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("malformed JWS payload: %w", err)
+	}
+	return json.Unmarshal(payload, out)
+}
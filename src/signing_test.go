@@ -0,0 +1,109 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSigningKeyCreatesAndReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.key")
+	created, err := LoadSigningKey(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded, err := LoadSigningKey(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if created.ID != loaded.ID || !created.Private.Equal(loaded.Private) {
+		t.Fatalf("expected the saved key to be reloaded")
+	}
+}
+
+func TestVerifyJWS(t *testing.T) {
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	keys := JWKSet{Keys: []JWK{key.JWK()}}
+	token, err := key.Sign(map[string]string{"sub": "alice"}, "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var claims map[string]string
+	if err := VerifyJWS(token, keys, "test", &claims); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims["sub"] != "alice" {
+		t.Fatalf("unexpected claims: %v", claims)
+	}
+
+	if err := VerifyJWS(token, keys, "other", &claims); err == nil {
+		t.Fatal("expected error for unexpected token type")
+	}
+	parts := strings.Split(token, ".")
+	forged := parts[0] + "." + jwsEncoding.EncodeToString([]byte(`{"sub":"mallory"}`)) + "." + parts[2]
+	if err := VerifyJWS(forged, keys, "test", &claims); err == nil {
+		t.Fatal("expected error for forged payload")
+	}
+	other, _ := GenerateSigningKey()
+	if err := VerifyJWS(token, JWKSet{Keys: []JWK{other.JWK()}}, "test", &claims); err == nil {
+		t.Fatal("expected error for unknown key")
+	}
+}
+
+func TestLoadRSASigningKeyCreatesAndReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id-token.pem")
+	created, err := LoadRSASigningKey(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded, err := LoadRSASigningKey(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if created.ID != loaded.ID || !created.Private.Equal(loaded.Private) {
+		t.Fatalf("expected the saved key to be reloaded")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	key, err := GenerateRSASigningKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	edKey, _ := GenerateSigningKey()
+	keys := JWKSet{Keys: []JWK{key.JWK(), edKey.JWK()}}
+	token, err := key.Sign(map[string]string{"sub": "alice"}, idTokenType)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	header, _ := jwsEncoding.DecodeString(strings.Split(token, ".")[0])
+	if string(header) != `{"alg":"RS256","kid":"`+key.ID+`","typ":"JWT"}` {
+		t.Fatalf("unexpected header: %s", header)
+	}
+
+	var claims map[string]string
+	if err := VerifyIDToken(token, keys, &claims); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims["sub"] != "alice" {
+		t.Fatalf("unexpected claims: %v", claims)
+	}
+	parts := strings.Split(token, ".")
+	forged := parts[0] + "." + jwsEncoding.EncodeToString([]byte(`{"sub":"mallory"}`)) + "." + parts[2]
+	if err := VerifyIDToken(forged, keys, &claims); err == nil {
+		t.Fatal("expected error for forged payload")
+	}
+
+	// The algorithms do not pass for one another
+	if err := VerifyJWS(token, keys, idTokenType, &claims); err == nil {
+		t.Fatal("expected VerifyJWS to reject an RS256 token")
+	}
+	edToken, _ := edKey.Sign(map[string]string{"sub": "alice"}, idTokenType)
+	if err := VerifyIDToken(edToken, keys, &claims); err == nil {
+		t.Fatal("expected VerifyIDToken to reject an EdDSA token")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import "time"
+
+// Percentage of a vouch's remaining stake slashed each time the vouchee is punished.
+const stakeSlashPercent = 50
+
+// Returns the part of a stake slashed by a penalty on the vouchee.
+// Rounds up so that small stakes are still slashed, and never exceeds the
+// penalty itself.
+func slashedStake(stake uint64, penalty uint64) uint64 {
+	slashed := (stake*stakeSlashPercent + 99) / 100
+	return min(slashed, stake, penalty)
+}
+
+// Converts part of the stake of every active vouch for the punished user into
+// a penalty on the voucher. Slashed amounts are deducted from the stakes.
+func slashStakes(state *AppState, origin PenaltyEvent) {
+	for _, vouch := range state.UserVouchesTo(origin.User) {
+		if vouch.Stake == 0 || vouch.ExpiredAt(origin.Timestamp) {
+			continue
+		}
+		slashed := slashedStake(vouch.Stake, origin.Amount)
+		if slashed == 0 {
+			continue
+		}
+		vouch.Stake -= slashed
+		state.UpdateVouch(vouch, WriteReasonSlash)
+		state.AddPenalty(PenaltyEvent{
+			User:      vouch.From,
+			Amount:    slashed,
+			Timestamp: origin.Timestamp,
+			Origin:    origin.ID,
+		})
+	}
+}
+
+// Checks that the voucher's balance covers the stake of a new vouch for `to`
+// together with the stakes of the voucher's other active vouches.
+func checkStake(state *AppState, from string, to string, stake uint64, now time.Time) IdentityError {
+	if stake == 0 {
+		return nil
+	}
+	staked := stake
+	for _, vouch := range state.UserVouchesFrom(from) {
+		// The stake of a replaced vouch is released
+		if vouch.To == to || vouch.ExpiredAt(now) {
+			continue
+		}
+		staked += vouch.Stake
+	}
+	balance := Balance(state, from, nil, &now)
+	if balance <= 0 || staked > uint64(balance) {
+		return ErrInsufficientStake
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlashedStake(t *testing.T) {
+	cases := []struct {
+		stake, penalty, expected uint64
+	}{
+		{stake: 100, penalty: 1000, expected: 50},
+		{stake: 100, penalty: 20, expected: 20},
+		{stake: 1, penalty: 10, expected: 1},
+		{stake: 0, penalty: 10, expected: 0},
+	}
+	for _, c := range cases {
+		if got := slashedStake(c.stake, c.penalty); got != c.expected {
+			t.Fatalf("slashedStake(%d, %d) = %d, expected %d", c.stake, c.penalty, got, c.expected)
+		}
+	}
+}
+
+func TestPunishSlashesStakes(t *testing.T) {
+	state := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	state.SetProof(ProofEvent{User: "alice", Balance: 1000, Timestamp: now})
+	state.SetProof(ProofEvent{User: "carol", Balance: 1000, Timestamp: now})
+
+	if err := VouchHandler(state, "alice", "sig", "nonce", "bob", 0, time.Time{}, 200); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := VouchHandler(state, "carol", "sig", "nonce", "bob", 0, time.Time{}, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := PunishHandler(state, "bob", 500); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	origin := state.Penalties("bob")
+	if len(origin) != 1 {
+		t.Fatalf("expected the penalty on bob, got %#v", origin)
+	}
+	penalties := state.Penalties("alice")
+	expected := PenaltyEvent{User: "alice", Amount: 100, Timestamp: now, Origin: origin[0].ID}
+	if len(penalties) == 1 {
+		// Penalties get random IDs
+		expected.ID = penalties[0].ID
+	}
+	if len(penalties) != 1 || penalties[0] != expected {
+		t.Fatalf("expected slashing penalty %#v, got %#v", expected, penalties)
+	}
+	vouches := state.UserVouchesFrom("alice")
+	if len(vouches) != 1 || vouches[0].Stake != 100 {
+		t.Fatalf("expected remaining stake 100, got %#v", vouches)
+	}
+	// Vouchers without a stake only receive the propagated penalty
+	if penalties := state.Penalties("carol"); len(penalties) != 0 {
+		t.Fatalf("expected no slashing for carol, got %#v", penalties)
+	}
+	// 100 slashed + 10% of bob's 500 penalty
+	if got := Penalty(state, "alice", nil, nil); got != 150 {
+		t.Fatalf("expected penalty 150, got %d", got)
+	}
+}
+
+func TestVouchStakeLimitedByBalance(t *testing.T) {
+	state := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: now})
+
+	if err := VouchHandler(state, "alice", "sig", "nonce", "bob", 0, time.Time{}, 60); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := VouchHandler(state, "alice", "sig", "nonce", "carol", 0, time.Time{}, 60); err != ErrInsufficientStake {
+		t.Fatalf("expected %v, got %v", ErrInsufficientStake, err)
+	}
+	// Replacing a vouch releases its previous stake
+	if err := VouchHandler(state, "alice", "sig", "nonce", "bob", 0, time.Time{}, 100); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := VouchHandler(state, "dave", "sig", "nonce", "bob", 0, time.Time{}, 1); err != ErrInsufficientStake {
+		t.Fatalf("expected %v without balance, got %v", ErrInsufficientStake, err)
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"crypto/rand"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -9,6 +12,42 @@ import (
 type AppState struct {
 	storage Storage
 	now     func() time.Time
+	// default scoring mode for identity requests, tree scoring if empty
+	scoring ScoringMode
+	// lifetime of new and renewed vouches, vouches never expire if zero
+	vouchTTL time.Duration
+	// caps on outgoing vouches per user, unlimited if zero
+	vouchLimits VouchLimits
+	// identity tier cutoffs, defaultTierThresholds if nil
+	tiers *TierThresholds
+	// key signing attestations and tokens, generated on first use if nil
+	signingKey     *SigningKey
+	signingKeyOnce sync.Once
+	// RSA key signing ID tokens, generated on first use if nil
+	idTokenKey     *RSASigningKey
+	idTokenKeyOnce sync.Once
+	// public URL identifying this service in signed documents
+	issuer string
+	// login challenges waiting for a signature
+	challenges pendingStore[struct{}]
+	// relying parties of the OpenID Connect flow by client ID
+	oidcClients map[string]OIDCClient
+	// authorization codes waiting to be exchanged for tokens
+	authorizationCodes pendingStore[authorizationCode]
+	// event stream served to followers, replication is disabled if nil
+	replication *ReplicationLog
+	// rejects writes over HTTP on followers
+	readOnly bool
+	// peers and remote trust discount, defaults apply if nil
+	federation *FederationConfig
+	// latest Merkle commitment of the stored events
+	commitments commitmentStore
+	// ledger the commitments are published to, not anchored if nil
+	anchor Anchor
+	// accepted writes are published here for streams and webhooks
+	events *EventBus
+	// bearer token of the /admin endpoints, which are disabled if empty
+	adminToken string
 }
 
 // Returns the current time. Uses the overridable now function if set,
@@ -20,10 +59,91 @@ func (s *AppState) currentTime() time.Time {
 	return time.Now().UTC()
 }
 
+// Returns the scoring mode used when a request does not select one.
+func (s *AppState) defaultScoring() ScoringMode {
+	if s.scoring == "" {
+		return ScoringModeTree
+	}
+	return s.scoring
+}
+
+// Returns the key signing attestations and tokens.
+// Without a configured key, an ephemeral one is generated on first use.
+func (s *AppState) signer() *SigningKey {
+	s.signingKeyOnce.Do(func() {
+		if s.signingKey != nil {
+			return
+		}
+		key, err := GenerateSigningKey()
+		if err != nil {
+			log.Fatalf("Failed to generate signing key: %v", err)
+		}
+		s.signingKey = key
+	})
+	return s.signingKey
+}
+
+// Returns the RSA key signing OpenID Connect ID tokens.
+// Without a configured key, an ephemeral one is generated on first use.
+func (s *AppState) idTokenSigner() *RSASigningKey {
+	s.idTokenKeyOnce.Do(func() {
+		if s.idTokenKey != nil {
+			return
+		}
+		key, err := GenerateRSASigningKey()
+		if err != nil {
+			log.Fatalf("Failed to generate ID token key: %v", err)
+		}
+		s.idTokenKey = key
+	})
+	return s.idTokenKey
+}
+
+// Returns the public keys of the service.
+func (s *AppState) publicKeys() JWKSet {
+	return JWKSet{Keys: []JWK{s.signer().JWK(), s.idTokenSigner().JWK()}}
+}
+
+// Returns the URL identifying this service in signed documents.
+func (s *AppState) issuerURL() string {
+	if s.issuer == "" {
+		return fmt.Sprintf("http://localhost:%d", PORT)
+	}
+	return s.issuer
+}
+
+// Returns the tier cutoffs of the deployment.
+func (s *AppState) tierThresholds() TierThresholds {
+	if s.tiers == nil {
+		return defaultTierThresholds
+	}
+	return *s.tiers
+}
+
+// Resolves the expiry of a new or renewed vouch made at `now`.
+// Without a requested expiry the vouch lives for the deployment's TTL, if any.
+// A requested expiry must be in the future and within the TTL.
+func (s *AppState) vouchExpiry(now time.Time, requested time.Time) (time.Time, IdentityError) {
+	if requested.IsZero() {
+		if s.vouchTTL > 0 {
+			return now.Add(s.vouchTTL), nil
+		}
+		return time.Time{}, nil
+	}
+	if !requested.After(now) {
+		return time.Time{}, ErrInvalidVouchExpiry
+	}
+	if s.vouchTTL > 0 && requested.After(now.Add(s.vouchTTL)) {
+		return time.Time{}, ErrInvalidVouchExpiry
+	}
+	return requested.UTC(), nil
+}
+
 // Initializes an application state with in-memory storage.
 func NewAppState() *AppState {
 	return &AppState{
 		storage: NewMemoryStorage(),
+		events:  NewEventBus(eventBusHistory),
 	}
 }
 
@@ -31,6 +151,7 @@ func NewAppState() *AppState {
 func NewAppStateWithStorage(storage Storage) *AppState {
 	return &AppState{
 		storage: storage,
+		events:  NewEventBus(eventBusHistory),
 	}
 }
 
@@ -44,11 +165,25 @@ func (s *AppState) Users() []string {
 	return users
 }
 
+// Publishes an accepted write on the event bus.
+func (s *AppState) publish(event Event, reason WriteReason) {
+	s.events.Publish(event, reason)
+}
+
 // Records an incoming vouch event.
 func (s *AppState) AddVouch(vouch VouchEvent) {
+	s.UpdateVouch(vouch, "")
+}
+
+// Rewrites an existing vouch, such as a renewed one or one whose stake was
+// slashed. The reason is published with the vouch so that subscribers can
+// tell the update from a new vouch.
+func (s *AppState) UpdateVouch(vouch VouchEvent, reason WriteReason) {
 	if err := s.storage.AddVouch(vouch); err != nil {
 		log.Printf("Error adding vouch: %v", err)
+		return
 	}
+	s.publish(EventFromVouch(vouch), reason)
 }
 
 func (s *AppState) UserVouchesFrom(user string) []VouchEvent {
@@ -73,7 +208,9 @@ func (s *AppState) UserVouchesTo(user string) []VouchEvent {
 func (s *AppState) SetProof(proof ProofEvent) {
 	if err := s.storage.SetProof(proof); err != nil {
 		log.Printf("Error setting proof: %v", err)
+		return
 	}
+	s.publish(EventFromProof(proof), "")
 }
 
 // Returns the stored proof event for a user, if any.
@@ -85,11 +222,94 @@ func (s *AppState) ProofRecord(user string) (ProofEvent, error) {
 	return proof, nil
 }
 
+// Stores the public key of a user.
+func (s *AppState) SetKey(key KeyEvent) {
+	if err := s.storage.SetKey(key); err != nil {
+		log.Printf("Error setting key: %v", err)
+	}
+}
+
+// Returns the stored key of a user, if any.
+func (s *AppState) KeyRecord(user string) (KeyEvent, error) {
+	return s.storage.KeyRecord(user)
+}
+
 // Records a penalty event.
 func (s *AppState) AddPenalty(penalty PenaltyEvent) {
+	// Assigned here so that the published event carries the stored ID
+	if penalty.ID == "" {
+		penalty.ID = rand.Text()
+	}
 	if err := s.storage.AddPenalty(penalty); err != nil {
 		log.Printf("Error adding penalty: %v", err)
+		return
+	}
+	s.publish(EventFromPenalty(penalty), "")
+}
+
+// Stores an event written elsewhere, such as an imported, federated or
+// replicated one, and publishes it like a local write. Events already
+// stored unchanged are not published again, since peers and leaders are
+// replayed from the start after a restart. Keys are not published.
+func (s *AppState) ApplyEvent(event Event) error {
+	// Assigned here so that the published event carries the stored ID
+	if event.Kind == EventKindPenalty && event.Penalty != nil && event.Penalty.ID == "" {
+		penalty := *event.Penalty
+		penalty.ID = rand.Text()
+		event.Penalty = &penalty
+	}
+	if err := event.Validate(); err != nil {
+		return err
+	}
+	stored, err := s.hasEvent(event)
+	if err != nil {
+		return err
+	}
+	if err := event.Apply(s.storage); err != nil {
+		return err
+	}
+	if !stored && event.Kind != EventKindKey {
+		s.publish(event, "")
+	}
+	return nil
+}
+
+// Reports whether the storage already holds the vouch, proof or penalty
+// unchanged. Penalties are told apart by their ID.
+func (s *AppState) hasEvent(event Event) (bool, error) {
+	switch event.Kind {
+	case EventKindVouch:
+		vouches, err := s.storage.UserVouchesFrom(event.Vouch.From)
+		if err != nil {
+			return false, err
+		}
+		for _, vouch := range vouches {
+			if vouch.To == event.Vouch.To {
+				return sameVouch(vouch, *event.Vouch), nil
+			}
+		}
+	case EventKindProof:
+		proof, err := s.storage.ProofRecord(event.Proof.User)
+		if err != nil {
+			return false, err
+		}
+		return proof.Balance == event.Proof.Balance && proof.Timestamp.Equal(event.Proof.Timestamp), nil
+	case EventKindPenalty:
+		penalties, err := s.storage.Penalties(event.Penalty.User)
+		if err != nil {
+			return false, err
+		}
+		for _, penalty := range penalties {
+			if penalty.ID == event.Penalty.ID {
+				return true, nil
+			}
+		}
 	}
+	return false, nil
+}
+
+func sameVouch(a VouchEvent, b VouchEvent) bool {
+	return a.From == b.From && a.To == b.To && a.Timestamp.Equal(b.Timestamp) && a.Weight == b.Weight && a.ExpiresAt.Equal(b.ExpiresAt) && a.Stake == b.Stake
 }
 
 // Returns all stored penalties for a user.
@@ -102,6 +322,30 @@ func (s *AppState) Penalties(user string) []PenaltyEvent {
 	return penalties
 }
 
+// Records the receipt of an anchored state root.
+func (s *AppState) AddAnchorReceipt(receipt AnchorReceipt) {
+	if err := s.storage.AddAnchorReceipt(receipt); err != nil {
+		log.Printf("Error adding anchor receipt: %v", err)
+	}
+}
+
+// Returns the most recent anchor receipt, if any.
+func (s *AppState) LatestAnchorReceipt() (AnchorReceipt, bool) {
+	receipt, ok, err := s.storage.LatestAnchorReceipt()
+	if err != nil {
+		log.Printf("Error getting the latest anchor receipt: %v", err)
+		return AnchorReceipt{}, false
+	}
+	return receipt, ok
+}
+
+// Records the current state of a webhook delivery.
+func (s *AppState) SetWebhookDelivery(delivery WebhookDelivery) {
+	if err := s.storage.SetWebhookDelivery(delivery); err != nil {
+		log.Printf("Error recording webhook delivery: %v", err)
+	}
+}
+
 // Releases any resources used by the storage.
 func (s *AppState) Close() error {
 	return s.storage.Close()
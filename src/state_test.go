@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestNewAppStateEmpty(t *testing.T) {
 	state := NewAppState()
@@ -48,8 +51,8 @@ func TestAppStateVouchesReturnsCopy(t *testing.T) {
 
 func TestAppStatePenaltiesReturnsCopy(t *testing.T) {
 	state := NewAppState()
-	first := PenaltyEvent{User: "alice", Amount: 10}
-	second := PenaltyEvent{User: "alice", Amount: 20}
+	first := PenaltyEvent{ID: "p1", User: "alice", Amount: 10}
+	second := PenaltyEvent{ID: "p2", User: "alice", Amount: 20}
 	state.AddPenalty(first)
 	state.AddPenalty(second)
 
@@ -87,3 +90,43 @@ func TestAppStateSetProofReplacesExisting(t *testing.T) {
 		t.Fatalf("expected latest balance 25, got %d", proof.Balance)
 	}
 }
+
+func TestProveHandlerUsesStateClock(t *testing.T) {
+	state := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	if err := ProveHandler(state, "alice", 100); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	proof, _ := state.ProofRecord("alice")
+	if !proof.Timestamp.Equal(now) {
+		t.Fatalf("expected proof at %v, got %v", now, proof.Timestamp)
+	}
+}
+
+func TestApplyEventPublishes(t *testing.T) {
+	state := NewAppState()
+	var published []Event
+	state.events.Subscribe("", func(event BusEvent) {
+		published = append(published, event.Event)
+	})
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	if err := state.ApplyEvent(EventFromPenalty(PenaltyEvent{User: "alice", Amount: 5, Timestamp: now})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := state.ApplyEvent(EventFromKey(KeyEvent{User: "alice", PublicKey: make([]byte, 32), Timestamp: now})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Keys are stored but not published
+	if len(published) != 1 || published[0].Kind != EventKindPenalty {
+		t.Fatalf("expected only the penalty to be published, got %#v", published)
+	}
+	penalties := state.Penalties("alice")
+	if len(penalties) != 1 || published[0].Penalty.ID == "" || penalties[0].ID != published[0].Penalty.ID {
+		t.Fatalf("expected the published penalty to carry the stored ID, got %#v and %#v", published[0].Penalty, penalties)
+	}
+	if _, err := state.KeyRecord("alice"); err != nil {
+		t.Fatalf("expected the key to be stored: %v", err)
+	}
+}
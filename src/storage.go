@@ -6,7 +6,7 @@ type Storage interface {
 	// Records an incoming vouch event.
 	AddVouch(vouch VouchEvent) error
 
-	// Returns all users who have vouches, proofs, or penalties recorded.
+	// Returns all users who have vouches, proofs, penalties or keys recorded.
 	Users() ([]string, error)
 
 	// Returns all stored outgoing vouches for a specific user.
@@ -21,12 +21,36 @@ type Storage interface {
 	// Returns the stored proof event for a user, if any.
 	ProofRecord(user string) (ProofEvent, error)
 
-	// Records a penalty event.
+	// Records a penalty event. A penalty without an ID is given a new one.
+	// A penalty with the ID of a stored penalty is ignored, so that replayed
+	// events do not duplicate it.
 	AddPenalty(penalty PenaltyEvent) error
 
 	// Returns all penalties for a user.
 	Penalties(user string) ([]PenaltyEvent, error)
 
+	// Stores the public key of a user, replacing any prior key.
+	SetKey(key KeyEvent) error
+
+	// Returns the stored key of a user. The key is empty if none is registered.
+	KeyRecord(user string) (KeyEvent, error)
+
+	// Records the receipt of a state root published to an external ledger.
+	AddAnchorReceipt(receipt AnchorReceipt) error
+
+	// Returns all anchor receipts in the order they were recorded.
+	AnchorReceipts() ([]AnchorReceipt, error)
+
+	// Returns the most recently recorded anchor receipt. Reports false if
+	// there is none.
+	LatestAnchorReceipt() (AnchorReceipt, bool, error)
+
+	// Stores a webhook delivery, replacing any prior record with the same ID.
+	SetWebhookDelivery(delivery WebhookDelivery) error
+
+	// Returns all webhook deliveries in the order they were first recorded.
+	WebhookDeliveries() ([]WebhookDelivery, error)
+
 	// Releases any resources used by the storage.
 	Close() error
 }
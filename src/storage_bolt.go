@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// maps from_user\x00to_user to an outgoing vouch
+	boltVouchesFromBucket = []byte("vouches_from")
+	// maps to_user\x00from_user to an incoming vouch
+	boltVouchesToBucket = []byte("vouches_to")
+	// maps user to the latest proof
+	boltProofsBucket = []byte("proofs")
+	// maps user\x00sequence to a penalty, sequence keeps insertion order
+	boltPenaltiesBucket = []byte("penalties")
+	// maps penalty ID to the key of the penalty in the penalties bucket
+	boltPenaltyIDsBucket = []byte("penalty_ids")
+	// maps user to the registered public key
+	boltKeysBucket = []byte("keys")
+	// maps sequence to an anchor receipt, sequence keeps insertion order
+	boltAnchorsBucket = []byte("anchors")
+	// maps sequence to a webhook delivery, sequence keeps insertion order
+	boltWebhookDeliveriesBucket = []byte("webhook_deliveries")
+	// maps delivery ID to its sequence in the deliveries bucket
+	boltWebhookDeliveryIDsBucket = []byte("webhook_delivery_ids")
+)
+
+// Separates the parts of composite bucket keys.
+const boltKeySeparator = 0
+
+// Implements Storage using an embedded bbolt key-value database.
+// Unlike SQLiteStorage it is pure Go and does not require cgo.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// Creates a new bbolt storage backed by the file at the given path.
+// The file is created if it does not exist.
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	// Create buckets if they don't exist
+	err = db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltPenaltyIDsBucket) == nil {
+			if err := boltIndexPenalties(tx); err != nil {
+				return err
+			}
+		}
+		for _, name := range [][]byte{boltVouchesFromBucket, boltVouchesToBucket, boltProofsBucket, boltPenaltiesBucket, boltPenaltyIDsBucket, boltKeysBucket, boltAnchorsBucket, boltWebhookDeliveriesBucket, boltWebhookDeliveryIDsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStorage{db: db}, nil
+}
+
+// Creates the penalty ID index of a database written before penalties had
+// IDs. Such penalties get an ID derived from their sequence.
+func boltIndexPenalties(tx *bolt.Tx) error {
+	index, err := tx.CreateBucket(boltPenaltyIDsBucket)
+	if err != nil {
+		return err
+	}
+	penalties := tx.Bucket(boltPenaltiesBucket)
+	if penalties == nil {
+		return nil
+	}
+	type entry struct {
+		key   []byte
+		value []byte
+	}
+	updates := []entry{}
+	err = penalties.ForEach(func(k, v []byte) error {
+		var p PenaltyEvent
+		if err := json.Unmarshal(v, &p); err != nil {
+			return err
+		}
+		if p.ID == "" {
+			p.ID = fmt.Sprintf("bolt-%d", binary.BigEndian.Uint64(k[len(k)-8:]))
+			data, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			updates = append(updates, entry{bytes.Clone(k), data})
+		}
+		return index.Put([]byte(p.ID), bytes.Clone(k))
+	})
+	if err != nil {
+		return err
+	}
+	// Buckets must not be modified while they are iterated
+	for _, update := range updates {
+		if err := penalties.Put(update.key, update.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Joins a user with a key suffix into a composite key.
+func boltKey(user string, suffix []byte) []byte {
+	key := make([]byte, 0, len(user)+1+len(suffix))
+	key = append(key, user...)
+	key = append(key, boltKeySeparator)
+	return append(key, suffix...)
+}
+
+// Returns the user part of a composite key.
+func boltKeyUser(key []byte) string {
+	if i := bytes.IndexByte(key, boltKeySeparator); i >= 0 {
+		return string(key[:i])
+	}
+	return string(key)
+}
+
+// Calls fn for every value stored under the user's composite key prefix in key order.
+func boltScanUser(bucket *bolt.Bucket, user string, fn func(value []byte) error) error {
+	prefix := boltKey(user, nil)
+	cursor := bucket.Cursor()
+	for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns all users who have vouches, proofs, penalties or keys recorded.
+func (s *BoltStorage) Users() ([]string, error) {
+	userSet := make(map[string]struct{})
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltVouchesFromBucket, boltVouchesToBucket, boltPenaltiesBucket} {
+			err := tx.Bucket(name).ForEach(func(k, _ []byte) error {
+				userSet[boltKeyUser(k)] = struct{}{}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, name := range [][]byte{boltProofsBucket, boltKeysBucket} {
+			err := tx.Bucket(name).ForEach(func(k, _ []byte) error {
+				userSet[string(k)] = struct{}{}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]string, 0, len(userSet))
+	for user := range userSet {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// Records an incoming vouch event.
+func (s *BoltStorage) AddVouch(vouch VouchEvent) error {
+	data, err := json.Marshal(vouch)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltVouchesFromBucket).Put(boltKey(vouch.From, []byte(vouch.To)), data); err != nil {
+			return err
+		}
+		// Also update the reverse mapping
+		return tx.Bucket(boltVouchesToBucket).Put(boltKey(vouch.To, []byte(vouch.From)), data)
+	})
+}
+
+// Returns all vouches stored in the bucket under the user's prefix.
+func (s *BoltStorage) userVouches(bucket []byte, user string) ([]VouchEvent, error) {
+	vouches := make([]VouchEvent, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return boltScanUser(tx.Bucket(bucket), user, func(value []byte) error {
+			var v VouchEvent
+			if err := json.Unmarshal(value, &v); err != nil {
+				return err
+			}
+			vouches = append(vouches, v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vouches, nil
+}
+
+// Returns a copy of all stored outgoing vouches for a specific user.
+func (s *BoltStorage) UserVouchesFrom(user string) ([]VouchEvent, error) {
+	return s.userVouches(boltVouchesFromBucket, user)
+}
+
+// Returns a copy of all stored incoming vouches for a specific user.
+func (s *BoltStorage) UserVouchesTo(user string) ([]VouchEvent, error) {
+	return s.userVouches(boltVouchesToBucket, user)
+}
+
+// Stores the latest proof event for a user, replacing any prior record.
+func (s *BoltStorage) SetProof(proof ProofEvent) error {
+	data, err := json.Marshal(proof)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltProofsBucket).Put([]byte(proof.User), data)
+	})
+}
+
+// Returns the stored proof event for a user, if any.
+func (s *BoltStorage) ProofRecord(user string) (ProofEvent, error) {
+	proof := ProofEvent{User: user}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltProofsBucket).Get([]byte(user))
+		// Defaults to zero balance if no proof exists.
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &proof)
+	})
+	if err != nil {
+		return ProofEvent{User: user}, err
+	}
+	return proof, nil
+}
+
+// Stores the public key of a user, replacing any prior key.
+func (s *BoltStorage) SetKey(key KeyEvent) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltKeysBucket).Put([]byte(key.User), data)
+	})
+}
+
+// Returns the stored key of a user. The key is empty if none is registered.
+func (s *BoltStorage) KeyRecord(user string) (KeyEvent, error) {
+	key := KeyEvent{User: user}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltKeysBucket).Get([]byte(user))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &key)
+	})
+	if err != nil {
+		return KeyEvent{User: user}, err
+	}
+	return key, nil
+}
+
+// Records a penalty event unless a penalty with its ID is stored.
+func (s *BoltStorage) AddPenalty(penalty PenaltyEvent) error {
+	if penalty.ID == "" {
+		penalty.ID = rand.Text()
+	}
+	data, err := json.Marshal(penalty)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(boltPenaltyIDsBucket)
+		if index.Get([]byte(penalty.ID)) != nil {
+			return nil
+		}
+		bucket := tx.Bucket(boltPenaltiesBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		// Big-endian sequence keeps the user's penalties in insertion order
+		key := boltKey(penalty.User, binary.BigEndian.AppendUint64(nil, seq))
+		if err := index.Put([]byte(penalty.ID), key); err != nil {
+			return err
+		}
+		return bucket.Put(key, data)
+	})
+}
+
+// Returns all stored penalties for a user.
+func (s *BoltStorage) Penalties(user string) ([]PenaltyEvent, error) {
+	penalties := make([]PenaltyEvent, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return boltScanUser(tx.Bucket(boltPenaltiesBucket), user, func(value []byte) error {
+			var p PenaltyEvent
+			if err := json.Unmarshal(value, &p); err != nil {
+				return err
+			}
+			penalties = append(penalties, p)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return penalties, nil
+}
+
+// Records the receipt of a state root published to an external ledger.
+func (s *BoltStorage) AddAnchorReceipt(receipt AnchorReceipt) error {
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAnchorsBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(binary.BigEndian.AppendUint64(nil, seq), data)
+	})
+}
+
+// Returns all anchor receipts in the order they were recorded.
+func (s *BoltStorage) AnchorReceipts() ([]AnchorReceipt, error) {
+	receipts := []AnchorReceipt{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltAnchorsBucket).ForEach(func(_, data []byte) error {
+			var receipt AnchorReceipt
+			if err := json.Unmarshal(data, &receipt); err != nil {
+				return err
+			}
+			receipts = append(receipts, receipt)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
+
+// Returns the most recently recorded anchor receipt, if any.
+func (s *BoltStorage) LatestAnchorReceipt() (AnchorReceipt, bool, error) {
+	var receipt AnchorReceipt
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, data := tx.Bucket(boltAnchorsBucket).Cursor().Last()
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &receipt)
+	})
+	if err != nil {
+		return AnchorReceipt{}, false, err
+	}
+	return receipt, found, nil
+}
+
+// Stores a webhook delivery, replacing any prior record with the same ID.
+func (s *BoltStorage) SetWebhookDelivery(delivery WebhookDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		ids := tx.Bucket(boltWebhookDeliveryIDsBucket)
+		key := ids.Get([]byte(delivery.ID))
+		if key == nil {
+			deliveries := tx.Bucket(boltWebhookDeliveriesBucket)
+			seq, err := deliveries.NextSequence()
+			if err != nil {
+				return err
+			}
+			key = binary.BigEndian.AppendUint64(nil, seq)
+			if err := ids.Put([]byte(delivery.ID), key); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(boltWebhookDeliveriesBucket).Put(key, data)
+	})
+}
+
+// Returns all webhook deliveries in the order they were first recorded.
+func (s *BoltStorage) WebhookDeliveries() ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltWebhookDeliveriesBucket).ForEach(func(_, data []byte) error {
+			var delivery WebhookDelivery
+			if err := json.Unmarshal(data, &delivery); err != nil {
+				return err
+			}
+			deliveries = append(deliveries, delivery)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Closes the database file.
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
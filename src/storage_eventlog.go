@@ -0,0 +1,482 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const eventLogFileName = "events.log"
+const eventLogSnapshotFileName = "snapshot"
+
+// Anchor receipts are not events, so they are kept in their own log with
+// the same record framing.
+const eventLogAnchorsFileName = "anchors.log"
+
+// Webhook deliveries are kept the same way. Every attempt appends the
+// delivery again, so the log holds the full delivery history.
+const eventLogWebhooksFileName = "webhooks.log"
+
+// Number of appended events after which a new snapshot is written.
+const defaultSnapshotInterval = 1000
+
+// Each record is framed as a 4-byte big-endian payload length, a 4-byte
+// CRC-32 (IEEE) of the payload and the payload itself.
+const eventLogHeaderSize = 8
+
+var errEventLogChecksum = errors.New("event log record checksum mismatch")
+
+var errEventLogClosed = errors.New("event log storage is closed")
+
+// Represents the derived state persisted in a snapshot file.
+type eventLogSnapshot struct {
+	// Byte offset in the log up to which events are included in the snapshot.
+	Offset int64   `json:"offset"`
+	Events []Event `json:"events"`
+}
+
+// Implements Storage as an append-only, checksummed log of events.
+// Every write is appended to the log before it is applied to an in-memory
+// index, and the index is rebuilt by replaying the log on startup.
+// Snapshots of the derived state are written periodically so that replay
+// only needs to cover the log tail.
+type EventLogStorage struct {
+	mu     sync.Mutex
+	dir    string
+	file   *os.File
+	offset int64
+	memory *MemoryStorage
+	// events appended since the last snapshot
+	pending          int
+	snapshotInterval int
+}
+
+// Opens an event log storage in the given directory, creating it if needed,
+// and restores state from the latest snapshot and the log.
+func NewEventLogStorage(dir string) (*EventLogStorage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &EventLogStorage{
+		dir:              dir,
+		memory:           NewMemoryStorage(),
+		snapshotInterval: defaultSnapshotInterval,
+	}
+
+	start, err := s.loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, eventLogFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+
+	if err := s.replay(start); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := s.loadAnchors(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := s.loadWebhookDeliveries(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Appends a framed record to the writer.
+func writeEventLogRecord(w io.Writer, payload []byte) error {
+	record := make([]byte, eventLogHeaderSize, eventLogHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+	_, err := w.Write(record)
+	return err
+}
+
+// Reads a framed record from the reader and verifies its checksum.
+// Returns io.EOF if there are no more records and io.ErrUnexpectedEOF if the
+// record is truncated.
+func readEventLogRecord(r io.Reader) ([]byte, error) {
+	header := make([]byte, eventLogHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errEventLogChecksum
+	}
+	return payload, nil
+}
+
+// Restores the in-memory index from the snapshot file, if any.
+// Returns the log offset at which replay should start.
+func (s *EventLogStorage) loadSnapshot() (int64, error) {
+	file, err := os.Open(filepath.Join(s.dir, eventLogSnapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	payload, err := readEventLogRecord(file)
+	if err != nil {
+		return 0, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	var snapshot eventLogSnapshot
+	if err := json.Unmarshal(payload, &snapshot); err != nil {
+		return 0, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	for i, event := range snapshot.Events {
+		// Penalties written before penalties had IDs get one derived from
+		// their position, so that it stays the same on every startup
+		legacyPenaltyID(event, fmt.Sprintf("snapshot-%d-%d", snapshot.Offset, i))
+		if err := event.Apply(s.memory); err != nil {
+			return 0, fmt.Errorf("invalid snapshot: %w", err)
+		}
+	}
+	return snapshot.Offset, nil
+}
+
+// Applies all log records starting at the given offset to the in-memory index.
+// A truncated record at the end of the log, left by an interrupted write, is
+// discarded so that new records can be appended after the last valid one.
+func (s *EventLogStorage) replay(start int64) error {
+	if _, err := s.file.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	offset := start
+	for {
+		payload, err := readEventLogRecord(s.file)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			log.Printf("Discarding truncated event log record at offset %d", offset)
+			if err := s.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("event log record at offset %d: %w", offset, err)
+		}
+
+		var event Event
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return fmt.Errorf("event log record at offset %d: %w", offset, err)
+		}
+		legacyPenaltyID(event, fmt.Sprintf("log-%d", offset))
+		if err := event.Apply(s.memory); err != nil {
+			return fmt.Errorf("event log record at offset %d: %w", offset, err)
+		}
+		offset += eventLogHeaderSize + int64(len(payload))
+		s.pending++
+	}
+
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	s.offset = offset
+	return nil
+}
+
+// Gives a penalty event without an ID the fallback ID.
+func legacyPenaltyID(event Event, id string) {
+	if event.Kind == EventKindPenalty && event.Penalty != nil && event.Penalty.ID == "" {
+		event.Penalty.ID = id
+	}
+}
+
+// Writes the current derived state to the snapshot file atomically.
+// Must be called with the mutex held.
+func (s *EventLogStorage) writeSnapshot() error {
+	events, err := StorageEvents(s.memory)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(eventLogSnapshot{Offset: s.offset, Events: events})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, eventLogSnapshotFileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeEventLogRecord(tmp, payload); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, eventLogSnapshotFileName)); err != nil {
+		return err
+	}
+	s.pending = 0
+	return nil
+}
+
+// Durably appends the event to the log and applies it to the in-memory index.
+// Invalid events are rejected before they are written, since replaying them
+// would fail on every later start.
+func (s *EventLogStorage) append(event Event) error {
+	if err := event.Validate(); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errEventLogClosed
+	}
+
+	if event.Kind == EventKindPenalty && s.memory.hasPenalty(event.Penalty.ID) {
+		return nil
+	}
+	if err := writeEventLogRecord(s.file, payload); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.offset += eventLogHeaderSize + int64(len(payload))
+
+	if err := event.Apply(s.memory); err != nil {
+		return err
+	}
+
+	s.pending++
+	if s.snapshotInterval > 0 && s.pending >= s.snapshotInterval {
+		// The event is already durable in the log, so a failed snapshot only
+		// means a longer replay on the next startup.
+		if err := s.writeSnapshot(); err != nil {
+			log.Printf("Error writing event log snapshot: %v", err)
+		}
+	}
+	return nil
+}
+
+// Returns all users who have vouches, proofs, penalties or keys recorded.
+func (s *EventLogStorage) Users() ([]string, error) {
+	return s.memory.Users()
+}
+
+// Records an incoming vouch event.
+func (s *EventLogStorage) AddVouch(vouch VouchEvent) error {
+	return s.append(EventFromVouch(vouch))
+}
+
+// Returns a copy of all stored outgoing vouches for a specific user.
+func (s *EventLogStorage) UserVouchesFrom(user string) ([]VouchEvent, error) {
+	return s.memory.UserVouchesFrom(user)
+}
+
+// Returns a copy of all stored incoming vouches for a specific user.
+func (s *EventLogStorage) UserVouchesTo(user string) ([]VouchEvent, error) {
+	return s.memory.UserVouchesTo(user)
+}
+
+// Stores the latest proof event for a user, replacing any prior record.
+func (s *EventLogStorage) SetProof(proof ProofEvent) error {
+	return s.append(EventFromProof(proof))
+}
+
+// Returns the stored proof event for a user, if any.
+func (s *EventLogStorage) ProofRecord(user string) (ProofEvent, error) {
+	return s.memory.ProofRecord(user)
+}
+
+// Stores the public key of a user, replacing any prior key.
+func (s *EventLogStorage) SetKey(key KeyEvent) error {
+	return s.append(EventFromKey(key))
+}
+
+// Returns the stored key of a user. The key is empty if none is registered.
+func (s *EventLogStorage) KeyRecord(user string) (KeyEvent, error) {
+	return s.memory.KeyRecord(user)
+}
+
+// Records a penalty event unless a penalty with its ID is stored.
+func (s *EventLogStorage) AddPenalty(penalty PenaltyEvent) error {
+	if penalty.ID == "" {
+		penalty.ID = rand.Text()
+	}
+	return s.append(EventFromPenalty(penalty))
+}
+
+// Returns a copy of all stored penalties for a user.
+func (s *EventLogStorage) Penalties(user string) ([]PenaltyEvent, error) {
+	return s.memory.Penalties(user)
+}
+
+// Restores records that are not events from their own log, if it exists.
+// `kind` names the records in errors.
+func (s *EventLogStorage) loadRecords(name string, kind string, apply func(payload []byte) error) error {
+	file, err := os.Open(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	offset := int64(0)
+	for {
+		payload, err := readEventLogRecord(file)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			// Discard the interrupted write so that new records follow the last valid one
+			log.Printf("Discarding truncated %s at offset %d", kind, offset)
+			return os.Truncate(file.Name(), offset)
+		}
+		if err != nil {
+			return fmt.Errorf("%s at offset %d: %w", kind, offset, err)
+		}
+		if err := apply(payload); err != nil {
+			return fmt.Errorf("%s at offset %d: %w", kind, offset, err)
+		}
+		offset += eventLogHeaderSize + int64(len(payload))
+	}
+}
+
+// Durably appends a record to the log with the given name.
+// Must be called with the mutex held.
+func (s *EventLogStorage) appendRecord(name string, payload []byte) error {
+	if s.file == nil {
+		return errEventLogClosed
+	}
+	file, err := os.OpenFile(filepath.Join(s.dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if err := writeEventLogRecord(file, payload); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Restores the anchor receipts from their log, if any.
+func (s *EventLogStorage) loadAnchors() error {
+	return s.loadRecords(eventLogAnchorsFileName, "anchor receipt", func(payload []byte) error {
+		var receipt AnchorReceipt
+		if err := json.Unmarshal(payload, &receipt); err != nil {
+			return err
+		}
+		return s.memory.AddAnchorReceipt(receipt)
+	})
+}
+
+// Durably appends the receipt to the anchor receipt log.
+func (s *EventLogStorage) AddAnchorReceipt(receipt AnchorReceipt) error {
+	payload, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.appendRecord(eventLogAnchorsFileName, payload); err != nil {
+		return err
+	}
+	return s.memory.AddAnchorReceipt(receipt)
+}
+
+// Returns all anchor receipts in the order they were recorded.
+func (s *EventLogStorage) AnchorReceipts() ([]AnchorReceipt, error) {
+	return s.memory.AnchorReceipts()
+}
+
+// Returns the most recently recorded anchor receipt, if any.
+func (s *EventLogStorage) LatestAnchorReceipt() (AnchorReceipt, bool, error) {
+	return s.memory.LatestAnchorReceipt()
+}
+
+// Restores the webhook deliveries from their log, if any. Later records
+// of a delivery replace earlier ones.
+func (s *EventLogStorage) loadWebhookDeliveries() error {
+	return s.loadRecords(eventLogWebhooksFileName, "webhook delivery", func(payload []byte) error {
+		var delivery WebhookDelivery
+		if err := json.Unmarshal(payload, &delivery); err != nil {
+			return err
+		}
+		return s.memory.SetWebhookDelivery(delivery)
+	})
+}
+
+// Durably appends the delivery to the webhook delivery log.
+func (s *EventLogStorage) SetWebhookDelivery(delivery WebhookDelivery) error {
+	payload, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.appendRecord(eventLogWebhooksFileName, payload); err != nil {
+		return err
+	}
+	return s.memory.SetWebhookDelivery(delivery)
+}
+
+// Returns all webhook deliveries in the order they were first recorded.
+func (s *EventLogStorage) WebhookDeliveries() ([]WebhookDelivery, error) {
+	return s.memory.WebhookDeliveries()
+}
+
+// Writes a final snapshot and closes the log file.
+func (s *EventLogStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	if s.pending > 0 {
+		if err := s.writeSnapshot(); err != nil {
+			log.Printf("Error writing event log snapshot: %v", err)
+		}
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
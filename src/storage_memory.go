@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/rand"
 	"sync"
 )
 
@@ -13,19 +14,29 @@ type MemoryStorage struct {
 	vouchesTo map[string]map[string]VouchEvent
 	proofs    map[string]ProofEvent
 	penalties map[string][]PenaltyEvent
+	// IDs of the stored penalties
+	penaltyIDs map[string]struct{}
+	keys       map[string]KeyEvent
+	anchors    []AnchorReceipt
+	// maps delivery ID to its position in webhookDeliveries
+	webhookIndex      map[string]int
+	webhookDeliveries []WebhookDelivery
 }
 
 // Initializes an empty in-memory storage.
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		vouchesFrom: make(map[string]map[string]VouchEvent),
-		vouchesTo:   make(map[string]map[string]VouchEvent),
-		proofs:      make(map[string]ProofEvent),
-		penalties:   make(map[string][]PenaltyEvent),
+		vouchesFrom:  make(map[string]map[string]VouchEvent),
+		vouchesTo:    make(map[string]map[string]VouchEvent),
+		proofs:       make(map[string]ProofEvent),
+		penalties:    make(map[string][]PenaltyEvent),
+		penaltyIDs:   make(map[string]struct{}),
+		keys:         make(map[string]KeyEvent),
+		webhookIndex: make(map[string]int),
 	}
 }
 
-// Returns all users who have vouches, proofs, or penalties recorded.
+// Returns all users who have vouches, proofs, penalties or keys recorded.
 func (s *MemoryStorage) Users() ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -42,6 +53,9 @@ func (s *MemoryStorage) Users() ([]string, error) {
 	for user := range s.penalties {
 		userSet[user] = struct{}{}
 	}
+	for user := range s.keys {
+		userSet[user] = struct{}{}
+	}
 	users := make([]string, 0, len(userSet))
 	for user := range userSet {
 		users = append(users, user)
@@ -107,14 +121,50 @@ func (s *MemoryStorage) ProofRecord(user string) (ProofEvent, error) {
 	return proof, nil
 }
 
-// Records a penalty event.
+// Stores the public key of a user, replacing any prior key.
+func (s *MemoryStorage) SetKey(key KeyEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key.PublicKey = append([]byte(nil), key.PublicKey...)
+	s.keys[key.User] = key
+	return nil
+}
+
+// Returns the stored key of a user. The key is empty if none is registered.
+func (s *MemoryStorage) KeyRecord(user string) (KeyEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[user]
+	if !ok {
+		return KeyEvent{User: user}, nil
+	}
+	key.PublicKey = append([]byte(nil), key.PublicKey...)
+	return key, nil
+}
+
+// Records a penalty event unless a penalty with its ID is stored.
 func (s *MemoryStorage) AddPenalty(penalty PenaltyEvent) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if penalty.ID == "" {
+		penalty.ID = rand.Text()
+	}
+	if _, ok := s.penaltyIDs[penalty.ID]; ok {
+		return nil
+	}
+	s.penaltyIDs[penalty.ID] = struct{}{}
 	s.penalties[penalty.User] = append(s.penalties[penalty.User], penalty)
 	return nil
 }
 
+// Reports whether a penalty with the ID is stored.
+func (s *MemoryStorage) hasPenalty(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.penaltyIDs[id]
+	return ok
+}
+
 // Returns a copy of all stored penalties for a user.
 func (s *MemoryStorage) Penalties(user string) ([]PenaltyEvent, error) {
 	s.mu.RLock()
@@ -124,6 +174,55 @@ func (s *MemoryStorage) Penalties(user string) ([]PenaltyEvent, error) {
 	return penaltiesCopy, nil
 }
 
+// Records the receipt of a state root published to an external ledger.
+func (s *MemoryStorage) AddAnchorReceipt(receipt AnchorReceipt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.anchors = append(s.anchors, receipt)
+	return nil
+}
+
+// Returns a copy of all anchor receipts in the order they were recorded.
+func (s *MemoryStorage) AnchorReceipts() ([]AnchorReceipt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	receipts := make([]AnchorReceipt, len(s.anchors))
+	copy(receipts, s.anchors)
+	return receipts, nil
+}
+
+// Returns the most recently recorded anchor receipt, if any.
+func (s *MemoryStorage) LatestAnchorReceipt() (AnchorReceipt, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.anchors) == 0 {
+		return AnchorReceipt{}, false, nil
+	}
+	return s.anchors[len(s.anchors)-1], true, nil
+}
+
+// Stores a webhook delivery, replacing any prior record with the same ID.
+func (s *MemoryStorage) SetWebhookDelivery(delivery WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.webhookIndex[delivery.ID]; ok {
+		s.webhookDeliveries[i] = delivery
+		return nil
+	}
+	s.webhookIndex[delivery.ID] = len(s.webhookDeliveries)
+	s.webhookDeliveries = append(s.webhookDeliveries, delivery)
+	return nil
+}
+
+// Returns a copy of all webhook deliveries in the order they were first recorded.
+func (s *MemoryStorage) WebhookDeliveries() ([]WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	deliveries := make([]WebhookDelivery, len(s.webhookDeliveries))
+	copy(deliveries, s.webhookDeliveries)
+	return deliveries, nil
+}
+
 // Close is a no-op for in-memory storage.
 func (s *MemoryStorage) Close() error {
 	return nil
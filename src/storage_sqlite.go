@@ -1,7 +1,10 @@
 package main
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -26,6 +29,12 @@ func NewSQLiteStorage(dataSourceName string) (*SQLiteStorage, error) {
 		return nil, err
 	}
 
+	// Bring older databases up to the current schema
+	if err := migrateTables(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return &SQLiteStorage{db: db}, nil
 }
 
@@ -89,7 +98,132 @@ func createTables(db *sql.DB) error {
 	return nil
 }
 
-// Returns all users who have vouches, proofs, or penalties recorded.
+// Schema migrations applied in order on top of the tables created by createTables.
+// After migration i runs, PRAGMA user_version is set to i+1.
+var sqliteMigrations = []string{
+	// Version 1: sub-second timestamp precision. Timestamps were stored as Unix
+	// seconds; the nanosecond remainder is kept in a separate column so existing
+	// rows stay valid with a zero remainder.
+	`
+		ALTER TABLE vouches ADD COLUMN timestamp_nanos INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE proofs ADD COLUMN timestamp_nanos INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE penalties ADD COLUMN timestamp_nanos INTEGER NOT NULL DEFAULT 0;
+	`,
+	// Version 2: vouch weights. Existing vouches get full weight.
+	`
+		ALTER TABLE vouches ADD COLUMN weight INTEGER NOT NULL DEFAULT 100;
+	`,
+	// Version 3: vouch expiry. NULL means the vouch never expires.
+	`
+		ALTER TABLE vouches ADD COLUMN expires_at INTEGER;
+		ALTER TABLE vouches ADD COLUMN expires_at_nanos INTEGER NOT NULL DEFAULT 0;
+	`,
+	// Version 4: vouch stakes and the origin of slashing penalties.
+	`
+		ALTER TABLE vouches ADD COLUMN stake INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE penalties ADD COLUMN origin TEXT NOT NULL DEFAULT '';
+	`,
+	// Version 5: public keys of users.
+	`
+		CREATE TABLE user_keys (
+			user TEXT PRIMARY KEY,
+			public_key BLOB NOT NULL,
+			timestamp INTEGER NOT NULL,
+			timestamp_nanos INTEGER NOT NULL DEFAULT 0
+		);
+	`,
+	// Version 6: receipts of state roots published to external ledgers.
+	`
+		CREATE TABLE anchor_receipts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			anchor TEXT NOT NULL,
+			root TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			committed_at INTEGER NOT NULL,
+			anchored_at INTEGER NOT NULL,
+			anchored_at_nanos INTEGER NOT NULL DEFAULT 0,
+			location TEXT NOT NULL
+		);
+	`,
+	// Version 7: delivery log of webhook notifications.
+	`
+		CREATE TABLE webhook_deliveries (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			id TEXT NOT NULL UNIQUE,
+			url TEXT NOT NULL,
+			type TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL,
+			response_code INTEGER NOT NULL,
+			error TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			created_at_nanos INTEGER NOT NULL,
+			last_attempt_at INTEGER NOT NULL,
+			last_attempt_at_nanos INTEGER NOT NULL,
+			next_attempt_at INTEGER NOT NULL,
+			next_attempt_at_nanos INTEGER NOT NULL
+		);
+	`,
+	// Version 8: penalty IDs. Existing penalties get an ID derived from
+	// their row ID.
+	`
+		ALTER TABLE penalties ADD COLUMN penalty_id TEXT NOT NULL DEFAULT '';
+		UPDATE penalties SET penalty_id = 'sqlite-' || id;
+		CREATE UNIQUE INDEX idx_penalties_penalty_id ON penalties(penalty_id);
+	`,
+}
+
+// Applies all pending schema migrations.
+func migrateTables(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, len(sqliteMigrations))
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		// PRAGMA does not accept bound parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the schema version recorded in the database.
+func (s *SQLiteStorage) SchemaVersion() (int, error) {
+	var version int
+	err := s.db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// Splits a timestamp into Unix seconds and the nanosecond remainder for storage.
+func splitTimestamp(t time.Time) (int64, int64) {
+	return t.Unix(), int64(t.Nanosecond())
+}
+
+// Restores a timestamp stored as Unix seconds and nanosecond remainder.
+func joinTimestamp(seconds int64, nanos int64) time.Time {
+	return time.Unix(seconds, nanos).UTC()
+}
+
+// Returns all users who have vouches, proofs, penalties or keys recorded.
 func (s *SQLiteStorage) Users() ([]string, error) {
 	rows, err := s.db.Query(`
 		SELECT DISTINCT user FROM (
@@ -100,6 +234,8 @@ func (s *SQLiteStorage) Users() ([]string, error) {
 			SELECT user FROM proofs
 			UNION
 			SELECT user FROM penalties
+			UNION
+			SELECT user FROM user_keys
 		)
 	`)
 	if err != nil {
@@ -124,18 +260,30 @@ func (s *SQLiteStorage) Users() ([]string, error) {
 
 // Records an incoming vouch event.
 func (s *SQLiteStorage) AddVouch(vouch VouchEvent) error {
+	seconds, nanos := splitTimestamp(vouch.Timestamp)
+	var expiresAt sql.NullInt64
+	var expiresAtNanos int64
+	if !vouch.ExpiresAt.IsZero() {
+		expiresAt.Valid = true
+		expiresAt.Int64, expiresAtNanos = splitTimestamp(vouch.ExpiresAt)
+	}
 	_, err := s.db.Exec(
-		"REPLACE INTO vouches (from_user, to_user, timestamp) VALUES (?, ?, ?)",
+		"REPLACE INTO vouches (from_user, to_user, timestamp, timestamp_nanos, weight, expires_at, expires_at_nanos, stake) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
 		vouch.From,
 		vouch.To,
-		vouch.Timestamp.Unix(),
+		seconds,
+		nanos,
+		vouch.Weight,
+		expiresAt,
+		expiresAtNanos,
+		vouch.Stake,
 	)
 	return err
 }
 
 // Returns a copy of all stored outgoing vouches for a specific user.
 func (s *SQLiteStorage) UserVouchesFrom(user string) ([]VouchEvent, error) {
-	rows, err := s.db.Query("SELECT from_user, to_user, timestamp FROM vouches WHERE from_user = ?", user)
+	rows, err := s.db.Query("SELECT from_user, to_user, timestamp, timestamp_nanos, weight, expires_at, expires_at_nanos, stake FROM vouches WHERE from_user = ?", user)
 	if err != nil {
 		return nil, err
 	}
@@ -144,11 +292,15 @@ func (s *SQLiteStorage) UserVouchesFrom(user string) ([]VouchEvent, error) {
 	var vouches []VouchEvent
 	for rows.Next() {
 		var v VouchEvent
-		var timestamp int64
-		if err := rows.Scan(&v.From, &v.To, &timestamp); err != nil {
+		var timestamp, nanos, expiresAtNanos int64
+		var expiresAt sql.NullInt64
+		if err := rows.Scan(&v.From, &v.To, &timestamp, &nanos, &v.Weight, &expiresAt, &expiresAtNanos, &v.Stake); err != nil {
 			return nil, err
 		}
-		v.Timestamp = time.Unix(timestamp, 0).UTC()
+		v.Timestamp = joinTimestamp(timestamp, nanos)
+		if expiresAt.Valid {
+			v.ExpiresAt = joinTimestamp(expiresAt.Int64, expiresAtNanos)
+		}
 		vouches = append(vouches, v)
 	}
 	if err := rows.Err(); err != nil {
@@ -163,7 +315,7 @@ func (s *SQLiteStorage) UserVouchesFrom(user string) ([]VouchEvent, error) {
 
 // Returns a copy of all stored incoming vouches for a specific user.
 func (s *SQLiteStorage) UserVouchesTo(user string) ([]VouchEvent, error) {
-	rows, err := s.db.Query("SELECT from_user, to_user, timestamp FROM vouches WHERE to_user = ?", user)
+	rows, err := s.db.Query("SELECT from_user, to_user, timestamp, timestamp_nanos, weight, expires_at, expires_at_nanos, stake FROM vouches WHERE to_user = ?", user)
 	if err != nil {
 		return nil, err
 	}
@@ -172,11 +324,15 @@ func (s *SQLiteStorage) UserVouchesTo(user string) ([]VouchEvent, error) {
 	var vouches []VouchEvent
 	for rows.Next() {
 		var v VouchEvent
-		var timestamp int64
-		if err := rows.Scan(&v.From, &v.To, &timestamp); err != nil {
+		var timestamp, nanos, expiresAtNanos int64
+		var expiresAt sql.NullInt64
+		if err := rows.Scan(&v.From, &v.To, &timestamp, &nanos, &v.Weight, &expiresAt, &expiresAtNanos, &v.Stake); err != nil {
 			return nil, err
 		}
-		v.Timestamp = time.Unix(timestamp, 0).UTC()
+		v.Timestamp = joinTimestamp(timestamp, nanos)
+		if expiresAt.Valid {
+			v.ExpiresAt = joinTimestamp(expiresAt.Int64, expiresAtNanos)
+		}
 		vouches = append(vouches, v)
 	}
 	if err := rows.Err(); err != nil {
@@ -191,21 +347,26 @@ func (s *SQLiteStorage) UserVouchesTo(user string) ([]VouchEvent, error) {
 
 // Stores the latest proof event for a user, replacing any prior record.
 func (s *SQLiteStorage) SetProof(proof ProofEvent) error {
+	seconds, nanos := splitTimestamp(proof.Timestamp)
 	_, err := s.db.Exec(`
-		INSERT INTO proofs (user, balance, timestamp) VALUES (?, ?, ?)
-		ON CONFLICT(user) DO UPDATE SET balance = excluded.balance, timestamp = excluded.timestamp
-	`, proof.User, proof.Balance, proof.Timestamp.Unix())
+		INSERT INTO proofs (user, balance, timestamp, timestamp_nanos) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user) DO UPDATE SET
+			balance = excluded.balance,
+			timestamp = excluded.timestamp,
+			timestamp_nanos = excluded.timestamp_nanos
+	`, proof.User, proof.Balance, seconds, nanos)
 	return err
 }
 
 // Returns the stored proof event for a user, if any.
 func (s *SQLiteStorage) ProofRecord(user string) (ProofEvent, error) {
 	var proof ProofEvent
-	var timestamp int64
-	err := s.db.QueryRow("SELECT user, balance, timestamp FROM proofs WHERE user = ?", user).Scan(
+	var timestamp, nanos int64
+	err := s.db.QueryRow("SELECT user, balance, timestamp, timestamp_nanos FROM proofs WHERE user = ?", user).Scan(
 		&proof.User,
 		&proof.Balance,
 		&timestamp,
+		&nanos,
 	)
 	if err == sql.ErrNoRows {
 		return ProofEvent{User: user}, nil
@@ -213,24 +374,63 @@ func (s *SQLiteStorage) ProofRecord(user string) (ProofEvent, error) {
 	if err != nil {
 		return ProofEvent{User: user}, err
 	}
-	proof.Timestamp = time.Unix(timestamp, 0).UTC()
+	proof.Timestamp = joinTimestamp(timestamp, nanos)
 	return proof, nil
 }
 
-// Records a penalty event.
+// Stores the public key of a user, replacing any prior key.
+func (s *SQLiteStorage) SetKey(key KeyEvent) error {
+	seconds, nanos := splitTimestamp(key.Timestamp)
+	_, err := s.db.Exec(
+		"REPLACE INTO user_keys (user, public_key, timestamp, timestamp_nanos) VALUES (?, ?, ?, ?)",
+		key.User,
+		key.PublicKey,
+		seconds,
+		nanos,
+	)
+	return err
+}
+
+// Returns the stored key of a user. The key is empty if none is registered.
+func (s *SQLiteStorage) KeyRecord(user string) (KeyEvent, error) {
+	key := KeyEvent{User: user}
+	var timestamp, nanos int64
+	err := s.db.QueryRow("SELECT public_key, timestamp, timestamp_nanos FROM user_keys WHERE user = ?", user).Scan(
+		&key.PublicKey,
+		&timestamp,
+		&nanos,
+	)
+	if err == sql.ErrNoRows {
+		return KeyEvent{User: user}, nil
+	}
+	if err != nil {
+		return KeyEvent{User: user}, err
+	}
+	key.Timestamp = joinTimestamp(timestamp, nanos)
+	return key, nil
+}
+
+// Records a penalty event unless a penalty with its ID is stored.
 func (s *SQLiteStorage) AddPenalty(penalty PenaltyEvent) error {
+	if penalty.ID == "" {
+		penalty.ID = rand.Text()
+	}
+	seconds, nanos := splitTimestamp(penalty.Timestamp)
 	_, err := s.db.Exec(
-		"INSERT INTO penalties (user, amount, timestamp) VALUES (?, ?, ?)",
+		"INSERT INTO penalties (penalty_id, user, amount, timestamp, timestamp_nanos, origin) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT(penalty_id) DO NOTHING",
+		penalty.ID,
 		penalty.User,
 		penalty.Amount,
-		penalty.Timestamp.Unix(),
+		seconds,
+		nanos,
+		penalty.Origin,
 	)
 	return err
 }
 
 // Returns all stored penalties for a user.
 func (s *SQLiteStorage) Penalties(user string) ([]PenaltyEvent, error) {
-	rows, err := s.db.Query("SELECT user, amount, timestamp FROM penalties WHERE user = ? ORDER BY id", user)
+	rows, err := s.db.Query("SELECT penalty_id, user, amount, timestamp, timestamp_nanos, origin FROM penalties WHERE user = ? ORDER BY id", user)
 	if err != nil {
 		return nil, err
 	}
@@ -239,11 +439,11 @@ func (s *SQLiteStorage) Penalties(user string) ([]PenaltyEvent, error) {
 	var penalties []PenaltyEvent
 	for rows.Next() {
 		var p PenaltyEvent
-		var timestamp int64
-		if err := rows.Scan(&p.User, &p.Amount, &timestamp); err != nil {
+		var timestamp, nanos int64
+		if err := rows.Scan(&p.ID, &p.User, &p.Amount, &timestamp, &nanos, &p.Origin); err != nil {
 			return nil, err
 		}
-		p.Timestamp = time.Unix(timestamp, 0).UTC()
+		p.Timestamp = joinTimestamp(timestamp, nanos)
 		penalties = append(penalties, p)
 	}
 	if err := rows.Err(); err != nil {
@@ -256,6 +456,135 @@ func (s *SQLiteStorage) Penalties(user string) ([]PenaltyEvent, error) {
 	return penalties, nil
 }
 
+// Records the receipt of a state root published to an external ledger.
+func (s *SQLiteStorage) AddAnchorReceipt(receipt AnchorReceipt) error {
+	seconds, nanos := splitTimestamp(receipt.AnchoredAt)
+	_, err := s.db.Exec(
+		"INSERT INTO anchor_receipts (anchor, root, size, committed_at, anchored_at, anchored_at_nanos, location) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		receipt.Anchor,
+		receipt.Root,
+		receipt.Size,
+		receipt.CommittedAt,
+		seconds,
+		nanos,
+		receipt.Location,
+	)
+	return err
+}
+
+// Returns all anchor receipts in the order they were recorded.
+func (s *SQLiteStorage) AnchorReceipts() ([]AnchorReceipt, error) {
+	rows, err := s.db.Query("SELECT anchor, root, size, committed_at, anchored_at, anchored_at_nanos, location FROM anchor_receipts ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	receipts := []AnchorReceipt{}
+	for rows.Next() {
+		var receipt AnchorReceipt
+		var anchoredAt, nanos int64
+		if err := rows.Scan(&receipt.Anchor, &receipt.Root, &receipt.Size, &receipt.CommittedAt, &anchoredAt, &nanos, &receipt.Location); err != nil {
+			return nil, err
+		}
+		receipt.AnchoredAt = joinTimestamp(anchoredAt, nanos)
+		receipts = append(receipts, receipt)
+	}
+	return receipts, rows.Err()
+}
+
+// Returns the most recently recorded anchor receipt, if any.
+func (s *SQLiteStorage) LatestAnchorReceipt() (AnchorReceipt, bool, error) {
+	var receipt AnchorReceipt
+	var anchoredAt, nanos int64
+	err := s.db.QueryRow("SELECT anchor, root, size, committed_at, anchored_at, anchored_at_nanos, location FROM anchor_receipts ORDER BY id DESC LIMIT 1").Scan(
+		&receipt.Anchor, &receipt.Root, &receipt.Size, &receipt.CommittedAt, &anchoredAt, &nanos, &receipt.Location)
+	if err == sql.ErrNoRows {
+		return AnchorReceipt{}, false, nil
+	}
+	if err != nil {
+		return AnchorReceipt{}, false, err
+	}
+	receipt.AnchoredAt = joinTimestamp(anchoredAt, nanos)
+	return receipt, true, nil
+}
+
+// Stores a webhook delivery, replacing any prior record with the same ID.
+// Replacing keeps the sequence, so deliveries stay in the order they were
+// first recorded.
+func (s *SQLiteStorage) SetWebhookDelivery(delivery WebhookDelivery) error {
+	created, createdNanos := splitTimestamp(delivery.CreatedAt)
+	lastAttempt, lastAttemptNanos := splitTimestamp(delivery.LastAttemptAt)
+	nextAttempt, nextAttemptNanos := splitTimestamp(delivery.NextAttemptAt)
+	_, err := s.db.Exec(`
+		INSERT INTO webhook_deliveries (id, url, type, payload, status, attempts, response_code, error,
+			created_at, created_at_nanos, last_attempt_at, last_attempt_at_nanos, next_attempt_at, next_attempt_at_nanos)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			url = excluded.url,
+			type = excluded.type,
+			payload = excluded.payload,
+			status = excluded.status,
+			attempts = excluded.attempts,
+			response_code = excluded.response_code,
+			error = excluded.error,
+			created_at = excluded.created_at,
+			created_at_nanos = excluded.created_at_nanos,
+			last_attempt_at = excluded.last_attempt_at,
+			last_attempt_at_nanos = excluded.last_attempt_at_nanos,
+			next_attempt_at = excluded.next_attempt_at,
+			next_attempt_at_nanos = excluded.next_attempt_at_nanos
+	`,
+		delivery.ID,
+		delivery.URL,
+		delivery.Type,
+		string(delivery.Payload),
+		delivery.Status,
+		delivery.Attempts,
+		delivery.ResponseCode,
+		delivery.Error,
+		created,
+		createdNanos,
+		lastAttempt,
+		lastAttemptNanos,
+		nextAttempt,
+		nextAttemptNanos,
+	)
+	return err
+}
+
+// Returns all webhook deliveries in the order they were first recorded.
+func (s *SQLiteStorage) WebhookDeliveries() ([]WebhookDelivery, error) {
+	rows, err := s.db.Query(`
+		SELECT id, url, type, payload, status, attempts, response_code, error,
+			created_at, created_at_nanos, last_attempt_at, last_attempt_at_nanos, next_attempt_at, next_attempt_at_nanos
+		FROM webhook_deliveries ORDER BY seq
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var payload string
+		var created, createdNanos, lastAttempt, lastAttemptNanos, nextAttempt, nextAttemptNanos int64
+		if err := rows.Scan(
+			&delivery.ID, &delivery.URL, &delivery.Type, &payload, &delivery.Status, &delivery.Attempts, &delivery.ResponseCode, &delivery.Error,
+			&created, &createdNanos, &lastAttempt, &lastAttemptNanos, &nextAttempt, &nextAttemptNanos,
+		); err != nil {
+			return nil, err
+		}
+		delivery.Payload = json.RawMessage(payload)
+		delivery.CreatedAt = joinTimestamp(created, createdNanos)
+		delivery.LastAttemptAt = joinTimestamp(lastAttempt, lastAttemptNanos)
+		delivery.NextAttemptAt = joinTimestamp(nextAttempt, nextAttemptNanos)
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
 // Closes the database connection.
 func (s *SQLiteStorage) Close() error {
 	return s.db.Close()
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"database/sql"
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -9,8 +13,8 @@ import (
 // Creates a new Storage instance
 type storageFactory func(t *testing.T) Storage
 
-// Runs the given test function against both in-memory and SQLite
-// storage implementations to ensure identical behavior.
+// Runs the given test function against the in-memory, SQLite, bbolt and
+// event log storage implementations to ensure identical behavior.
 func testStorageImplementations(t *testing.T, name string, testFn func(t *testing.T, storage Storage)) {
 	factories := map[string]storageFactory{
 		"Memory": func(t *testing.T) Storage {
@@ -35,6 +39,26 @@ func testStorageImplementations(t *testing.T, name string, testFn func(t *testin
 			})
 			return storage
 		},
+		"Bolt": func(t *testing.T) Storage {
+			storage, err := NewBoltStorage(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("Failed to create bbolt storage: %v", err)
+			}
+			t.Cleanup(func() {
+				storage.Close()
+			})
+			return storage
+		},
+		"EventLog": func(t *testing.T) Storage {
+			storage, err := NewEventLogStorage(t.TempDir())
+			if err != nil {
+				t.Fatalf("Failed to create event log storage: %v", err)
+			}
+			t.Cleanup(func() {
+				storage.Close()
+			})
+			return storage
+		},
 	}
 
 	for storageType, factory := range factories {
@@ -215,8 +239,8 @@ func TestStorageSetProofReplacesExisting(t *testing.T) {
 func TestStorageAddPenalty(t *testing.T) {
 	testStorageImplementations(t, "AddPenalty", func(t *testing.T, storage Storage) {
 		timestamp := time.Date(2024, time.March, 4, 5, 6, 7, 0, time.UTC)
-		p1 := PenaltyEvent{User: "alice", Amount: 10, Timestamp: timestamp}
-		p2 := PenaltyEvent{User: "alice", Amount: 20, Timestamp: timestamp.Add(2 * time.Minute)}
+		p1 := PenaltyEvent{ID: "p1", User: "alice", Amount: 10, Timestamp: timestamp}
+		p2 := PenaltyEvent{ID: "p2", User: "alice", Amount: 20, Timestamp: timestamp.Add(2 * time.Minute)}
 
 		if err := storage.AddPenalty(p1); err != nil {
 			t.Fatalf("unexpected error: %v", err)
@@ -238,10 +262,39 @@ func TestStorageAddPenalty(t *testing.T) {
 	})
 }
 
+func TestStorageAddPenaltyIgnoresStoredIDs(t *testing.T) {
+	testStorageImplementations(t, "AddPenaltyIgnoresStoredIDs", func(t *testing.T, storage Storage) {
+		timestamp := time.Date(2024, time.March, 4, 5, 6, 7, 0, time.UTC)
+		penalty := PenaltyEvent{ID: "p1", User: "alice", Amount: 10, Timestamp: timestamp}
+		for range 2 {
+			if err := storage.AddPenalty(penalty); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		// Identical penalties without an ID are distinct penalties
+		for range 2 {
+			if err := storage.AddPenalty(PenaltyEvent{User: "alice", Amount: 10, Timestamp: timestamp}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		penalties, err := storage.Penalties("alice")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(penalties) != 3 || penalties[0] != penalty {
+			t.Fatalf("expected 3 penalties, got %#v", penalties)
+		}
+		if penalties[1].ID == "" || penalties[1].ID == penalties[2].ID {
+			t.Fatalf("expected penalties to get distinct IDs, got %#v", penalties)
+		}
+	})
+}
+
 func TestStoragePenaltiesReturnsCopy(t *testing.T) {
 	testStorageImplementations(t, "PenaltiesReturnsCopy", func(t *testing.T, storage Storage) {
-		p1 := PenaltyEvent{User: "alice", Amount: 10}
-		p2 := PenaltyEvent{User: "alice", Amount: 20}
+		p1 := PenaltyEvent{ID: "p1", User: "alice", Amount: 10}
+		p2 := PenaltyEvent{ID: "p2", User: "alice", Amount: 20}
 
 		if err := storage.AddPenalty(p1); err != nil {
 			t.Fatalf("unexpected error: %v", err)
@@ -273,6 +326,148 @@ func TestStoragePenaltiesReturnsCopy(t *testing.T) {
 	})
 }
 
+func TestStorageTimestampPrecision(t *testing.T) {
+	testStorageImplementations(t, "TimestampPrecision", func(t *testing.T, storage Storage) {
+		timestamp := time.Date(2024, time.April, 5, 6, 7, 8, 123456789, time.UTC)
+		vouch := VouchEvent{From: "alice", To: "bob", Timestamp: timestamp}
+		proof := ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp.Add(time.Nanosecond)}
+		penalty := PenaltyEvent{ID: "p1", User: "alice", Amount: 10, Timestamp: timestamp.Add(time.Millisecond)}
+
+		if err := storage.AddVouch(vouch); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := storage.SetProof(proof); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := storage.AddPenalty(penalty); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		vouchesFrom, err := storage.UserVouchesFrom("alice")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(vouchesFrom) != 1 || vouchesFrom[0] != vouch {
+			t.Fatalf("outgoing vouch did not round-trip: %#v", vouchesFrom)
+		}
+		vouchesTo, err := storage.UserVouchesTo("bob")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(vouchesTo) != 1 || vouchesTo[0] != vouch {
+			t.Fatalf("incoming vouch did not round-trip: %#v", vouchesTo)
+		}
+
+		gotProof, err := storage.ProofRecord("alice")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if gotProof != proof {
+			t.Fatalf("proof did not round-trip: expected %#v, got %#v", proof, gotProof)
+		}
+
+		penalties, err := storage.Penalties("alice")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(penalties) != 1 || penalties[0] != penalty {
+			t.Fatalf("penalty did not round-trip: %#v", penalties)
+		}
+	})
+}
+
+func TestStorageVouchWeight(t *testing.T) {
+	testStorageImplementations(t, "VouchWeight", func(t *testing.T, storage Storage) {
+		weighted := VouchEvent{From: "alice", To: "bob", Weight: 40}
+		unweighted := VouchEvent{From: "alice", To: "carol"}
+		if err := storage.AddVouch(weighted); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := storage.AddVouch(unweighted); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		vouches, err := storage.UserVouchesTo("bob")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(vouches) != 1 || vouches[0] != weighted {
+			t.Fatalf("weighted vouch did not round-trip: %#v", vouches)
+		}
+		vouches, err = storage.UserVouchesTo("carol")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(vouches) != 1 || vouches[0] != unweighted || vouches[0].EffectiveWeight() != maxVouchWeight {
+			t.Fatalf("unweighted vouch did not round-trip: %#v", vouches)
+		}
+	})
+}
+
+func TestStorageKeys(t *testing.T) {
+	testStorageImplementations(t, "Keys", func(t *testing.T, storage Storage) {
+		key, err := storage.KeyRecord("alice")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if key.User != "alice" || len(key.PublicKey) != 0 {
+			t.Fatalf("expected no key, got %#v", key)
+		}
+
+		timestamp := time.Date(2024, time.March, 1, 12, 0, 0, 5, time.UTC)
+		for _, fill := range []byte{1, 2} {
+			if err := storage.SetKey(KeyEvent{User: "alice", PublicKey: bytes.Repeat([]byte{fill}, 32), Timestamp: timestamp}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		key, err = storage.KeyRecord("alice")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(key.PublicKey, bytes.Repeat([]byte{2}, 32)) || !key.Timestamp.Equal(timestamp) {
+			t.Fatalf("expected the latest key, got %#v", key)
+		}
+
+		users, err := storage.Users()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(users) != 1 || users[0] != "alice" {
+			t.Fatalf("expected alice to be a user, got %v", users)
+		}
+	})
+}
+
+func TestStorageVouchExpiry(t *testing.T) {
+	testStorageImplementations(t, "VouchExpiry", func(t *testing.T, storage Storage) {
+		timestamp := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+		vouch := VouchEvent{From: "alice", To: "bob", Timestamp: timestamp, ExpiresAt: timestamp.Add(36*time.Hour + time.Nanosecond)}
+		if err := storage.AddVouch(vouch); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		vouches, err := storage.UserVouchesFrom("alice")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(vouches) != 1 || vouches[0] != vouch {
+			t.Fatalf("expiring vouch did not round-trip: %#v", vouches)
+		}
+
+		// Renewal replaces the expiry of the existing edge
+		vouch.ExpiresAt = vouch.ExpiresAt.Add(48 * time.Hour)
+		if err := storage.AddVouch(vouch); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		vouches, err = storage.UserVouchesTo("bob")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(vouches) != 1 || vouches[0] != vouch {
+			t.Fatalf("renewed vouch did not round-trip: %#v", vouches)
+		}
+	})
+}
+
 func TestStorageMultipleUsers(t *testing.T) {
 	testStorageImplementations(t, "MultipleUsers", func(t *testing.T, storage Storage) {
 		// Add vouches
@@ -444,6 +639,366 @@ func TestSQLiteStoragePersistence(t *testing.T) {
 	}
 }
 
+// Verifies that databases created with the original schema keep their data
+// after migration: second-resolution timestamps stay valid, precise ones are
+// accepted, and existing vouches get full weight.
+func TestSQLiteStorageMigratesLegacySchema(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_migration_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	// Create a database with the original schema
+	db, err := sql.Open("sqlite3", tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := createTables(db); err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+	legacy := time.Date(2023, time.May, 6, 7, 8, 9, 0, time.UTC)
+	if _, err := db.Exec("INSERT INTO vouches (from_user, to_user, timestamp) VALUES (?, ?, ?)", "alice", "bob", legacy.Unix()); err != nil {
+		t.Fatalf("Failed to insert legacy vouch: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO proofs (user, balance, timestamp) VALUES (?, ?, ?)", "alice", 100, legacy.Unix()); err != nil {
+		t.Fatalf("Failed to insert legacy proof: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO penalties (user, amount, timestamp) VALUES (?, ?, ?)", "alice", 10, legacy.Unix()); err != nil {
+		t.Fatalf("Failed to insert legacy penalty: %v", err)
+	}
+	db.Close()
+
+	storage, err := NewSQLiteStorage(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to open SQLite storage: %v", err)
+	}
+	defer storage.Close()
+
+	vouches, err := storage.UserVouchesFrom("alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vouches) != 1 || !vouches[0].Timestamp.Equal(legacy) {
+		t.Fatalf("legacy vouch not migrated: %#v", vouches)
+	}
+	if vouches[0].Weight != maxVouchWeight {
+		t.Fatalf("expected legacy vouch to get full weight, got %d", vouches[0].Weight)
+	}
+	if !vouches[0].ExpiresAt.IsZero() {
+		t.Fatalf("expected legacy vouch to never expire, got %v", vouches[0].ExpiresAt)
+	}
+	proof, err := storage.ProofRecord("alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !proof.Timestamp.Equal(legacy) {
+		t.Fatalf("legacy proof not migrated: %#v", proof)
+	}
+	penalties, err := storage.Penalties("alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(penalties) != 1 || !penalties[0].Timestamp.Equal(legacy) || penalties[0].ID != "sqlite-1" {
+		t.Fatalf("legacy penalty not migrated: %#v", penalties)
+	}
+
+	precise := legacy.Add(987654321 * time.Nanosecond)
+	if err := storage.AddPenalty(PenaltyEvent{User: "alice", Amount: 5, Timestamp: precise}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	penalties, err = storage.Penalties("alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(penalties) != 2 || !penalties[1].Timestamp.Equal(precise) {
+		t.Fatalf("expected precise timestamp after migration, got %#v", penalties)
+	}
+}
+
+// Verifies that bbolt data persists across reopening the file.
+func TestBoltStoragePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test_persistence.db")
+
+	// Create storage and add data
+	storage1, err := NewBoltStorage(path)
+	if err != nil {
+		t.Fatalf("Failed to create bbolt storage: %v", err)
+	}
+
+	if err := storage1.AddVouch(VouchEvent{From: "alice", To: "bob"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := storage1.SetProof(ProofEvent{User: "alice", Balance: 100}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := storage1.AddPenalty(PenaltyEvent{User: "alice", Amount: 10}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Close and reopen
+	storage1.Close()
+
+	storage2, err := NewBoltStorage(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen bbolt storage: %v", err)
+	}
+	defer storage2.Close()
+
+	// Verify data persisted
+	vouches, err := storage2.UserVouchesTo("bob")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vouches) != 1 || vouches[0].From != "alice" || vouches[0].To != "bob" {
+		t.Fatalf("vouches did not persist: %#v", vouches)
+	}
+
+	proof, err := storage2.ProofRecord("alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if proof.User != "alice" || proof.Balance != 100 {
+		t.Fatalf("proof did not persist: %#v", proof)
+	}
+
+	penalties, err := storage2.Penalties("alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(penalties) != 1 || penalties[0].Amount != 10 {
+		t.Fatalf("penalties did not persist: %#v", penalties)
+	}
+}
+
+// Writes a fixed set of events into the storage.
+func populateEventLogStorage(t *testing.T, storage Storage) {
+	timestamp := time.Date(2024, time.June, 7, 8, 9, 10, 11, time.UTC)
+	if err := storage.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := storage.AddVouch(VouchEvent{From: "bob", To: "carol", Timestamp: timestamp}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := storage.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := storage.AddPenalty(PenaltyEvent{User: "bob", Amount: 10, Timestamp: timestamp}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := storage.AddPenalty(PenaltyEvent{User: "bob", Amount: 20, Timestamp: timestamp}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Verifies that the storage holds the events written by populateEventLogStorage.
+func checkEventLogStorage(t *testing.T, storage Storage) {
+	vouches, err := storage.UserVouchesTo("carol")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vouches) != 1 || vouches[0].From != "bob" {
+		t.Fatalf("vouches not restored: %#v", vouches)
+	}
+	proof, err := storage.ProofRecord("alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if proof.Balance != 100 {
+		t.Fatalf("proof not restored: %#v", proof)
+	}
+	penalties, err := storage.Penalties("bob")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(penalties) != 2 || penalties[0].Amount != 10 || penalties[1].Amount != 20 {
+		t.Fatalf("penalties not restored: %#v", penalties)
+	}
+}
+
+// Verifies that the event log storage rebuilds its state by replaying the log.
+func TestEventLogStorageReplay(t *testing.T) {
+	dir := t.TempDir()
+	storage1, err := NewEventLogStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to create event log storage: %v", err)
+	}
+	// Disable snapshots so that state can only come from the log
+	storage1.snapshotInterval = 0
+	populateEventLogStorage(t, storage1)
+	storage1.file.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, eventLogSnapshotFileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected no snapshot, got %v", err)
+	}
+
+	storage2, err := NewEventLogStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen event log storage: %v", err)
+	}
+	defer storage2.Close()
+	checkEventLogStorage(t, storage2)
+}
+
+// Verifies that invalid events are rejected before they reach the log, and
+// that a closed log rejects writes.
+func TestEventLogStorageRejectsInvalidEvents(t *testing.T) {
+	dir := t.TempDir()
+	storage1, err := NewEventLogStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to create event log storage: %v", err)
+	}
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	if err := storage1.AddVouch(VouchEvent{From: "alice", To: "", Timestamp: timestamp}); err == nil {
+		t.Fatal("expected error for a vouch to an empty user")
+	}
+	if err := storage1.AddVouch(VouchEvent{From: "alice", To: "alice", Timestamp: timestamp}); err == nil {
+		t.Fatal("expected error for a vouch to self")
+	}
+	if err := storage1.SetProof(ProofEvent{User: "", Balance: 1, Timestamp: timestamp}); err == nil {
+		t.Fatal("expected error for an empty user")
+	}
+	if err := storage1.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := storage1.Close(); err != nil {
+		t.Fatalf("unexpected error closing storage: %v", err)
+	}
+	if err := storage1.AddVouch(VouchEvent{From: "alice", To: "carol", Timestamp: timestamp}); err != errEventLogClosed {
+		t.Fatalf("expected %v after closing, got %v", errEventLogClosed, err)
+	}
+	if err := storage1.SetWebhookDelivery(WebhookDelivery{ID: "a"}); err != errEventLogClosed {
+		t.Fatalf("expected %v after closing, got %v", errEventLogClosed, err)
+	}
+
+	storage2, err := NewEventLogStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen event log storage: %v", err)
+	}
+	defer storage2.Close()
+	if vouches, _ := storage2.UserVouchesFrom("alice"); len(vouches) != 1 || vouches[0].To != "bob" {
+		t.Fatalf("expected only the valid vouch, got %#v", vouches)
+	}
+}
+
+// Verifies that snapshots are written periodically and combined with the log tail on startup.
+func TestEventLogStorageSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	storage1, err := NewEventLogStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to create event log storage: %v", err)
+	}
+	storage1.snapshotInterval = 3
+	populateEventLogStorage(t, storage1)
+
+	// Snapshot was taken after the third event, two events remain in the tail
+	if storage1.pending != 2 {
+		t.Fatalf("expected 2 events after snapshot, got %d", storage1.pending)
+	}
+	storage1.file.Close()
+
+	storage2, err := NewEventLogStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen event log storage: %v", err)
+	}
+	if storage2.pending != 2 {
+		t.Fatalf("expected 2 replayed events, got %d", storage2.pending)
+	}
+	checkEventLogStorage(t, storage2)
+
+	// Closing writes a final snapshot
+	if err := storage2.Close(); err != nil {
+		t.Fatalf("unexpected error closing storage: %v", err)
+	}
+	storage3, err := NewEventLogStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen event log storage: %v", err)
+	}
+	defer storage3.Close()
+	if storage3.pending != 0 {
+		t.Fatalf("expected no replayed events, got %d", storage3.pending)
+	}
+	checkEventLogStorage(t, storage3)
+}
+
+// Verifies that a truncated record at the end of the log is discarded.
+func TestEventLogStorageTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	storage1, err := NewEventLogStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to create event log storage: %v", err)
+	}
+	storage1.snapshotInterval = 0
+	populateEventLogStorage(t, storage1)
+	validSize := storage1.offset
+	storage1.file.Close()
+
+	// Simulate an interrupted write
+	logPath := filepath.Join(dir, eventLogFileName)
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	if _, err := file.Write([]byte{0, 0, 1, 0, 1, 2}); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	file.Close()
+
+	storage2, err := NewEventLogStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen event log storage: %v", err)
+	}
+	defer storage2.Close()
+	checkEventLogStorage(t, storage2)
+
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatalf("Failed to stat log: %v", err)
+	}
+	if info.Size() != validSize {
+		t.Fatalf("expected log truncated to %d bytes, got %d", validSize, info.Size())
+	}
+
+	if err := storage2.AddPenalty(PenaltyEvent{User: "bob", Amount: 30}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	penalties, err := storage2.Penalties("bob")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(penalties) != 3 {
+		t.Fatalf("expected 3 penalties, got %d", len(penalties))
+	}
+}
+
+// Verifies that a corrupted record is reported instead of silently skipped.
+func TestEventLogStorageChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	storage1, err := NewEventLogStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to create event log storage: %v", err)
+	}
+	storage1.snapshotInterval = 0
+	populateEventLogStorage(t, storage1)
+	storage1.file.Close()
+
+	logPath := filepath.Join(dir, eventLogFileName)
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	// Flip a byte in the first record's payload
+	data[eventLogHeaderSize] ^= 0xff
+	if err := os.WriteFile(logPath, data, 0600); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	if _, err := NewEventLogStorage(dir); !errors.Is(err, errEventLogChecksum) {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+}
+
 // Verifies that AppState behaves identically with both storage types.
 func TestAppStateWithBothStorages(t *testing.T) {
 	// Test with memory storage
@@ -471,8 +1026,8 @@ func TestAppStateWithBothStorages(t *testing.T) {
 		state.AddVouch(VouchEvent{From: "bob", To: "carol"})
 		state.SetProof(ProofEvent{User: "alice", Balance: 100})
 		state.SetProof(ProofEvent{User: "bob", Balance: 50})
-		state.AddPenalty(PenaltyEvent{User: "alice", Amount: 10})
-		state.AddPenalty(PenaltyEvent{User: "alice", Amount: 15})
+		state.AddPenalty(PenaltyEvent{ID: "p1", User: "alice", Amount: 10})
+		state.AddPenalty(PenaltyEvent{ID: "p2", User: "alice", Amount: 15})
 	}
 
 	// Verify both states have identical results
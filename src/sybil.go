@@ -0,0 +1,245 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Users whose degree-normalized trust is at most this fraction of the average
+// are considered poorly connected to proven users.
+const sybilTrustThreshold = 0.5
+
+// Smallest group of poorly connected users reported as a cluster.
+const sybilMinClusterSize = 3
+
+// Largest conductance of a reported cluster. Clusters with low conductance
+// have few vouches crossing their boundary compared to vouches inside.
+const sybilMaxConductance = 0.5
+
+// Represents a group of users that are densely connected to each other but
+// weakly connected to proven users.
+type SybilCluster struct {
+	Users []string `json:"users"`
+	// Vouches between cluster members.
+	InternalEdges int `json:"internal_edges"`
+	// Vouch relationships between cluster members and the rest of the graph.
+	CutEdges int `json:"cut_edges"`
+	// Share of possible vouches between cluster members that exist.
+	Density float64 `json:"density"`
+	// Cut edges relative to the smaller of the cluster and remainder volumes.
+	Conductance float64 `json:"conductance"`
+	// Average degree-normalized trust of cluster members.
+	Trust float64 `json:"trust"`
+}
+
+// Represents the vouch graph with vouch direction ignored.
+type undirectedGraph struct {
+	users     []string
+	neighbors map[string]map[string]struct{}
+	// directed vouches, used to count edges inside clusters
+	vouches map[string]map[string]struct{}
+}
+
+func newUndirectedGraph(state *AppState) undirectedGraph {
+	graph := undirectedGraph{
+		neighbors: make(map[string]map[string]struct{}),
+		vouches:   make(map[string]map[string]struct{}),
+	}
+	link := func(set map[string]map[string]struct{}, a string, b string) {
+		if set[a] == nil {
+			set[a] = make(map[string]struct{})
+		}
+		set[a][b] = struct{}{}
+	}
+
+	graph.users = state.Users()
+	sort.Strings(graph.users)
+	for _, user := range graph.users {
+		for _, vouch := range state.UserVouchesFrom(user) {
+			if vouch.From == vouch.To {
+				continue
+			}
+			link(graph.neighbors, vouch.From, vouch.To)
+			link(graph.neighbors, vouch.To, vouch.From)
+			link(graph.vouches, vouch.From, vouch.To)
+		}
+	}
+	return graph
+}
+
+func (g undirectedGraph) degree(user string) int {
+	return len(g.neighbors[user])
+}
+
+// Returns users with a positive own balance, which serve as trust seeds.
+// The proof balance is decayed and reduced by penalties as in Balance, so
+// stale or punished proofs do not make a user a seed.
+func provenUsers(state *AppState, users []string) []string {
+	now := state.currentTime()
+	seeds := []string{}
+	for _, user := range users {
+		if _, err := state.ProofRecord(user); err != nil {
+			continue
+		}
+		if ownBalance(state, user, now) > 0 {
+			seeds = append(seeds, user)
+		}
+	}
+	return seeds
+}
+
+// Computes SybilRank-style trust for every user.
+// Trust starts evenly split between proven users and spreads along vouches in
+// both directions for O(log n) power iterations. The early termination keeps
+// trust from crossing the few vouches that connect sybil regions to honest
+// users. The result is divided by user degree so that well connected users
+// are not favored.
+func SybilRank(state *AppState) map[string]float64 {
+	graph := newUndirectedGraph(state)
+	return sybilRank(graph, provenUsers(state, graph.users))
+}
+
+func sybilRank(graph undirectedGraph, seeds []string) map[string]float64 {
+	trust := make(map[string]float64, len(graph.users))
+	for _, user := range graph.users {
+		trust[user] = 0
+	}
+	for _, seed := range seeds {
+		trust[seed] = 1 / float64(len(seeds))
+	}
+
+	iterations := int(math.Ceil(math.Log2(float64(len(graph.users)))))
+	for i := 0; i < iterations; i++ {
+		next := make(map[string]float64, len(trust))
+		for _, user := range graph.users {
+			degree := graph.degree(user)
+			if degree == 0 {
+				// Isolated users keep their trust
+				next[user] += trust[user]
+				continue
+			}
+			share := trust[user] / float64(degree)
+			for neighbor := range graph.neighbors[user] {
+				next[neighbor] += share
+			}
+		}
+		trust = next
+	}
+
+	for user, value := range trust {
+		if degree := graph.degree(user); degree > 0 {
+			trust[user] = value / float64(degree)
+		}
+	}
+	return trust
+}
+
+// Finds clusters of users that are poorly connected to proven users.
+// Users with trust at or below the threshold are grouped into connected components,
+// and components with low conductance are reported, least trusted first.
+// Without proven users there is no trusted region to compare against, so no
+// clusters are reported.
+func DetectSybilClusters(state *AppState) []SybilCluster {
+	graph := newUndirectedGraph(state)
+	seeds := provenUsers(state, graph.users)
+	if len(seeds) == 0 {
+		return []SybilCluster{}
+	}
+	trust := sybilRank(graph, seeds)
+
+	// Average trust over users that take part in vouches
+	total, count := 0.0, 0
+	totalVolume := 0
+	for _, user := range graph.users {
+		if degree := graph.degree(user); degree > 0 {
+			total += trust[user]
+			count++
+			totalVolume += degree
+		}
+	}
+	if count == 0 {
+		return []SybilCluster{}
+	}
+	threshold := sybilTrustThreshold * total / float64(count)
+
+	suspicious := make(map[string]bool)
+	for _, user := range graph.users {
+		if graph.degree(user) > 0 && trust[user] <= threshold {
+			suspicious[user] = true
+		}
+	}
+
+	clusters := []SybilCluster{}
+	visited := make(map[string]bool)
+	for _, start := range graph.users {
+		if !suspicious[start] || visited[start] {
+			continue
+		}
+
+		// Collect the connected component of suspicious users
+		members := []string{}
+		stack := []string{start}
+		visited[start] = true
+		for len(stack) > 0 {
+			user := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			members = append(members, user)
+			for neighbor := range graph.neighbors[user] {
+				if suspicious[neighbor] && !visited[neighbor] {
+					visited[neighbor] = true
+					stack = append(stack, neighbor)
+				}
+			}
+		}
+		if len(members) < sybilMinClusterSize {
+			continue
+		}
+
+		cluster := sybilCluster(graph, trust, members, totalVolume)
+		if cluster.Conductance <= sybilMaxConductance {
+			clusters = append(clusters, cluster)
+		}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Trust != clusters[j].Trust {
+			return clusters[i].Trust < clusters[j].Trust
+		}
+		return len(clusters[i].Users) > len(clusters[j].Users)
+	})
+	return clusters
+}
+
+// Computes the structural metrics of a group of users.
+func sybilCluster(graph undirectedGraph, trust map[string]float64, members []string, totalVolume int) SybilCluster {
+	sort.Strings(members)
+	inCluster := make(map[string]bool, len(members))
+	for _, user := range members {
+		inCluster[user] = true
+	}
+
+	cluster := SybilCluster{Users: members}
+	volume := 0
+	for _, user := range members {
+		volume += graph.degree(user)
+		cluster.Trust += trust[user]
+		for neighbor := range graph.neighbors[user] {
+			if !inCluster[neighbor] {
+				cluster.CutEdges++
+			}
+		}
+		for vouchee := range graph.vouches[user] {
+			if inCluster[vouchee] {
+				cluster.InternalEdges++
+			}
+		}
+	}
+	cluster.Trust /= float64(len(members))
+	cluster.Density = float64(cluster.InternalEdges) / float64(len(members)*(len(members)-1))
+
+	smallerVolume := min(volume, totalVolume-volume)
+	if smallerVolume > 0 {
+		cluster.Conductance = float64(cluster.CutEdges) / float64(smallerVolume)
+	}
+	return cluster
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Creates an honest region of proven users and a ring of fresh accounts
+// attached to it by a single vouch.
+func newSybilState() *AppState {
+	state := NewAppState()
+	honest := [][2]string{
+		{"alice", "bob"}, {"bob", "carol"}, {"carol", "dan"}, {"dan", "alice"},
+		{"alice", "carol"}, {"bob", "dan"}, {"erin", "alice"}, {"erin", "bob"},
+		{"carol", "erin"}, {"frank", "dan"}, {"frank", "erin"}, {"alice", "frank"},
+	}
+	sybils := [][2]string{
+		{"s1", "s2"}, {"s2", "s3"}, {"s3", "s4"}, {"s4", "s1"},
+		{"s1", "s3"}, {"s2", "s4"}, {"s3", "s1"},
+	}
+	for _, edge := range append(honest, sybils...) {
+		state.AddVouch(VouchEvent{From: edge[0], To: edge[1]})
+	}
+	// Attack edge
+	state.AddVouch(VouchEvent{From: "frank", To: "s1"})
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		state.SetProof(ProofEvent{User: user, Balance: 100, Timestamp: time.Now().UTC()})
+	}
+	return state
+}
+
+func TestSybilRankFavorsHonestRegion(t *testing.T) {
+	trust := SybilRank(newSybilState())
+	for _, honest := range []string{"alice", "bob", "carol", "dan", "erin"} {
+		for _, sybil := range []string{"s2", "s3", "s4"} {
+			if trust[honest] <= trust[sybil] {
+				t.Fatalf("expected %s trust %v above %s trust %v", honest, trust[honest], sybil, trust[sybil])
+			}
+		}
+	}
+}
+
+func TestDetectSybilClusters(t *testing.T) {
+	clusters := DetectSybilClusters(newSybilState())
+	if len(clusters) != 1 {
+		t.Fatalf("expected 1 cluster, got %#v", clusters)
+	}
+	cluster := clusters[0]
+	members := make(map[string]bool)
+	for _, user := range cluster.Users {
+		members[user] = true
+	}
+	for _, user := range []string{"s2", "s3", "s4"} {
+		if !members[user] {
+			t.Fatalf("expected %s in cluster, got %v", user, cluster.Users)
+		}
+	}
+	for _, user := range []string{"alice", "bob", "carol", "dan", "erin"} {
+		if members[user] {
+			t.Fatalf("did not expect honest user %s in cluster %v", user, cluster.Users)
+		}
+	}
+	if cluster.InternalEdges == 0 || cluster.Density <= 0 {
+		t.Fatalf("expected internal edges, got %#v", cluster)
+	}
+	if cluster.Conductance > sybilMaxConductance {
+		t.Fatalf("expected low conductance, got %v", cluster.Conductance)
+	}
+}
+
+func TestDetectSybilClustersIgnoresSmallGroups(t *testing.T) {
+	state := NewAppState()
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: time.Now().UTC()})
+	state.AddVouch(VouchEvent{From: "alice", To: "bob"})
+	state.AddVouch(VouchEvent{From: "bob", To: "alice"})
+	state.AddVouch(VouchEvent{From: "mallory", To: "trent"})
+
+	if clusters := DetectSybilClusters(state); len(clusters) != 0 {
+		t.Fatalf("expected no clusters, got %#v", clusters)
+	}
+}
+
+func TestDetectSybilClustersEmpty(t *testing.T) {
+	if clusters := DetectSybilClusters(NewAppState()); len(clusters) != 0 {
+		t.Fatalf("expected no clusters, got %#v", clusters)
+	}
+}
+
+func TestDetectSybilClustersWithoutProvenUsers(t *testing.T) {
+	state := NewAppState()
+	for _, edge := range [][2]string{{"alice", "bob"}, {"bob", "carol"}, {"carol", "alice"}, {"carol", "dan"}} {
+		state.AddVouch(VouchEvent{From: edge[0], To: edge[1]})
+	}
+	if clusters := DetectSybilClusters(state); len(clusters) != 0 {
+		t.Fatalf("expected no clusters without proven users, got %#v", clusters)
+	}
+}
+
+// Verifies that trust seeds use the decayed and penalized proof balance.
+func TestProvenUsersUseEffectiveBalance(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return timestamp }
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: timestamp})
+	// Fully decayed by now
+	state.SetProof(ProofEvent{User: "bob", Balance: 100, Timestamp: timestamp.AddDate(-10, 0, 0)})
+	// Outweighed by penalties
+	state.SetProof(ProofEvent{User: "carol", Balance: 100, Timestamp: timestamp})
+	state.AddPenalty(PenaltyEvent{User: "carol", Amount: 100, Timestamp: timestamp})
+
+	seeds := provenUsers(state, []string{"alice", "bob", "carol", "dan"})
+	if len(seeds) != 1 || seeds[0] != "alice" {
+		t.Fatalf("expected only alice as a seed, got %v", seeds)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+// Coarse identity level derived from a user's scores, for consumers that gate
+// features without interpreting raw IDT amounts.
+type Tier string
+
+const (
+	TierUnverified        Tier = "unverified"
+	TierBasic             Tier = "basic"
+	TierTrusted           Tier = "trusted"
+	TierModeratorVerified Tier = "moderator-verified"
+)
+
+// Cutoffs between tiers. Balances and penalties use the same units as IdtInfo.
+type TierThresholds struct {
+	// Minimum balance of a basic user.
+	Basic int64
+	// Minimum balance of a trusted user.
+	Trusted int64
+	// Maximum penalty of trusted and moderator-verified users.
+	MaxPenalty uint64
+}
+
+// Tier cutoffs used when a deployment does not configure its own.
+// Basic needs any positive balance, trusted needs 1 IDT with 6 decimals, and
+// any penalty disqualifies a user from the upper tiers.
+var defaultTierThresholds = TierThresholds{Basic: 1, Trusted: 1_000_000, MaxPenalty: 0}
+
+// Computes the tier of a user.
+// Users with a moderator proof and no more than the allowed penalty are
+// moderator-verified regardless of their balance.
+func ComputeTier(thresholds TierThresholds, balance int64, penalty uint64, proven bool) Tier {
+	clean := penalty <= thresholds.MaxPenalty
+	switch {
+	case proven && clean:
+		return TierModeratorVerified
+	case balance >= thresholds.Trusted && clean:
+		return TierTrusted
+	case balance >= thresholds.Basic:
+		return TierBasic
+	}
+	return TierUnverified
+}
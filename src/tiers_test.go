@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestComputeTier(t *testing.T) {
+	thresholds := TierThresholds{Basic: 10, Trusted: 100, MaxPenalty: 5}
+	cases := []struct {
+		balance  int64
+		penalty  uint64
+		proven   bool
+		expected Tier
+	}{
+		{balance: 0, penalty: 0, proven: false, expected: TierUnverified},
+		{balance: -20, penalty: 30, proven: false, expected: TierUnverified},
+		{balance: 10, penalty: 0, proven: false, expected: TierBasic},
+		{balance: 100, penalty: 5, proven: false, expected: TierTrusted},
+		// Penalties over the limit keep a user out of the upper tiers
+		{balance: 100, penalty: 6, proven: false, expected: TierBasic},
+		{balance: 0, penalty: 0, proven: true, expected: TierModeratorVerified},
+		{balance: 100, penalty: 6, proven: true, expected: TierBasic},
+	}
+	for _, c := range cases {
+		if got := ComputeTier(thresholds, c.balance, c.penalty, c.proven); got != c.expected {
+			t.Fatalf("ComputeTier(%d, %d, %v) = %s, expected %s", c.balance, c.penalty, c.proven, got, c.expected)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import "math"
+
+// Limits the number of equally short paths returned for a pair of users.
+const maxTrustPaths = 10
+
+// Represents a chain of vouches leading from one user to another.
+type TrustPath struct {
+	// Users along the path, starting with the voucher and ending with the target.
+	Users []string `json:"users"`
+	Hops  int      `json:"hops"`
+	// Share of the first user's balance that reaches the last user along this
+	// path, following the per-layer weight and vouch weights used by Balance.
+	Weight float64 `json:"weight"`
+}
+
+// Finds the shortest vouch paths from one user to another.
+// The search follows outgoing vouches breadth-first and gives up after
+// maxDepth hops. If maxDepth is negative, the search is unlimited.
+// Expired vouches are not followed.
+func TrustPaths(state *AppState, from string, to string, maxDepth int) []TrustPath {
+	if from == to {
+		return []TrustPath{{Users: []string{from}, Hops: 0, Weight: 1}}
+	}
+
+	// Vouches reaching each user on the shortest paths from the source
+	parents := map[string][]VouchEvent{}
+	levels := map[string]int{from: 0}
+	frontier := []string{from}
+	found := false
+	now := state.currentTime()
+
+	for depth := 1; len(frontier) > 0 && !found; depth++ {
+		// Negative depth means unlimited search
+		if maxDepth >= 0 && depth > maxDepth {
+			break
+		}
+		next := []string{}
+		for _, user := range frontier {
+			for _, vouch := range state.UserVouchesFrom(user) {
+				if vouch.ExpiredAt(now) {
+					continue
+				}
+				level, seen := levels[vouch.To]
+				if seen && level < depth {
+					continue
+				}
+				if !seen {
+					levels[vouch.To] = depth
+					next = append(next, vouch.To)
+				}
+				parents[vouch.To] = append(parents[vouch.To], vouch)
+				if vouch.To == to {
+					found = true
+				}
+			}
+		}
+		frontier = next
+	}
+
+	if !found {
+		return []TrustPath{}
+	}
+
+	// Walk predecessors back from the target to enumerate the paths
+	paths := []TrustPath{}
+	var walk func(user string, suffix []string, weight float64)
+	walk = func(user string, suffix []string, weight float64) {
+		if len(paths) >= maxTrustPaths {
+			return
+		}
+		path := append([]string{user}, suffix...)
+		if user == from {
+			hops := len(path) - 1
+			paths = append(paths, TrustPath{
+				Users:  path,
+				Hops:   hops,
+				Weight: weight * math.Pow(balanceWeightPerLayer, float64(hops)),
+			})
+			return
+		}
+		for _, vouch := range parents[user] {
+			walk(vouch.From, path, weight*float64(vouch.EffectiveWeight())/maxVouchWeight)
+		}
+	}
+	walk(to, nil, 1)
+	return paths
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTrustPathsSelf(t *testing.T) {
+	paths := TrustPaths(NewAppState(), "alice", "alice", DefaultTreeDepth)
+	if len(paths) != 1 || paths[0].Hops != 0 || paths[0].Weight != 1 {
+		t.Fatalf("unexpected paths: %#v", paths)
+	}
+}
+
+func TestTrustPathsShortest(t *testing.T) {
+	state := NewAppState()
+	// Two shortest paths of 2 hops and one longer path of 3 hops
+	state.AddVouch(VouchEvent{From: "alice", To: "bob"})
+	state.AddVouch(VouchEvent{From: "alice", To: "carol"})
+	state.AddVouch(VouchEvent{From: "bob", To: "erin"})
+	state.AddVouch(VouchEvent{From: "carol", To: "erin"})
+	state.AddVouch(VouchEvent{From: "alice", To: "dan"})
+	state.AddVouch(VouchEvent{From: "dan", To: "frank"})
+	state.AddVouch(VouchEvent{From: "frank", To: "erin"})
+
+	paths := TrustPaths(state, "alice", "erin", DefaultTreeDepth)
+	if len(paths) != 2 {
+		t.Fatalf("expected 2 shortest paths, got %#v", paths)
+	}
+	middles := map[string]bool{}
+	for _, path := range paths {
+		if path.Hops != 2 || len(path.Users) != 3 {
+			t.Fatalf("unexpected path: %#v", path)
+		}
+		if path.Users[0] != "alice" || path.Users[2] != "erin" {
+			t.Fatalf("unexpected path endpoints: %#v", path.Users)
+		}
+		if math.Abs(path.Weight-balanceWeightPerLayer*balanceWeightPerLayer) > 1e-12 {
+			t.Fatalf("unexpected weight %v", path.Weight)
+		}
+		middles[path.Users[1]] = true
+	}
+	if !middles["bob"] || !middles["carol"] {
+		t.Fatalf("expected paths through bob and carol, got %#v", paths)
+	}
+}
+
+func TestTrustPathsDepthCap(t *testing.T) {
+	state := NewAppState()
+	state.AddVouch(VouchEvent{From: "alice", To: "bob"})
+	state.AddVouch(VouchEvent{From: "bob", To: "carol"})
+
+	if paths := TrustPaths(state, "alice", "carol", 1); len(paths) != 0 {
+		t.Fatalf("expected no paths within 1 hop, got %#v", paths)
+	}
+	if paths := TrustPaths(state, "alice", "carol", 2); len(paths) != 1 {
+		t.Fatalf("expected 1 path within 2 hops, got %#v", paths)
+	}
+	// Vouches are directed
+	if paths := TrustPaths(state, "carol", "alice", -1); len(paths) != 0 {
+		t.Fatalf("expected no reverse path, got %#v", paths)
+	}
+}
+
+func TestTrustPathsCycle(t *testing.T) {
+	state := NewAppState()
+	state.AddVouch(VouchEvent{From: "alice", To: "bob"})
+	state.AddVouch(VouchEvent{From: "bob", To: "alice"})
+	state.AddVouch(VouchEvent{From: "bob", To: "carol"})
+
+	paths := TrustPaths(state, "alice", "carol", -1)
+	if len(paths) != 1 || paths[0].Hops != 2 {
+		t.Fatalf("unexpected paths: %#v", paths)
+	}
+}
+
+func TestTrustPathsVouchWeight(t *testing.T) {
+	state := NewAppState()
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Weight: 50})
+	state.AddVouch(VouchEvent{From: "bob", To: "carol"})
+
+	paths := TrustPaths(state, "alice", "carol", -1)
+	if len(paths) != 1 {
+		t.Fatalf("expected 1 path, got %#v", paths)
+	}
+	expected := 0.5 * balanceWeightPerLayer * balanceWeightPerLayer
+	if math.Abs(paths[0].Weight-expected) > 1e-12 {
+		t.Fatalf("expected weight %v, got %v", expected, paths[0].Weight)
+	}
+}
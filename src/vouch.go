@@ -3,11 +3,76 @@ package main
 import "time"
 
 // Handles vouch requests
-func VouchHandler(state *AppState, from string, _signature string, _nonce string, to string) IdentityError {
+// Weight is the voucher's confidence from 1 to maxVouchWeight, or zero for full weight.
+// ExpiresAt is the requested expiry, or zero to use the deployment's policy.
+// Stake is the part of the voucher's balance put at risk by the vouch.
+func VouchHandler(state *AppState, from string, _signature string, _nonce string, to string, weight uint64, expiresAt time.Time, stake uint64) IdentityError {
+	// Remote users vouch on their home instance
+	if IsRemoteUser(from) {
+		return ErrRemoteUser
+	}
+	if !validUserID(from) || !validUserID(to) || from == to {
+		return ErrInvalidUserID
+	}
+	if weight > maxVouchWeight {
+		return ErrInvalidVouchWeight
+	}
+	if weight == 0 {
+		weight = maxVouchWeight
+	}
+	now := state.currentTime()
+	expiresAt, err := state.vouchExpiry(now, expiresAt)
+	if err != nil {
+		return err
+	}
+	if err := checkVouchLimits(state, from, to, now); err != nil {
+		return err
+	}
+	if err := checkStake(state, from, to, stake, now); err != nil {
+		return err
+	}
 	state.AddVouch(VouchEvent{
 		From:      from,
 		To:        to,
-		Timestamp: time.Now().UTC(),
+		Timestamp: now,
+		Weight:    weight,
+		ExpiresAt: expiresAt,
+		Stake:     stake,
 	})
 	return nil
 }
+
+// Handles vouch renewal requests
+// Extends the expiry of an existing vouch without creating a new edge, so the
+// vouch keeps its original timestamp, weight and stake. ExpiresAt is the requested
+// expiry, or zero to use the deployment's policy.
+func RenewHandler(state *AppState, from string, to string, expiresAt time.Time) IdentityError {
+	if IsRemoteUser(from) {
+		return ErrRemoteUser
+	}
+	if !validUserID(from) || !validUserID(to) {
+		return ErrInvalidUserID
+	}
+	var vouch *VouchEvent
+	for _, v := range state.UserVouchesFrom(from) {
+		if v.To == to {
+			vouch = &v
+			break
+		}
+	}
+	if vouch == nil {
+		return ErrVouchNotFound
+	}
+
+	expiresAt, err := state.vouchExpiry(state.currentTime(), expiresAt)
+	if err != nil {
+		return err
+	}
+	// Renewal must not shorten the vouch
+	if !expiresAt.IsZero() && !vouch.ExpiresAt.IsZero() && expiresAt.Before(vouch.ExpiresAt) {
+		return ErrInvalidVouchExpiry
+	}
+	vouch.ExpiresAt = expiresAt
+	state.UpdateVouch(*vouch, WriteReasonRenew)
+	return nil
+}
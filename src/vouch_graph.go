@@ -13,14 +13,49 @@ import (
 // NOTE: penalties are not limited with 100 IDT, so one may consider another depth for penalty calculations.
 const DefaultTreeDepth = 8
 
+// Full confidence of a vouch, in percent.
+const maxVouchWeight = 100
+
 // Represents a stored vouch.
 type VouchEvent struct {
-	From      string
-	To        string
-	Timestamp time.Time
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Timestamp time.Time `json:"timestamp"`
+	// Confidence of the voucher from 1 to maxVouchWeight. Zero means full
+	// weight, so vouches recorded before weights existed count fully.
+	Weight uint64 `json:"weight,omitempty"`
+	// Time after which the vouch no longer counts. Zero means it never expires.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// Part of the voucher's balance put at risk by the vouch. Punishing the
+	// vouchee slashes the stake into a penalty on the voucher.
+	Stake uint64 `json:"stake,omitempty"`
 	// TODO: maybe store proof for external verification?
 }
 
+// Returns the vouch weight, treating an unset weight as full weight.
+func (v VouchEvent) EffectiveWeight() uint64 {
+	if v.Weight == 0 || v.Weight > maxVouchWeight {
+		return maxVouchWeight
+	}
+	return v.Weight
+}
+
+// Reports whether the vouch has expired at the given time.
+func (v VouchEvent) ExpiredAt(now time.Time) bool {
+	return !v.ExpiresAt.IsZero() && !now.Before(v.ExpiresAt)
+}
+
+// Scales an amount passing through the vouch by its weight.
+// The amount is split at maxVouchWeight so that neither product can overflow,
+// even for amounts close to the limits of T. The result is rounded toward zero.
+func scaleByWeight[T int64 | uint64](amount T, vouch VouchEvent) T {
+	weight := T(vouch.EffectiveWeight())
+	if weight == maxVouchWeight {
+		return amount
+	}
+	return amount/maxVouchWeight*weight + amount%maxVouchWeight*weight/maxVouchWeight
+}
+
 // Represents a vouch event in the vouch tree.
 type VouchTreeEdge struct {
 	Event VouchEvent
@@ -47,7 +82,8 @@ func NewVouchTreeNode(user string, depth int) VouchTreeNode {
 
 // Builds a depth-limited tree in a specified direction.
 // If depth is negative, the search is unlimited.
-func buildTree(state *AppState, user string, depth int, isOutgoing bool) *VouchTreeNode {
+// Vouches that have expired at `now` are left out of the tree.
+func buildTree(state *AppState, user string, depth int, isOutgoing bool, now time.Time) *VouchTreeNode {
 	if depth == 0 {
 		return &VouchTreeNode{User: user, Depth: 0, Peers: []VouchTreeEdge{}}
 	}
@@ -83,6 +119,10 @@ func buildTree(state *AppState, user string, depth int, isOutgoing bool) *VouchT
 		}
 
 		for _, event := range events {
+			if event.ExpiredAt(now) {
+				continue
+			}
+
 			peerUser := event.From
 			if isOutgoing {
 				peerUser = event.To
@@ -119,12 +159,22 @@ func buildTree(state *AppState, user string, depth int, isOutgoing bool) *VouchT
 
 // Builds a depth-limited outgoing vouch tree rooted at the user iteratively.
 func OutgoingTree(state *AppState, user string, depth int) *VouchTreeNode {
-	return buildTree(state, user, depth, true)
+	return buildTree(state, user, depth, true, state.currentTime())
 }
 
 // Builds a depth-limited incoming vouch tree rooted at the user iteratively.
 func IncomingTree(state *AppState, user string, depth int) *VouchTreeNode {
-	return buildTree(state, user, depth, false)
+	return buildTree(state, user, depth, false, state.currentTime())
+}
+
+// Builds a depth-limited outgoing vouch tree of vouches still active at `now`.
+func OutgoingTreeAt(state *AppState, user string, depth int, now time.Time) *VouchTreeNode {
+	return buildTree(state, user, depth, true, now)
+}
+
+// Builds a depth-limited incoming vouch tree of vouches still active at `now`.
+func IncomingTreeAt(state *AppState, user string, depth int, now time.Time) *VouchTreeNode {
+	return buildTree(state, user, depth, false, now)
 }
 
 // Traverses the vouch tree in post-order and applies the process function to each node.
@@ -1,6 +1,10 @@
 package main
 
-import "testing"
+import (
+	"math"
+	"testing"
+	"time"
+)
 
 func edgeSet(edges []VouchTreeEdge) map[string]VouchTreeEdge {
 	set := make(map[string]VouchTreeEdge, len(edges))
@@ -293,3 +297,49 @@ func TestVouchGraphIncomingTreeBranchIndependence(t *testing.T) {
 		t.Fatalf("unexpected carol incoming edge: %#v", aliceFromCarol)
 	}
 }
+
+func TestBuildTreeSkipsExpiredVouches(t *testing.T) {
+	state := NewAppState()
+	timestamp := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	expiresAt := timestamp.Add(24 * time.Hour)
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: timestamp, ExpiresAt: expiresAt})
+	state.AddVouch(VouchEvent{From: "alice", To: "carol", Timestamp: timestamp})
+
+	tree := OutgoingTreeAt(state, "alice", DefaultTreeDepth, expiresAt.Add(-time.Nanosecond))
+	if got := len(tree.Peers); got != 2 {
+		t.Fatalf("expected 2 peers before expiry, got %d", got)
+	}
+	tree = OutgoingTreeAt(state, "alice", DefaultTreeDepth, expiresAt)
+	if got := len(tree.Peers); got != 1 || tree.Peers[0].Peer.User != "carol" {
+		t.Fatalf("expected only carol after expiry, got %#v", tree.Peers)
+	}
+	tree = IncomingTreeAt(state, "bob", DefaultTreeDepth, expiresAt)
+	if got := len(tree.Peers); got != 0 {
+		t.Fatalf("expected no incoming peers after expiry, got %d", got)
+	}
+}
+
+// Verifies that weighted amounts near the integer limits neither overflow nor wrap sign.
+func TestScaleByWeightBoundaries(t *testing.T) {
+	half := VouchEvent{Weight: 50}
+	if got := scaleByWeight(uint64(math.MaxUint64), half); got != math.MaxUint64/2 {
+		t.Fatalf("expected %d, got %d", uint64(math.MaxUint64/2), got)
+	}
+	if got := scaleByWeight(int64(math.MaxInt64), half); got != math.MaxInt64/2 {
+		t.Fatalf("expected %d, got %d", int64(math.MaxInt64/2), got)
+	}
+	if got := scaleByWeight(int64(math.MinInt64), half); got != math.MinInt64/2 {
+		t.Fatalf("expected %d, got %d", int64(math.MinInt64/2), got)
+	}
+	// floor(MaxInt64 * 99 / 100)
+	if got := scaleByWeight(int64(math.MaxInt64), VouchEvent{Weight: 99}); got != 9131138316486228048 {
+		t.Fatalf("expected 9131138316486228048, got %d", got)
+	}
+	// Small amounts round toward zero as before
+	if got := scaleByWeight(int64(-199), half); got != -99 {
+		t.Fatalf("expected -99, got %d", got)
+	}
+	if got := scaleByWeight(uint64(199), VouchEvent{Weight: 1}); got != 1 {
+		t.Fatalf("expected 1, got %d", got)
+	}
+}
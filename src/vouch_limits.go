@@ -0,0 +1,69 @@
+package main
+
+import "time"
+
+// Limits on how many users one identity can vouch for.
+// Zero values disable the corresponding limit.
+type VouchLimits struct {
+	// Maximum number of active (unexpired) outgoing vouches per user.
+	MaxActive int
+	// Balance needed for each active vouch above MaxActive. Lets users with
+	// a higher balance vouch for more users. Zero disables the scaling.
+	BalancePerVouch uint64
+	// Maximum number of vouches a user can make within RateWindow.
+	MaxPerWindow int
+	RateWindow   time.Duration
+}
+
+// Returns the number of active outgoing vouches a user with the given balance
+// may hold. Returns 0 if the number is unlimited.
+func (l VouchLimits) activeCap(balance int64) int {
+	if l.MaxActive == 0 {
+		return 0
+	}
+	limit := l.MaxActive
+	if l.BalancePerVouch > 0 && balance > 0 {
+		limit += int(uint64(balance) / l.BalancePerVouch)
+	}
+	return limit
+}
+
+// Checks that a new vouch from `from` to `to` at `now` stays within the
+// deployment's limits. Replacing an existing vouch for the same user does not
+// count as an additional vouch.
+func checkVouchLimits(state *AppState, from string, to string, now time.Time) IdentityError {
+	limits := state.vouchLimits
+	if limits.MaxActive == 0 && (limits.MaxPerWindow == 0 || limits.RateWindow <= 0) {
+		return nil
+	}
+
+	active := 0
+	recent := 0
+	windowStart := now.Add(-limits.RateWindow)
+	for _, vouch := range state.UserVouchesFrom(from) {
+		if vouch.To == to {
+			continue
+		}
+		if !vouch.ExpiredAt(now) {
+			active++
+		}
+		if vouch.Timestamp.After(windowStart) && !vouch.Timestamp.After(now) {
+			recent++
+		}
+	}
+
+	if limits.MaxPerWindow > 0 && limits.RateWindow > 0 && recent >= limits.MaxPerWindow {
+		return ErrVouchRateLimited
+	}
+	if limits.MaxActive > 0 {
+		balance := int64(0)
+		// Computing the balance is costly, only do it when it can raise the cap
+		if limits.BalancePerVouch > 0 && active >= limits.MaxActive {
+			balance = Balance(state, from, nil, &now)
+		}
+		if active >= limits.activeCap(balance) {
+			return ErrVouchQuotaExceeded
+		}
+	}
+	return nil
+}
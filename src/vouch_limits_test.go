@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVouchQuota(t *testing.T) {
+	state := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	state.vouchLimits = VouchLimits{MaxActive: 2}
+
+	for _, to := range []string{"bob", "carol"} {
+		if err := VouchHandler(state, "alice", "sig", "nonce", to, 0, time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error vouching for %s: %v", to, err)
+		}
+	}
+	if err := VouchHandler(state, "alice", "sig", "nonce", "dave", 0, time.Time{}, 0); err != ErrVouchQuotaExceeded {
+		t.Fatalf("expected %v, got %v", ErrVouchQuotaExceeded, err)
+	}
+	// Replacing an existing vouch does not count against the quota
+	if err := VouchHandler(state, "alice", "sig", "nonce", "bob", 50, time.Time{}, 0); err != nil {
+		t.Fatalf("unexpected error replacing a vouch: %v", err)
+	}
+
+	// Expired vouches free up the quota
+	state.AddVouch(VouchEvent{From: "erin", To: "bob", Timestamp: now, ExpiresAt: now.Add(time.Hour)})
+	state.AddVouch(VouchEvent{From: "erin", To: "carol", Timestamp: now})
+	now = now.Add(time.Hour)
+	if err := VouchHandler(state, "erin", "sig", "nonce", "dave", 0, time.Time{}, 0); err != nil {
+		t.Fatalf("unexpected error after expiry: %v", err)
+	}
+}
+
+func TestVouchQuotaScalesWithBalance(t *testing.T) {
+	state := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	state.vouchLimits = VouchLimits{MaxActive: 1, BalancePerVouch: 100}
+	state.SetProof(ProofEvent{User: "alice", Balance: 250, Timestamp: now})
+
+	// One base vouch plus two for the balance of 250
+	for _, to := range []string{"bob", "carol", "dave"} {
+		if err := VouchHandler(state, "alice", "sig", "nonce", to, 0, time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error vouching for %s: %v", to, err)
+		}
+	}
+	if err := VouchHandler(state, "alice", "sig", "nonce", "erin", 0, time.Time{}, 0); err != ErrVouchQuotaExceeded {
+		t.Fatalf("expected %v, got %v", ErrVouchQuotaExceeded, err)
+	}
+
+	if err := VouchHandler(state, "frank", "sig", "nonce", "bob", 0, time.Time{}, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := VouchHandler(state, "frank", "sig", "nonce", "carol", 0, time.Time{}, 0); err != ErrVouchQuotaExceeded {
+		t.Fatalf("expected %v without balance, got %v", ErrVouchQuotaExceeded, err)
+	}
+}
+
+func TestVouchRateLimit(t *testing.T) {
+	state := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	state.vouchLimits = VouchLimits{MaxPerWindow: 2, RateWindow: time.Hour}
+
+	for _, to := range []string{"bob", "carol"} {
+		if err := VouchHandler(state, "alice", "sig", "nonce", to, 0, time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error vouching for %s: %v", to, err)
+		}
+		now = now.Add(10 * time.Minute)
+	}
+	if err := VouchHandler(state, "alice", "sig", "nonce", "dave", 0, time.Time{}, 0); err != ErrVouchRateLimited {
+		t.Fatalf("expected %v, got %v", ErrVouchRateLimited, err)
+	}
+
+	// The first vouch leaves the window
+	now = now.Add(45 * time.Minute)
+	if err := VouchHandler(state, "alice", "sig", "nonce", "dave", 0, time.Time{}, 0); err != nil {
+		t.Fatalf("unexpected error after the window: %v", err)
+	}
+}
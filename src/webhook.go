@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of attempts after which a delivery is given up.
+const webhookMaxAttempts = 8
+
+// Delay before the first retry. Each further retry waits twice as long.
+const webhookRetryBase = 10 * time.Second
+
+// Longest delay between two attempts.
+const webhookRetryMax = time.Hour
+
+// Time a subscriber has to respond to a delivery.
+const webhookTimeout = 10 * time.Second
+
+// Maximum age of a signature accepted by VerifyWebhookSignature.
+const webhookSignatureTolerance = 5 * time.Minute
+
+// Header carrying the HMAC signature of a delivery.
+const webhookSignatureHeader = "X-IDT-Signature"
+
+// Identifies what a webhook notification is about.
+type WebhookEventType string
+
+const (
+	WebhookEventVouch   WebhookEventType = "vouch"
+	WebhookEventProof   WebhookEventType = "proof"
+	WebhookEventPenalty WebhookEventType = "penalty"
+	// The expiry of a vouch was extended
+	WebhookEventRenew WebhookEventType = "renew"
+	// The stake of a vouch was slashed by a penalty on the vouchee
+	WebhookEventSlash WebhookEventType = "slash"
+	// A user's tier changed
+	WebhookEventTier WebhookEventType = "tier"
+	// A user's balance crossed one of the configured thresholds
+	WebhookEventBalance WebhookEventType = "balance"
+)
+
+var webhookEventTypes = []WebhookEventType{WebhookEventVouch, WebhookEventProof, WebhookEventPenalty, WebhookEventRenew, WebhookEventSlash, WebhookEventTier, WebhookEventBalance}
+
+// Types of the notifications of vouches rewritten for a reason.
+var webhookReasonTypes = map[WriteReason]WebhookEventType{
+	WriteReasonRenew: WebhookEventRenew,
+	WriteReasonSlash: WebhookEventSlash,
+}
+
+// Represents an endpoint notified of a set of event types.
+type WebhookSubscription struct {
+	URL    string
+	Events []WebhookEventType
+}
+
+// Configures the webhook subscriptions of a deployment.
+type WebhookConfig struct {
+	Subscriptions []WebhookSubscription
+	// Key of the HMAC-SHA256 signature of every delivery
+	Secret []byte
+	// Balances at which balance notifications fire, in both directions
+	BalanceThresholds []int64
+}
+
+// Represents the change of a user's scores behind a tier or balance
+// notification.
+type ScoreChange struct {
+	User            string `json:"user"`
+	PreviousBalance int64  `json:"previous_balance"`
+	Balance         int64  `json:"balance"`
+	PreviousTier    Tier   `json:"previous_tier"`
+	Tier            Tier   `json:"tier"`
+	// Crossed balance threshold of a balance notification
+	Threshold *int64 `json:"threshold,omitempty"`
+}
+
+// Represents the body posted to subscribers.
+type WebhookNotification struct {
+	ID        string           `json:"id"`
+	Type      WebhookEventType `json:"type"`
+	CreatedAt time.Time        `json:"created_at"`
+	// Accepted write of vouch, proof, penalty, renew and slash notifications
+	Event *Event `json:"event,omitempty"`
+	// Score change of tier and balance notifications
+	Change *ScoreChange `json:"change,omitempty"`
+}
+
+// Tracks whether a delivery is still being attempted.
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryDelivered WebhookDeliveryStatus = "delivered"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// Represents the delivery of one notification to one subscriber.
+type WebhookDelivery struct {
+	ID       string                `json:"id"`
+	URL      string                `json:"url"`
+	Type     WebhookEventType      `json:"type"`
+	Payload  json.RawMessage       `json:"payload"`
+	Status   WebhookDeliveryStatus `json:"status"`
+	Attempts int                   `json:"attempts"`
+	// Status code of the last response, zero if there was none
+	ResponseCode  int       `json:"response_code,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastAttemptAt time.Time `json:"last_attempt_at,omitzero"`
+	// Time of the next attempt of a pending delivery
+	NextAttemptAt time.Time `json:"next_attempt_at,omitzero"`
+}
+
+// Parses a subscription of the form event[,event...]=url.
+func ParseWebhookSubscription(value string) (WebhookSubscription, error) {
+	names, rawURL, ok := strings.Cut(value, "=")
+	if !ok || names == "" {
+		return WebhookSubscription{}, fmt.Errorf("invalid webhook %q, expected event[,event...]=url", value)
+	}
+	subscription := WebhookSubscription{}
+	for _, name := range strings.Split(names, ",") {
+		event := WebhookEventType(name)
+		if !slices.Contains(webhookEventTypes, event) {
+			return WebhookSubscription{}, fmt.Errorf("invalid webhook %q: unknown event %q", value, name)
+		}
+		subscription.Events = append(subscription.Events, event)
+	}
+	if _, err := url.ParseRequestURI(rawURL); err != nil {
+		return WebhookSubscription{}, fmt.Errorf("invalid webhook %q: %w", value, err)
+	}
+	subscription.URL = rawURL
+	return subscription, nil
+}
+
+// Reads the webhook secret from a file, ignoring surrounding whitespace.
+func LoadWebhookSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret := bytes.TrimSpace(data)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("webhook secret file %s is empty", path)
+	}
+	return secret, nil
+}
+
+// Returns the signature header of a body sent at the given time:
+// t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">.
+func SignWebhook(secret []byte, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + webhookMAC(secret, t, body)
+}
+
+func webhookMAC(secret []byte, t string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks the signature header of a received body. Signatures older than
+// webhookSignatureTolerance are rejected to prevent replays.
+func VerifyWebhookSignature(secret []byte, header string, body []byte, now time.Time) error {
+	var t, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			signature = value
+		}
+	}
+	seconds, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || signature == "" {
+		return fmt.Errorf("malformed webhook signature")
+	}
+	if !hmac.Equal([]byte(signature), []byte(webhookMAC(secret, t, body))) {
+		return fmt.Errorf("invalid webhook signature")
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > webhookSignatureTolerance || age < -webhookSignatureTolerance {
+		return fmt.Errorf("webhook signature is outside of the tolerance")
+	}
+	return nil
+}
+
+// Returns the delay before the next attempt of a delivery that has failed
+// `attempts` times.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookRetryMax)
+}
+
+// Scores of a user as last notified.
+type webhookScore struct {
+	balance int64
+	tier    Tier
+}
+
+// Turns accepted writes into notifications and delivers them to the
+// subscribers. Writes are queued without blocking; a single worker started
+// with Run creates the deliveries and attempts those of each endpoint
+// concurrently, so pending deliveries and scores are only used by the worker.
+type WebhookDispatcher struct {
+	config WebhookConfig
+	client *http.Client
+
+	mu     sync.Mutex
+	queued []BusEvent
+	// signaled when events are queued
+	wake chan struct{}
+
+	// deliveries still being attempted
+	pending []WebhookDelivery
+	// scores of users as last computed, users missing had no records yet
+	scores map[string]webhookScore
+}
+
+// Creates a dispatcher for the subscriptions that queues the writes
+// published by the state. When scores are watched, the current scores of
+// every user are recorded so that later changes can be detected.
+func NewWebhookDispatcher(state *AppState, config WebhookConfig) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		config: config,
+		client: &http.Client{Timeout: webhookTimeout},
+		wake:   make(chan struct{}, 1),
+		scores: make(map[string]webhookScore),
+	}
+	if d.watchesScores() {
+		for _, user := range state.Users() {
+			d.scores[user] = d.score(state, user)
+		}
+	}
+	state.events.Subscribe("", func(published BusEvent) {
+		d.Enqueue(published)
+	})
+	return d
+}
+
+// Queues an accepted write for notification.
+func (d *WebhookDispatcher) Enqueue(published BusEvent) {
+	d.mu.Lock()
+	d.queued = append(d.queued, published)
+	d.mu.Unlock()
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *WebhookDispatcher) subscribed(event WebhookEventType) bool {
+	for _, subscription := range d.config.Subscriptions {
+		if slices.Contains(subscription.Events, event) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *WebhookDispatcher) watchesScores() bool {
+	return d.subscribed(WebhookEventTier) || (d.subscribed(WebhookEventBalance) && len(d.config.BalanceThresholds) > 0)
+}
+
+func (d *WebhookDispatcher) score(state *AppState, user string) webhookScore {
+	info, err := IdtHandler(state, user)
+	if err != nil {
+		log.Printf("Error scoring user %s: %v", user, err)
+		return webhookScore{tier: TierUnverified}
+	}
+	return webhookScore{balance: info.Balance, tier: info.Tier}
+}
+
+// Returns the users whose scores the event may change: the users it names
+// and everyone within the scoring depth of them in either direction, since
+// balances flow to vouchees and penalties to vouchers. Each user is visited
+// once, however many paths lead to them.
+func affectedUsers(state *AppState, event Event) []string {
+	var named []string
+	switch event.Kind {
+	case EventKindVouch:
+		named = []string{event.Vouch.From, event.Vouch.To}
+	case EventKindProof:
+		named = []string{event.Proof.User}
+	case EventKindPenalty:
+		named = []string{event.Penalty.User}
+	}
+
+	seen := make(map[string]bool)
+	users := []string{}
+	now := state.currentTime()
+	for _, isOutgoing := range []bool{false, true} {
+		// Breadth first, so that a user is first reached at their lowest depth
+		visited := make(map[string]bool)
+		frontier := []string{}
+		for _, user := range named {
+			if !visited[user] {
+				visited[user] = true
+				frontier = append(frontier, user)
+			}
+		}
+		for depth := 0; len(frontier) > 0; depth++ {
+			next := []string{}
+			for _, user := range frontier {
+				if !seen[user] {
+					seen[user] = true
+					users = append(users, user)
+				}
+				if depth == DefaultTreeDepth {
+					continue
+				}
+				var vouches []VouchEvent
+				if isOutgoing {
+					vouches = state.UserVouchesFrom(user)
+				} else {
+					vouches = state.UserVouchesTo(user)
+				}
+				for _, vouch := range vouches {
+					if vouch.ExpiredAt(now) {
+						continue
+					}
+					peer := vouch.From
+					if isOutgoing {
+						peer = vouch.To
+					}
+					if !visited[peer] {
+						visited[peer] = true
+						next = append(next, peer)
+					}
+				}
+			}
+			frontier = next
+		}
+	}
+	return users
+}
+
+// Returns the notifications of an accepted write. Vouches rewritten for a
+// reason, such as renewals and slashed stakes, are notified under the type
+// of the reason rather than as new vouches.
+func (d *WebhookDispatcher) notifications(state *AppState, published BusEvent) []WebhookNotification {
+	now := state.currentTime()
+	event := published.Event
+	notifications := []WebhookNotification{}
+	switch event.Kind {
+	case EventKindVouch, EventKindProof, EventKindPenalty:
+		notificationType := WebhookEventType(event.Kind)
+		if reasonType, ok := webhookReasonTypes[published.Reason]; ok {
+			notificationType = reasonType
+		}
+		notifications = append(notifications, WebhookNotification{Type: notificationType, CreatedAt: now, Event: &event})
+	default:
+		return notifications
+	}
+	if !d.watchesScores() {
+		return notifications
+	}
+
+	for _, user := range affectedUsers(state, event) {
+		previous, ok := d.scores[user]
+		if !ok {
+			// Users without records have no balance
+			previous = webhookScore{tier: TierUnverified}
+		}
+		current := d.score(state, user)
+		d.scores[user] = current
+		change := ScoreChange{
+			User:            user,
+			PreviousBalance: previous.balance,
+			Balance:         current.balance,
+			PreviousTier:    previous.tier,
+			Tier:            current.tier,
+		}
+		if current.tier != previous.tier {
+			notifications = append(notifications, WebhookNotification{Type: WebhookEventTier, CreatedAt: now, Change: &change})
+		}
+		for _, threshold := range d.config.BalanceThresholds {
+			if (previous.balance >= threshold) != (current.balance >= threshold) {
+				crossed := change
+				crossed.Threshold = &threshold
+				notifications = append(notifications, WebhookNotification{Type: WebhookEventBalance, CreatedAt: now, Change: &crossed})
+			}
+		}
+	}
+	return notifications
+}
+
+// Records a pending delivery of the notification for every subscriber of
+// its type.
+func (d *WebhookDispatcher) schedule(state *AppState, notification WebhookNotification) {
+	notification.ID = rand.Text()
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		log.Printf("Failed to encode webhook notification: %v", err)
+		return
+	}
+	for _, subscription := range d.config.Subscriptions {
+		if !slices.Contains(subscription.Events, notification.Type) {
+			continue
+		}
+		delivery := WebhookDelivery{
+			ID:            rand.Text(),
+			URL:           subscription.URL,
+			Type:          notification.Type,
+			Payload:       payload,
+			Status:        WebhookDeliveryPending,
+			CreatedAt:     notification.CreatedAt,
+			NextAttemptAt: notification.CreatedAt,
+		}
+		state.SetWebhookDelivery(delivery)
+		d.pending = append(d.pending, delivery)
+	}
+}
+
+// Posts the delivery once and records the outcome.
+func (d *WebhookDispatcher) attempt(ctx context.Context, state *AppState, delivery WebhookDelivery) WebhookDelivery {
+	now := state.currentTime()
+	delivery.Attempts++
+	delivery.LastAttemptAt = now
+	delivery.ResponseCode, delivery.Error = 0, ""
+
+	err := func() error {
+		req, err := http.NewRequestWithContext(ctx, "POST", delivery.URL, bytes.NewReader(delivery.Payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-IDT-Delivery", delivery.ID)
+		req.Header.Set("X-IDT-Event", string(delivery.Type))
+		req.Header.Set(webhookSignatureHeader, SignWebhook(d.config.Secret, now, delivery.Payload))
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		delivery.ResponseCode = resp.StatusCode
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("subscriber responded with %d", resp.StatusCode)
+		}
+		return nil
+	}()
+
+	switch {
+	case err == nil:
+		delivery.Status = WebhookDeliveryDelivered
+		delivery.NextAttemptAt = time.Time{}
+	case delivery.Attempts >= webhookMaxAttempts:
+		delivery.Status = WebhookDeliveryFailed
+		delivery.Error = err.Error()
+		delivery.NextAttemptAt = time.Time{}
+	default:
+		delivery.Error = err.Error()
+		delivery.NextAttemptAt = now.Add(webhookRetryDelay(delivery.Attempts))
+	}
+	state.SetWebhookDelivery(delivery)
+	return delivery
+}
+
+// Turns the queued writes into deliveries and attempts every delivery that
+// is due. Returns the time of the next due attempt, zero if none is pending.
+func (d *WebhookDispatcher) step(ctx context.Context, state *AppState) time.Time {
+	d.mu.Lock()
+	queued := d.queued
+	d.queued = nil
+	d.mu.Unlock()
+	for _, published := range queued {
+		for _, notification := range d.notifications(state, published) {
+			d.schedule(state, notification)
+		}
+	}
+
+	// Endpoints are attempted concurrently so that a slow subscriber does
+	// not hold up the others. Deliveries to one endpoint stay in order.
+	now := state.currentTime()
+	due := make(map[string][]int)
+	for i, delivery := range d.pending {
+		if !delivery.NextAttemptAt.After(now) {
+			due[delivery.URL] = append(due[delivery.URL], i)
+		}
+	}
+	var wg sync.WaitGroup
+	for _, indexes := range due {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, i := range indexes {
+				d.pending[i] = d.attempt(ctx, state, d.pending[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	pending := d.pending[:0]
+	next := time.Time{}
+	for _, delivery := range d.pending {
+		if delivery.Status != WebhookDeliveryPending {
+			continue
+		}
+		pending = append(pending, delivery)
+		if next.IsZero() || delivery.NextAttemptAt.Before(next) {
+			next = delivery.NextAttemptAt
+		}
+	}
+	d.pending = pending
+	return next
+}
+
+// Picks up the deliveries left pending by a previous run.
+func (d *WebhookDispatcher) resume(state *AppState) {
+	deliveries, err := state.storage.WebhookDeliveries()
+	if err != nil {
+		log.Printf("Error loading webhook deliveries: %v", err)
+		return
+	}
+	for _, delivery := range deliveries {
+		if delivery.Status == WebhookDeliveryPending {
+			d.pending = append(d.pending, delivery)
+		}
+	}
+}
+
+// Delivers notifications until the context is done. Deliveries left
+// pending by a previous run are resumed.
+func (d *WebhookDispatcher) Run(ctx context.Context, state *AppState) {
+	d.resume(state)
+	for {
+		next := d.step(ctx, state)
+		// Without pending deliveries only new writes wake the worker
+		wait := webhookRetryMax
+		if !next.IsZero() {
+			wait = max(next.Sub(state.currentTime()), 0)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-d.wake:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Returns the recorded deliveries, optionally only those with the status.
+func WebhookDeliveriesHandler(state *AppState, status WebhookDeliveryStatus) ([]WebhookDelivery, error) {
+	deliveries, err := state.storage.WebhookDeliveries()
+	if err != nil {
+		return nil, err
+	}
+	if status == "" {
+		return deliveries, nil
+	}
+	filtered := []WebhookDelivery{}
+	for _, delivery := range deliveries {
+		if delivery.Status == status {
+			filtered = append(filtered, delivery)
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the notifications posted to it and responds with the next status
+// code in line, 200 once the list is used up.
+type webhookReceiver struct {
+	t      *testing.T
+	secret []byte
+	now    func() time.Time
+
+	mu            sync.Mutex
+	codes         []int
+	notifications []WebhookNotification
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	if err := VerifyWebhookSignature(r.secret, req.Header.Get(webhookSignatureHeader), body, r.now()); err != nil {
+		r.t.Errorf("signature did not verify: %v", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	code := http.StatusOK
+	if len(r.codes) > 0 {
+		code, r.codes = r.codes[0], r.codes[1:]
+	}
+	if code == http.StatusOK {
+		var notification WebhookNotification
+		if err := json.Unmarshal(body, &notification); err != nil {
+			r.t.Errorf("failed to decode notification: %v", err)
+		}
+		r.notifications = append(r.notifications, notification)
+	}
+	w.WriteHeader(code)
+}
+
+func (r *webhookReceiver) received() []WebhookNotification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]WebhookNotification{}, r.notifications...)
+}
+
+// Creates a state at a fixed time whose writes notify a new receiver of the events.
+func newWebhookTest(t *testing.T, thresholds []int64, events ...WebhookEventType) (*AppState, *WebhookDispatcher, *webhookReceiver, *time.Time) {
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state := NewAppState()
+	state.now = func() time.Time { return now }
+	receiver := &webhookReceiver{t: t, secret: []byte("secret"), now: state.currentTime}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	dispatcher := NewWebhookDispatcher(state, WebhookConfig{
+		Subscriptions:     []WebhookSubscription{{URL: server.URL, Events: events}},
+		Secret:            receiver.secret,
+		BalanceThresholds: thresholds,
+	})
+	return state, dispatcher, receiver, &now
+}
+
+func TestParseWebhookSubscription(t *testing.T) {
+	subscription, err := ParseWebhookSubscription("vouch,tier=https://hooks.example/idt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if subscription.URL != "https://hooks.example/idt" || len(subscription.Events) != 2 || subscription.Events[1] != WebhookEventTier {
+		t.Fatalf("unexpected subscription: %#v", subscription)
+	}
+	for _, value := range []string{"https://hooks.example", "=https://hooks.example", "key=https://hooks.example", "vouch=not a url"} {
+		if _, err := ParseWebhookSubscription(value); err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1704164645, 0)
+	body := []byte(`{"type":"vouch"}`)
+	header := SignWebhook(secret, now, body)
+	if err := VerifyWebhookSignature(secret, header, body, now.Add(time.Minute)); err != nil {
+		t.Fatalf("signature did not verify: %v", err)
+	}
+	if err := VerifyWebhookSignature(secret, header, []byte(`{"type":"proof"}`), now); err == nil {
+		t.Fatal("expected a changed body to fail")
+	}
+	if err := VerifyWebhookSignature([]byte("other"), header, body, now); err == nil {
+		t.Fatal("expected another secret to fail")
+	}
+	if err := VerifyWebhookSignature(secret, header, body, now.Add(time.Hour)); err == nil {
+		t.Fatal("expected an old signature to fail")
+	}
+}
+
+func TestWebhookNotifiesWritesAndScoreChanges(t *testing.T) {
+	state, dispatcher, receiver, now := newWebhookTest(t, []int64{50}, WebhookEventVouch, WebhookEventTier, WebhookEventBalance)
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: *now})
+	state.AddVouch(VouchEvent{From: "alice", To: "bob", Timestamp: *now})
+	dispatcher.step(context.Background(), state)
+
+	counts := make(map[WebhookEventType]int)
+	changes := make(map[string]ScoreChange)
+	for _, notification := range receiver.received() {
+		counts[notification.Type]++
+		if notification.Type == WebhookEventTier {
+			changes[notification.Change.User] = *notification.Change
+		}
+		if notification.Type == WebhookEventBalance && (notification.Change.User != "alice" || *notification.Change.Threshold != 50) {
+			t.Fatalf("unexpected balance notification: %#v", notification.Change)
+		}
+	}
+	// The proof itself is not subscribed to
+	if counts[WebhookEventProof] != 0 || counts[WebhookEventVouch] != 1 || counts[WebhookEventBalance] != 1 {
+		t.Fatalf("unexpected notifications: %v", counts)
+	}
+	if changes["alice"].Tier != TierModeratorVerified || changes["bob"].PreviousTier != TierUnverified || changes["bob"].Tier != TierBasic {
+		t.Fatalf("unexpected tier changes: %#v", changes)
+	}
+
+	deliveries, _ := WebhookDeliveriesHandler(state, WebhookDeliveryDelivered)
+	if len(deliveries) != 4 {
+		t.Fatalf("expected 4 delivered notifications, got %#v", deliveries)
+	}
+}
+
+func TestWebhookNotifiesRenewalsAndSlashesSeparately(t *testing.T) {
+	state, dispatcher, receiver, now := newWebhookTest(t, nil, WebhookEventVouch, WebhookEventRenew, WebhookEventSlash)
+	state.SetProof(ProofEvent{User: "alice", Balance: 1000, Timestamp: *now})
+	if err := VouchHandler(state, "alice", "sig", "nonce", "bob", 0, time.Time{}, 200); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := RenewHandler(state, "alice", "bob", time.Time{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := PunishHandler(state, "bob", 500); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dispatcher.step(context.Background(), state)
+
+	received := receiver.received()
+	if len(received) != 3 {
+		t.Fatalf("expected 3 notifications, got %#v", received)
+	}
+	for i, expected := range []WebhookEventType{WebhookEventVouch, WebhookEventRenew, WebhookEventSlash} {
+		if received[i].Type != expected || received[i].Event.Vouch == nil || received[i].Event.Vouch.To != "bob" {
+			t.Fatalf("expected notification %d to be a %s of the vouch, got %#v", i, expected, received[i])
+		}
+	}
+	if received[2].Event.Vouch.Stake != 100 {
+		t.Fatalf("expected the slashed stake, got %#v", received[2].Event.Vouch)
+	}
+}
+
+func TestWebhookVouchSubscribersSkipRenewalsAndSlashes(t *testing.T) {
+	state, dispatcher, receiver, now := newWebhookTest(t, nil, WebhookEventVouch)
+	state.SetProof(ProofEvent{User: "alice", Balance: 1000, Timestamp: *now})
+	if err := VouchHandler(state, "alice", "sig", "nonce", "bob", 0, time.Time{}, 200); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := RenewHandler(state, "alice", "bob", time.Time{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := PunishHandler(state, "bob", 500); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dispatcher.step(context.Background(), state)
+
+	received := receiver.received()
+	if len(received) != 1 || received[0].Type != WebhookEventVouch {
+		t.Fatalf("expected only the new vouch, got %#v", received)
+	}
+}
+
+func TestAffectedUsersVisitsEachUserOnce(t *testing.T) {
+	state := NewAppState()
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	state.now = func() time.Time { return now }
+	// A dense graph has exponentially many paths within the scoring depth
+	users := []string{"a", "b", "c", "d", "e", "f"}
+	for _, from := range users {
+		for _, to := range users {
+			if from != to {
+				state.AddVouch(VouchEvent{From: from, To: to, Timestamp: now})
+			}
+		}
+	}
+	state.AddVouch(VouchEvent{From: "x", To: "y", Timestamp: now})
+
+	affected := affectedUsers(state, EventFromProof(ProofEvent{User: "a"}))
+	if len(affected) != len(users) {
+		t.Fatalf("expected each connected user once, got %v", affected)
+	}
+}
+
+func TestWebhookDoesNotWaitForSlowEndpoints(t *testing.T) {
+	state, dispatcher, receiver, now := newWebhookTest(t, nil, WebhookEventProof)
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	// Subscribed first, so that it would hold up the other endpoint
+	dispatcher.config.Subscriptions = append([]WebhookSubscription{{URL: slow.URL, Events: []WebhookEventType{WebhookEventProof}}}, dispatcher.config.Subscriptions...)
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: *now})
+
+	// The slow endpoint times out while the other one is delivered to
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	dispatcher.step(ctx, state)
+	if received := receiver.received(); len(received) != 1 {
+		t.Fatalf("expected the fast endpoint to be notified, got %#v", received)
+	}
+	pending, _ := WebhookDeliveriesHandler(state, WebhookDeliveryPending)
+	if len(pending) != 1 || pending[0].URL != slow.URL {
+		t.Fatalf("expected the slow delivery to be retried, got %#v", pending)
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	state, dispatcher, receiver, now := newWebhookTest(t, nil, WebhookEventPenalty)
+	receiver.codes = []int{http.StatusInternalServerError, http.StatusServiceUnavailable}
+	PunishHandler(state, "mallory", 10)
+
+	next := dispatcher.step(context.Background(), state)
+	if !next.Equal(now.Add(webhookRetryBase)) {
+		t.Fatalf("expected a retry after %v, got %v", webhookRetryBase, next)
+	}
+	// Nothing is due before the retry time
+	dispatcher.step(context.Background(), state)
+	deliveries, _ := WebhookDeliveriesHandler(state, WebhookDeliveryPending)
+	if len(deliveries) != 1 || deliveries[0].Attempts != 1 || deliveries[0].ResponseCode != http.StatusInternalServerError {
+		t.Fatalf("unexpected deliveries: %#v", deliveries)
+	}
+
+	*now = next
+	next = dispatcher.step(context.Background(), state)
+	if !next.Equal(now.Add(2 * webhookRetryBase)) {
+		t.Fatalf("expected the delay to double, got %v", next.Sub(*now))
+	}
+
+	// A restarted dispatcher resumes the pending delivery
+	restarted := NewWebhookDispatcher(state, dispatcher.config)
+	restarted.resume(state)
+	*now = next
+	if next := restarted.step(context.Background(), state); !next.IsZero() {
+		t.Fatalf("expected nothing pending, got %v", next)
+	}
+	deliveries, _ = WebhookDeliveriesHandler(state, "")
+	if len(deliveries) != 1 || deliveries[0].Status != WebhookDeliveryDelivered || deliveries[0].Attempts != 3 {
+		t.Fatalf("unexpected deliveries: %#v", deliveries)
+	}
+	received := receiver.received()
+	if len(received) != 1 || received[0].Event.Penalty.User != "mallory" {
+		t.Fatalf("unexpected notifications: %#v", received)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	state, dispatcher, receiver, now := newWebhookTest(t, nil, WebhookEventProof)
+	for range webhookMaxAttempts {
+		receiver.codes = append(receiver.codes, http.StatusInternalServerError)
+	}
+	state.SetProof(ProofEvent{User: "alice", Balance: 100, Timestamp: *now})
+
+	for next := dispatcher.step(context.Background(), state); !next.IsZero(); next = dispatcher.step(context.Background(), state) {
+		*now = next
+	}
+	deliveries, _ := WebhookDeliveriesHandler(state, WebhookDeliveryFailed)
+	if len(deliveries) != 1 || deliveries[0].Attempts != webhookMaxAttempts || deliveries[0].Error == "" {
+		t.Fatalf("unexpected deliveries: %#v", deliveries)
+	}
+}
+
+func TestStorageWebhookDeliveries(t *testing.T) {
+	testStorageImplementations(t, "WebhookDeliveries", func(t *testing.T, storage Storage) {
+		createdAt := time.Date(2024, time.March, 1, 12, 0, 0, 5, time.UTC)
+		first := WebhookDelivery{ID: "a", URL: "https://hooks.example", Type: WebhookEventVouch, Payload: json.RawMessage(`{"id":"n1"}`), Status: WebhookDeliveryPending, CreatedAt: createdAt, NextAttemptAt: createdAt}
+		second := first
+		second.ID = "b"
+		for _, delivery := range []WebhookDelivery{first, second} {
+			if err := storage.SetWebhookDelivery(delivery); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		first.Status, first.Attempts, first.ResponseCode = WebhookDeliveryDelivered, 1, 200
+		first.LastAttemptAt, first.NextAttemptAt = createdAt.Add(time.Second), time.Time{}
+		if err := storage.SetWebhookDelivery(first); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		deliveries, err := storage.WebhookDeliveries()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(deliveries) != 2 || deliveries[0].ID != "a" || deliveries[1].ID != "b" {
+			t.Fatalf("expected the deliveries in order, got %#v", deliveries)
+		}
+		got := deliveries[0]
+		if got.Status != WebhookDeliveryDelivered || got.Attempts != 1 || string(got.Payload) != `{"id":"n1"}` ||
+			!got.CreatedAt.Equal(createdAt) || !got.LastAttemptAt.Equal(first.LastAttemptAt) || !got.NextAttemptAt.IsZero() {
+			t.Fatalf("expected the replaced delivery, got %#v", got)
+		}
+	})
+}